@@ -3,23 +3,30 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rainu/mqtt-shell/internal/broker"
 	"github.com/rainu/mqtt-shell/internal/config"
 	internalIo "github.com/rainu/mqtt-shell/internal/io"
 	"io"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 var ApplicationVersion = "dev"
 var ApplicationCodeRev = "revision"
 
-var mqttReconnectListener interface {
-	OnMqttReconnect()
-}
+const (
+	embeddedBrokerScheme         = "embedded://"
+	defaultEmbeddedBrokerAddress = "127.0.0.1:1883"
+)
 
 func main() {
 	cfg, rc := config.ReadConfig(ApplicationVersion, ApplicationCodeRev)
@@ -31,10 +38,11 @@ func main() {
 
 	interactive := !cfg.NonInteractive
 
-	mqttClient := establishMqtt(cfg)
-
-	var output io.Writer
-	var inputChan chan string
+	var output, statusOutput io.Writer
+	var startShell func() chan string
+	var refreshPrompt func()
+	var setPromptTemplate func(string, func() internalIo.PromptData) error
+	var ask func(string) (string, bool)
 	var subInformer interface {
 		GetSubscriptions() []string
 	}
@@ -44,24 +52,94 @@ func main() {
 	}
 
 	if interactive {
-		shell, err := internalIo.NewShell(cfg.Prompt, cfg.HistoryFile, macroManager, func(s string) []string {
+		shell, err := internalIo.NewShell("", cfg.HistoryFile, macroManager, func(s string) []string {
 			return subInformer.GetSubscriptions()
 		})
 		if err != nil {
 			log.Fatal(err)
 		}
 		output = shell
-		inputChan = shell.Start()
+		statusOutput = shell
+		startShell = shell.Start
+		refreshPrompt = shell.RefreshPrompt
+		setPromptTemplate = shell.SetPromptTemplate
+		ask = shell.Ask
 	} else {
 		//non interactive mean that there is no shell open
-		inputChan = make(chan string)
 		output = os.Stdout
+		statusOutput = os.Stderr
 
 		//reacting to signals (interrupt)
 		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
 	}
 	macroManager.Output = output
 
+	embeddedBroker := startEmbeddedBroker(cfg, statusOutput)
+	if embeddedBroker != nil {
+		defer embeddedBroker.Close()
+	}
+
+	status := internalIo.NewConnectionStatus(statusOutput)
+	mqttClient := newMqttClient(cfg, status, statusOutput)
+
+	//the processor must exist before the connection is established: it will be informed about each (re-)connect
+	processor := internalIo.NewProcessor(output, mqttClient)
+	processor.ClientFactory = func(brokerUri string) (MQTT.Client, error) {
+		if strings.HasPrefix(brokerUri, embeddedBrokerScheme) {
+			if embeddedBroker == nil {
+				return nil, errors.New("the embedded broker is not running")
+			}
+			brokerUri = "tcp://" + embeddedBroker.Addr().String()
+		}
+		return connectMqtt(cfg, brokerUri)
+	}
+	processor.Execution = internalIo.ExecutionOptions{
+		Workers:   cfg.ChainWorkers,
+		Ordered:   cfg.ChainOrdered,
+		Timeout:   cfg.ChainTimeout,
+		QueueSize: cfg.ChainQueueSize,
+		Drop:      cfg.ChainQueuePolicy == "drop",
+	}
+	processor.Status = status
+	processor.ForwardQos = byte(cfg.SubscribeQOS)
+	processor.Guard = &internalIo.PublishGuard{
+		Protected:     cfg.Protected,
+		ConfirmTopics: cfg.ConfirmTopics,
+		BlockTopics:   cfg.BlockTopics,
+	}
+	//only the user of the interactive shell can confirm the guarded publishes
+	processor.Ask = ask
+	subInformer = processor
+	status.SetReconnectListener(processor)
+
+	if interactive {
+		err := setPromptTemplate(promptTemplate(cfg), func() internalIo.PromptData {
+			return internalIo.PromptData{
+				Env:           cfg.Environment,
+				Protected:     cfg.Protected,
+				Broker:        brokerHost(status.Broker()),
+				ClientId:      cfg.ClientId,
+				State:         status.State(),
+				Subscriptions: len(processor.GetSubscriptions()),
+				Jobs:          processor.RunningJobs(),
+			}
+		})
+		if err != nil {
+			log.Fatal("Unable to parse prompt: ", err)
+		}
+		status.OnChange(refreshPrompt)
+		processor.OnChange = refreshPrompt
+	}
+
+	establishMqtt(cfg, mqttClient, status, statusOutput)
+
+	//the shell must be started after the connection is established: otherwise a fatal connection error
+	//would leave the terminal in raw mode
+	inputChan := make(chan string)
+	if interactive {
+		inputChan = startShell()
+	}
+
 	if err := macroManager.ValidateAndInitMacros(); err != nil {
 		log.Fatal(err)
 	}
@@ -83,10 +161,6 @@ func main() {
 		}
 	}()
 
-	processor := internalIo.NewProcessor(output, mqttClient)
-	subInformer = processor
-	mqttReconnectListener = processor
-
 	//process loop
 	processor.Process(inputChan)
 
@@ -102,9 +176,67 @@ func applyColorBlacklist(cfg *config.Config) {
 	}
 }
 
-func establishMqtt(cfg *config.Config) MQTT.Client {
+// startEmbeddedBroker starts the embedded broker if one of the broker uris has the form embedded://[host:port]. This
+// broker uri of the config will be replaced by the address of the embedded broker.
+func startEmbeddedBroker(cfg *config.Config, out io.Writer) *broker.Server {
+	var server *broker.Server
+
+	for i, brokerUri := range cfg.Broker {
+		if !strings.HasPrefix(brokerUri, embeddedBrokerScheme) {
+			continue
+		}
+		if server != nil {
+			log.Fatal("Only one embedded broker can be started!")
+		}
+
+		address := strings.TrimPrefix(brokerUri, embeddedBrokerScheme)
+		if address == "" {
+			address = defaultEmbeddedBrokerAddress
+		}
+
+		var err error
+		server, err = broker.Listen(address)
+		if err != nil {
+			log.Fatal("Unable to start embedded broker: ", err)
+		}
+		cfg.Broker[i] = "tcp://" + server.Addr().String()
+		out.Write([]byte("Embedded broker is listening on " + cfg.Broker[i] + "\n"))
+	}
+
+	return server
+}
+
+// protectedMarker will be shown in front of the prompt of a protected environment (white on red)
+const protectedMarker = "{{if .Protected}}\033[1;37;41m {{or .Env \"protected\"}} \033[0m {{end}}"
+
+// promptTemplate returns the template of the prompt. If multiple brokers are configured, the connected one will be
+// shown in front of the prompt (except the prompt contains the broker already). A protected environment will be marked
+// in front of the prompt (except the prompt handles it already).
+func promptTemplate(cfg *config.Config) string {
+	prompt := cfg.Prompt
+	if len(cfg.Broker) > 1 && !strings.Contains(prompt, ".Broker") {
+		prompt = "{{with .Broker}}{{.}} {{end}}" + prompt
+	}
+	if cfg.Protected && !strings.Contains(prompt, ".Protected") {
+		prompt = protectedMarker + prompt
+	}
+	return prompt
+}
+
+// brokerHost returns the host (and port) of the given broker uri
+func brokerHost(brokerUri string) string {
+	u, err := url.Parse(brokerUri)
+	if err != nil || u.Host == "" {
+		return brokerUri
+	}
+	return u.Host
+}
+
+func newMqttOptions(cfg *config.Config, brokers ...string) *MQTT.ClientOptions {
 	opts := MQTT.NewClientOptions()
-	opts.AddBroker(cfg.Broker)
+	for _, broker := range brokers {
+		opts.AddBroker(broker)
+	}
 	opts.SetClientID(cfg.ClientId)
 	if cfg.Username != "" {
 		opts.SetUsername(cfg.Username)
@@ -129,29 +261,59 @@ func establishMqtt(cfg *config.Config) MQTT.Client {
 		})
 	}
 
-	opts.SetAutoReconnect(true)
 	opts.SetCleanSession(cfg.CleanSession)
+	opts.SetConnectTimeout(cfg.ConnectTimeout)
+	opts.SetKeepAlive(cfg.KeepAlive)
+	opts.SetAutoReconnect(cfg.AutoReconnect)
+	opts.SetMaxReconnectInterval(cfg.MaxReconnectInterval)
+
+	return opts
+}
 
-	firstConnect := true
-	opts.SetOnConnectHandler(func(_ MQTT.Client) {
-		if firstConnect {
-			println("Successfully connected to mqtt broker.")
-		} else {
-			println("Successfully re-connected to mqtt broker.")
+// connectMqtt establishes an additional connection to the given broker
+func connectMqtt(cfg *config.Config, broker string) (MQTT.Client, error) {
+	opts := newMqttOptions(cfg, broker)
+	//the client id must be unique on the broker
+	opts.SetClientID(cfg.ClientId + "-" + strconv.FormatInt(time.Now().UnixNano(), 36))
+
+	client := MQTT.NewClient(opts)
+	if t := client.Connect(); !t.Wait() || t.Error() != nil {
+		return nil, t.Error()
+	}
+	return client, nil
+}
+
+// newMqttClient creates the client of the main connection
+func newMqttClient(cfg *config.Config, status *internalIo.ConnectionStatus, out io.Writer) MQTT.Client {
+	opts := newMqttOptions(cfg, cfg.Broker...)
+	opts.SetConnectRetry(cfg.ConnectRetry)
+	opts.SetConnectRetryInterval(cfg.ConnectRetryInterval)
+
+	if cfg.SessionStore == "file" {
+		if cfg.CleanSession {
+			out.Write([]byte("The file session store has no effect with a clean session (use -cs=false).\n"))
 		}
-		if mqttReconnectListener != nil {
-			mqttReconnectListener.OnMqttReconnect()
+		if err := os.MkdirAll(cfg.SessionStoreDirectory, 0700); err != nil {
+			log.Fatal("Unable to create session store directory: ", err)
 		}
+		opts.SetStore(MQTT.NewFileStore(cfg.SessionStoreDirectory))
+	}
+	status.Observe(opts)
+	internalIo.OrderBrokers(opts, cfg.BrokerOrder)
 
-		firstConnect = false
-	})
-	opts.SetConnectionLostHandler(func(_ MQTT.Client, err error) {
-		println("Connection to broker lost. Reconnecting...")
-	})
+	return MQTT.NewClient(opts)
+}
 
-	client := MQTT.NewClient(opts)
+// establishMqtt establishes the main connection. If the initial connection should be retried, the interactive shell
+// will not wait for the connection: the commands can be entered in the meantime.
+func establishMqtt(cfg *config.Config, client MQTT.Client, status *internalIo.ConnectionStatus, out io.Writer) {
+	if cfg.ConnectRetry && !cfg.NonInteractive {
+		out.Write([]byte("Connecting to mqtt broker...\n"))
+		client.Connect()
+		return
+	}
 	if t := client.Connect(); !t.Wait() || t.Error() != nil {
 		log.Fatal(t.Error())
 	}
-	return client
+	status.Connected()
 }
@@ -0,0 +1,598 @@
+// Package broker contains a minimal MQTT (3.1 and 3.1.1) broker which can be embedded into the shell. It is intended
+// for local (offline) testing: there is no authentication, no TLS and the sessions are held in memory only.
+package broker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/rainu/mqtt-shell/internal/topic"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	//the maximum count of unacknowledged (or queued) messages per session
+	maxInflight = 1000
+
+	//the maximum count of packets which are waiting to be written to a connection
+	maxQueuedPackets = 1000
+
+	connectTimeout = 10 * time.Second
+)
+
+var errConnectionClosed = errors.New("connection closed")
+
+// CONNACK return codes
+const (
+	connackAccepted           = 0
+	connackInvalidProtocol    = 1
+	connackIdentifierRejected = 2
+)
+
+// message is a published application message
+type message struct {
+	topic    string
+	payload  []byte
+	qos      byte
+	retained bool
+}
+
+// outbound is a message which is sent (or will be sent) to a client with QoS 1 or 2
+type outbound struct {
+	id      uint16
+	message message
+
+	//true if the message was already sent to the client (it will be marked as duplicate on redelivery)
+	sent bool
+
+	//true if a QoS 2 message was received by the client (PUBREC) and is waiting for its PUBCOMP
+	released bool
+}
+
+type session struct {
+	mutex    sync.Mutex
+	clientId string
+	clean    bool
+	conn     *connection
+
+	subscriptions map[string]byte
+	nextId        uint16
+	inflight      []outbound
+
+	//the packet identifiers of the received QoS 2 messages which are waiting for their PUBREL
+	received map[uint16]bool
+}
+
+// connection is a client connection. The packets are written by its own routine: so the sessions can send packets
+// while they are locked without waiting for (slow) clients.
+type connection struct {
+	net.Conn
+
+	mutex  sync.Mutex
+	closed bool
+	queue  chan []byte
+	done   chan struct{}
+}
+
+func newConnection(conn net.Conn) *connection {
+	c := &connection{
+		Conn:  conn,
+		queue: make(chan []byte, maxQueuedPackets),
+		done:  make(chan struct{}),
+	}
+	go c.writePackets()
+
+	return c
+}
+
+func (c *connection) writePackets() {
+	defer close(c.done)
+
+	failed := false
+	for data := range c.queue {
+		if failed {
+			continue
+		}
+		if _, err := c.Write(data); err != nil {
+			//the reading routine will notice the closed connection
+			failed = true
+			c.Conn.Close()
+		}
+	}
+}
+
+// write queues the packet. If the client is too slow (the queue is full) the connection will be closed.
+func (c *connection) write(p packet) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return errConnectionClosed
+	}
+	select {
+	case c.queue <- p.encode():
+		return nil
+	default:
+		c.Conn.Close()
+		return errConnectionClosed
+	}
+}
+
+// shutdown writes the queued packets and closes the connection
+func (c *connection) shutdown() {
+	c.mutex.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.mutex.Unlock()
+
+	//a client which does not read anymore must not block the shutdown
+	c.SetWriteDeadline(time.Now().Add(connectTimeout))
+	<-c.done
+	c.Conn.Close()
+}
+
+// Server is an embedded mqtt broker
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mutex       sync.Mutex
+	closed      bool
+	sessions    map[string]*session
+	connections map[*connection]bool
+	retained    map[string]message
+	generatedId uint64
+}
+
+// Listen starts a broker which listens on the given tcp address (ex: 127.0.0.1:1883)
+func Listen(address string) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		listener:    listener,
+		sessions:    map[string]*session{},
+		connections: map[*connection]bool{},
+		retained:    map[string]message{},
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+// Addr returns the address on which the broker listens
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the broker and closes all client connections
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	err := s.listener.Close()
+	for conn := range s.connections {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+
+			if closed {
+				return
+			}
+			//temporary errors (such as too many open files)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+
+		c := newConnection(conn)
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			c.shutdown()
+			return
+		}
+		s.connections[c] = true
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mutex.Lock()
+				delete(s.connections, c)
+				s.mutex.Unlock()
+			}()
+
+			s.handle(c)
+		}()
+	}
+}
+
+// connectRequest is the content of a CONNECT packet
+type connectRequest struct {
+	clientId  string
+	clean     bool
+	keepAlive time.Duration
+	will      *message
+}
+
+func decodeConnect(p packet) (connectRequest, byte, error) {
+	req := connectRequest{}
+	r := &packetReader{data: p.body}
+
+	protocol := r.string()
+	level := r.byte()
+	flags := r.byte()
+	req.keepAlive = time.Duration(r.uint16()) * time.Second
+	req.clientId = r.string()
+	if r.err != nil {
+		return req, 0, r.err
+	}
+	if !(protocol == "MQTT" && level == 4) && !(protocol == "MQIsdp" && level == 3) {
+		return req, connackInvalidProtocol, nil
+	}
+
+	req.clean = flags&0x02 != 0
+	if flags&0x04 != 0 {
+		req.will = &message{
+			qos:      (flags >> 3) & 0x03,
+			retained: flags&0x20 != 0,
+		}
+		req.will.topic = r.string()
+		req.will.payload = append([]byte{}, r.bytes()...)
+		if req.will.qos > 2 || !validTopicName(req.will.topic) {
+			return req, 0, errMalformedPacket
+		}
+	}
+	//there is no authentication: username and password will be ignored
+
+	if req.clientId == "" && !req.clean {
+		return req, connackIdentifierRejected, nil
+	}
+	return req, connackAccepted, r.err
+}
+
+func (s *Server) handle(c *connection) {
+	defer c.shutdown()
+
+	reader := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(connectTimeout))
+
+	p, err := readPacket(reader)
+	if err != nil || p.kind() != packetConnect {
+		return
+	}
+	req, rc, err := decodeConnect(p)
+	if err != nil {
+		return
+	}
+	if rc != connackAccepted {
+		c.write(packet{header: packetConnack << 4, body: []byte{0, rc}})
+		return
+	}
+
+	sess, present := s.openSession(&req, c)
+	sessionPresent := byte(0)
+	if present {
+		sessionPresent = 1
+	}
+	if c.write(packet{header: packetConnack << 4, body: []byte{sessionPresent, connackAccepted}}) != nil {
+		s.closeSession(sess, c, req.will)
+		return
+	}
+	sess.resend()
+
+	for {
+		if req.keepAlive > 0 {
+			c.SetReadDeadline(time.Now().Add(req.keepAlive * 3 / 2))
+		} else {
+			c.SetReadDeadline(time.Time{})
+		}
+
+		p, err := readPacket(reader)
+		if err != nil {
+			break
+		}
+		if p.kind() == packetDisconnect {
+			//the will message must not be published on a regular disconnect
+			req.will = nil
+			break
+		}
+		if err := s.handlePacket(sess, c, p); err != nil {
+			break
+		}
+	}
+
+	s.closeSession(sess, c, req.will)
+}
+
+// openSession returns the (existing) session of the client. Returns true if the session was already present.
+func (s *Server) openSession(req *connectRequest, c *connection) (*session, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if req.clientId == "" {
+		s.generatedId++
+		req.clientId = fmt.Sprintf("auto-%d", s.generatedId)
+	}
+
+	sess, present := s.sessions[req.clientId]
+	if present {
+		sess.mutex.Lock()
+		if sess.conn != nil {
+			//the new connection takes over the session
+			sess.conn.Close()
+		}
+		sess.mutex.Unlock()
+	}
+	if !present || req.clean {
+		sess = &session{
+			clientId:      req.clientId,
+			subscriptions: map[string]byte{},
+			received:      map[uint16]bool{},
+		}
+		s.sessions[req.clientId] = sess
+		present = false
+	}
+
+	sess.mutex.Lock()
+	sess.clean = req.clean
+	sess.conn = c
+	sess.mutex.Unlock()
+
+	return sess, present
+}
+
+func (s *Server) closeSession(sess *session, c *connection, will *message) {
+	if will != nil {
+		s.publish(*will)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	if sess.conn != c {
+		//the session was taken over by another connection
+		return
+	}
+	sess.conn = nil
+	if sess.clean && s.sessions[sess.clientId] == sess {
+		delete(s.sessions, sess.clientId)
+	}
+}
+
+func (s *Server) handlePacket(sess *session, c *connection, p packet) error {
+	r := &packetReader{data: p.body}
+
+	switch p.kind() {
+	case packetPublish:
+		m, id, err := decodePublish(p)
+		if err != nil {
+			return err
+		}
+		switch m.qos {
+		case 0:
+			s.publish(m)
+		case 1:
+			s.publish(m)
+			return c.write(ackPacket(packetPuback<<4, id))
+		case 2:
+			sess.mutex.Lock()
+			duplicate := sess.received[id]
+			sess.received[id] = true
+			sess.mutex.Unlock()
+
+			if !duplicate {
+				s.publish(m)
+			}
+			return c.write(ackPacket(packetPubrec<<4, id))
+		}
+	case packetPubrel:
+		id := r.uint16()
+		sess.mutex.Lock()
+		delete(sess.received, id)
+		sess.mutex.Unlock()
+		return c.write(ackPacket(packetPubcomp<<4, id))
+	case packetPuback, packetPubcomp:
+		sess.acknowledge(r.uint16())
+	case packetPubrec:
+		id := r.uint16()
+		sess.release(id)
+		return c.write(ackPacket(packetPubrel<<4|0x02, id))
+	case packetSubscribe:
+		return s.subscribe(sess, c, r)
+	case packetUnsubscribe:
+		id := r.uint16()
+		sess.mutex.Lock()
+		for r.err == nil && len(r.data) > 0 {
+			delete(sess.subscriptions, r.string())
+		}
+		sess.mutex.Unlock()
+		if r.err != nil {
+			return r.err
+		}
+		return c.write(ackPacket(packetUnsuback<<4, id))
+	case packetPingreq:
+		return c.write(packet{header: packetPingresp << 4})
+	default:
+		return errMalformedPacket
+	}
+	return r.err
+}
+
+func (s *Server) subscribe(sess *session, c *connection, r *packetReader) error {
+	id := r.uint16()
+
+	var filters []string
+	granted := appendUint16(nil, id)
+	for r.err == nil && len(r.data) > 0 {
+		filter, qos := r.string(), r.byte()
+		if !validTopicFilter(filter) || qos > 2 {
+			granted = append(granted, 0x80)
+			continue
+		}
+
+		sess.mutex.Lock()
+		sess.subscriptions[filter] = qos
+		sess.mutex.Unlock()
+
+		filters = append(filters, filter)
+		granted = append(granted, qos)
+	}
+	if r.err != nil || len(granted) == 2 {
+		return errMalformedPacket
+	}
+	if err := c.write(packet{header: packetSuback << 4, body: granted}); err != nil {
+		return err
+	}
+
+	//deliver the retained messages
+	s.mutex.Lock()
+	var retained []message
+	for _, m := range s.retained {
+		for _, filter := range filters {
+			if topic.Matches(filter, m.topic) {
+				retained = append(retained, m)
+				break
+			}
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, m := range retained {
+		sess.deliver(m)
+	}
+	return nil
+}
+
+// publish routes the message to all matching subscriptions
+func (s *Server) publish(m message) {
+	s.mutex.Lock()
+	if m.retained {
+		if len(m.payload) == 0 {
+			delete(s.retained, m.topic)
+		} else {
+			s.retained[m.topic] = m
+		}
+	}
+
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mutex.Unlock()
+
+	//the retain flag will only be set for messages which are sent because of a new subscription
+	m.retained = false
+	for _, sess := range sessions {
+		sess.deliver(m)
+	}
+}
+
+// deliver sends the message to the client if it matches one of its subscriptions (with the highest matching QoS)
+func (sess *session) deliver(m message) {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	matched := false
+	var qos byte
+	for filter, subQos := range sess.subscriptions {
+		if topic.Matches(filter, m.topic) {
+			matched = true
+			if subQos > qos {
+				qos = subQos
+			}
+		}
+	}
+	if !matched {
+		return
+	}
+	if m.qos < qos {
+		qos = m.qos
+	}
+	m.qos = qos
+
+	if qos == 0 {
+		if sess.conn != nil {
+			sess.conn.write(publishPacket(m, 0, false))
+		}
+		return
+	}
+	if len(sess.inflight) >= maxInflight {
+		//the client is too slow (or offline for too long)
+		return
+	}
+
+	sess.nextId++
+	if sess.nextId == 0 {
+		sess.nextId = 1
+	}
+	o := outbound{id: sess.nextId, message: m, sent: sess.conn != nil}
+	sess.inflight = append(sess.inflight, o)
+	if o.sent {
+		sess.conn.write(publishPacket(m, o.id, false))
+	}
+}
+
+// resend sends all unacknowledged messages again (after a reconnect)
+func (sess *session) resend() {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	for i, o := range sess.inflight {
+		if o.released {
+			sess.conn.write(ackPacket(packetPubrel<<4|0x02, o.id))
+		} else {
+			sess.conn.write(publishPacket(o.message, o.id, o.sent))
+			sess.inflight[i].sent = true
+		}
+	}
+}
+
+func (sess *session) acknowledge(id uint16) {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	for i, o := range sess.inflight {
+		if o.id == id {
+			sess.inflight = append(sess.inflight[:i], sess.inflight[i+1:]...)
+			return
+		}
+	}
+}
+
+func (sess *session) release(id uint16) {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+
+	for i := range sess.inflight {
+		if sess.inflight[i].id == id {
+			sess.inflight[i].released = true
+			return
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package broker
+
+import (
+	"bufio"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func startTestBroker(t *testing.T) *Server {
+	server, err := Listen("127.0.0.1:0")
+	assert.NoError(t, err)
+	return server
+}
+
+func connectTestClient(t *testing.T, server *Server, clientId string, modify ...func(*mqtt.ClientOptions)) mqtt.Client {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker("tcp://" + server.Addr().String())
+	opts.SetClientID(clientId)
+	opts.SetAutoReconnect(false)
+	for _, m := range modify {
+		m(opts)
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	assert.True(t, token.WaitTimeout(time.Second))
+	assert.NoError(t, token.Error())
+	return client
+}
+
+// receive subscribes the filter and returns the channel of the received messages
+func receive(t *testing.T, client mqtt.Client, filter string, qos byte) chan mqtt.Message {
+	messages := make(chan mqtt.Message, 100)
+	token := client.Subscribe(filter, qos, func(_ mqtt.Client, m mqtt.Message) {
+		messages <- m
+	})
+	assert.True(t, token.WaitTimeout(time.Second))
+	assert.NoError(t, token.Error())
+	return messages
+}
+
+func expectMessage(t *testing.T, messages chan mqtt.Message) mqtt.Message {
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func expectNoMessage(t *testing.T, messages chan mqtt.Message) {
+	select {
+	case m := <-messages:
+		t.Fatalf("unexpected message received: %s", m.Topic())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServer_publishSubscribe(t *testing.T) {
+	server := startTestBroker(t)
+	defer server.Close()
+
+	subscriber := connectTestClient(t, server, "subscriber")
+	defer subscriber.Disconnect(0)
+	publisher := connectTestClient(t, server, "publisher")
+	defer publisher.Disconnect(0)
+
+	messages := receive(t, subscriber, "a/+/c", 1)
+
+	for _, qos := range []byte{0, 1, 2} {
+		token := publisher.Publish("a/b/c", qos, false, []byte{'0' + qos})
+		assert.True(t, token.WaitTimeout(time.Second))
+		assert.NoError(t, token.Error())
+
+		m := expectMessage(t, messages)
+		assert.Equal(t, "a/b/c", m.Topic())
+		assert.Equal(t, []byte{'0' + qos}, m.Payload())
+		assert.False(t, m.Retained())
+
+		//the qos will be downgraded to the qos of the subscription
+		if qos > 1 {
+			assert.Equal(t, byte(1), m.Qos())
+		} else {
+			assert.Equal(t, qos, m.Qos())
+		}
+	}
+
+	publisher.Publish("a/b/d", 1, false, "other").Wait()
+	expectNoMessage(t, messages)
+
+	assert.True(t, subscriber.Unsubscribe("a/+/c").WaitTimeout(time.Second))
+	publisher.Publish("a/b/c", 1, false, "unsubscribed").Wait()
+	expectNoMessage(t, messages)
+}
+
+func TestServer_retained(t *testing.T) {
+	server := startTestBroker(t)
+	defer server.Close()
+
+	client := connectTestClient(t, server, "client")
+	defer client.Disconnect(0)
+
+	client.Publish("config/a", 1, true, "A").Wait()
+	client.Publish("config/b", 1, true, "B").Wait()
+	client.Publish("config/b", 1, true, "").Wait() //clears the retained message
+
+	messages := receive(t, client, "config/#", 2)
+	m := expectMessage(t, messages)
+	assert.Equal(t, "config/a", m.Topic())
+	assert.Equal(t, "A", string(m.Payload()))
+	assert.True(t, m.Retained())
+	expectNoMessage(t, messages)
+}
+
+func TestServer_will(t *testing.T) {
+	server := startTestBroker(t)
+	defer server.Close()
+
+	observer := connectTestClient(t, server, "observer")
+	defer observer.Disconnect(0)
+	messages := receive(t, observer, "status/#", 0)
+
+	gone := connectTestClient(t, server, "gone", func(opts *mqtt.ClientOptions) {
+		opts.SetWill("status/gone", "offline", 0, false)
+	})
+	gone.Disconnect(250) //wait until the DISCONNECT packet is sent
+	expectNoMessage(t, messages)
+
+	//a second client with the same id takes over the session: the will of the first one will be published
+	connectTestClient(t, server, "dying", func(opts *mqtt.ClientOptions) {
+		opts.SetWill("status/dying", "offline", 0, false)
+	})
+	takeover := connectTestClient(t, server, "dying")
+	defer takeover.Disconnect(0)
+
+	m := expectMessage(t, messages)
+	assert.Equal(t, "status/dying", m.Topic())
+	assert.Equal(t, "offline", string(m.Payload()))
+}
+
+func TestServer_persistentSession(t *testing.T) {
+	server := startTestBroker(t)
+	defer server.Close()
+
+	persistent := func(opts *mqtt.ClientOptions) {
+		opts.SetCleanSession(false)
+	}
+
+	client := connectTestClient(t, server, "persistent", persistent)
+	receive(t, client, "queued/#", 1)
+	client.Disconnect(0)
+
+	publisher := connectTestClient(t, server, "publisher")
+	defer publisher.Disconnect(0)
+	publisher.Publish("queued/1", 1, false, "1").Wait()
+	publisher.Publish("queued/0", 0, false, "0").Wait()
+	publisher.Publish("queued/2", 2, false, "2").Wait()
+
+	//the messages (with qos > 0) are queued until the client comes back
+	messages := make(chan mqtt.Message, 10)
+	client = connectTestClient(t, server, "persistent", persistent, func(opts *mqtt.ClientOptions) {
+		opts.SetDefaultPublishHandler(func(_ mqtt.Client, m mqtt.Message) {
+			messages <- m
+		})
+	})
+	defer client.Disconnect(0)
+
+	assert.Equal(t, "queued/1", expectMessage(t, messages).Topic())
+	assert.Equal(t, "queued/2", expectMessage(t, messages).Topic())
+	expectNoMessage(t, messages)
+}
+
+func TestServer_invalidProtocol(t *testing.T) {
+	server := startTestBroker(t)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	//mqtt v5
+	body := appendString(nil, "MQTT")
+	body = append(body, 5, 0x02, 0, 60)
+	body = appendString(body, "client")
+	conn.Write(packet{header: packetConnect << 4, body: body}.encode())
+
+	connack, err := readPacket(bufio.NewReader(conn))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(packetConnack), connack.kind())
+	assert.Equal(t, []byte{0, connackInvalidProtocol}, connack.body)
+}
+
+func TestServer_slowClient(t *testing.T) {
+	server := startTestBroker(t)
+	defer server.Close()
+
+	//a client which subscribes but does not read anything
+	conn, err := net.Dial("tcp", server.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, 0x02, 0, 0)
+	body = appendString(body, "slow")
+	conn.Write(packet{header: packetConnect << 4, body: body}.encode())
+
+	body = appendUint16(nil, 1)
+	body = appendString(body, "flood/#")
+	body = append(body, 0)
+	conn.Write(packet{header: packetSubscribe<<4 | 0x02, body: body}.encode())
+
+	subscriber := connectTestClient(t, server, "subscriber")
+	defer subscriber.Disconnect(0)
+	messages := receive(t, subscriber, "done", 1)
+
+	publisher := connectTestClient(t, server, "publisher")
+	defer publisher.Disconnect(0)
+
+	//much more than the socket buffers and the queue of the slow client can hold
+	payload := make([]byte, 16*1024)
+	for i := 0; i < 2*maxQueuedPackets; i++ {
+		publisher.Publish("flood/data", 0, false, payload)
+	}
+	token := publisher.Publish("done", 1, false, "done")
+	assert.True(t, token.WaitTimeout(5*time.Second))
+
+	select {
+	case m := <-messages:
+		assert.Equal(t, "done", m.Topic())
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow client must not block the other clients")
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	server := startTestBroker(t)
+
+	lost := make(chan error, 1)
+	connectTestClient(t, server, "client", func(opts *mqtt.ClientOptions) {
+		opts.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			lost <- err
+		})
+	})
+
+	assert.NoError(t, server.Close())
+
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("the client connection should be closed")
+	}
+}
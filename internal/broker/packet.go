@@ -0,0 +1,202 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// maxPacketSize is the maximum size of a packet body which will be accepted (the maximum of the remaining length)
+const maxPacketSize = 268435455
+
+var errMalformedPacket = errors.New("malformed packet")
+
+// packet is a raw mqtt control packet
+type packet struct {
+	header byte
+	body   []byte
+}
+
+func (p packet) kind() byte {
+	return p.header >> 4
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, errMalformedPacket
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+
+	if length > maxPacketSize {
+		return packet{}, errMalformedPacket
+	}
+
+	//the body will grow with the received data: a wrong length must not allocate the whole size in advance
+	body := &bytes.Buffer{}
+	if _, err := io.CopyN(body, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return packet{}, err
+	}
+	return packet{header: header, body: body.Bytes()}, nil
+}
+
+// encode returns the wire format of the packet (fixed header + body)
+func (p packet) encode() []byte {
+	buf := make([]byte, 0, 5+len(p.body))
+	buf = append(buf, p.header)
+
+	length := len(p.body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(buf, p.body...)
+}
+
+// packetReader reads the fields of a packet body
+type packetReader struct {
+	data []byte
+	err  error
+}
+
+func (r *packetReader) byte() byte {
+	if r.err != nil || len(r.data) < 1 {
+		r.err = errMalformedPacket
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *packetReader) uint16() uint16 {
+	if r.err != nil || len(r.data) < 2 {
+		r.err = errMalformedPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.data)
+	r.data = r.data[2:]
+	return v
+}
+
+func (r *packetReader) bytes() []byte {
+	length := int(r.uint16())
+	if r.err != nil || len(r.data) < length {
+		r.err = errMalformedPacket
+		return nil
+	}
+	v := r.data[:length]
+	r.data = r.data[length:]
+	return v
+}
+
+func (r *packetReader) string() string {
+	return string(r.bytes())
+}
+
+func (r *packetReader) rest() []byte {
+	v := r.data
+	r.data = nil
+	return v
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(appendUint16(buf, uint16(len(s))), s...)
+}
+
+// ackPacket creates a packet which contains only the packet identifier (PUBACK, PUBREC, PUBREL, PUBCOMP, UNSUBACK)
+func ackPacket(header byte, id uint16) packet {
+	return packet{header: header, body: appendUint16(nil, id)}
+}
+
+func publishPacket(m message, id uint16, duplicate bool) packet {
+	header := byte(packetPublish<<4) | m.qos<<1
+	if m.retained {
+		header |= 0x01
+	}
+	if duplicate {
+		header |= 0x08
+	}
+
+	body := make([]byte, 0, 2+len(m.topic)+2+len(m.payload))
+	body = appendString(body, m.topic)
+	if m.qos > 0 {
+		body = appendUint16(body, id)
+	}
+	body = append(body, m.payload...)
+
+	return packet{header: header, body: body}
+}
+
+// decodePublish decodes a PUBLISH packet. Returns the message and the packet identifier.
+func decodePublish(p packet) (message, uint16, error) {
+	m := message{
+		qos:      (p.header >> 1) & 0x03,
+		retained: p.header&0x01 != 0,
+	}
+	if m.qos > 2 {
+		return m, 0, errMalformedPacket
+	}
+
+	r := &packetReader{data: p.body}
+	m.topic = r.string()
+
+	var id uint16
+	if m.qos > 0 {
+		id = r.uint16()
+	}
+	m.payload = append([]byte{}, r.rest()...)
+
+	if r.err == nil && !validTopicName(m.topic) {
+		r.err = errMalformedPacket
+	}
+	return m, id, r.err
+}
@@ -0,0 +1,31 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestReadPacket(t *testing.T) {
+	p, err := readPacket(bufio.NewReader(bytes.NewReader(packet{header: packetPublish << 4, body: []byte("body")}.encode())))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(packetPublish), p.kind())
+	assert.Equal(t, []byte("body"), p.body)
+
+	p, err = readPacket(bufio.NewReader(bytes.NewReader([]byte{packetPingreq << 4, 0})))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(packetPingreq), p.kind())
+	assert.Empty(t, p.body)
+}
+
+func TestReadPacket_invalidLength(t *testing.T) {
+	//the remaining length must not be encoded with more than 4 bytes
+	_, err := readPacket(bufio.NewReader(bytes.NewReader([]byte{packetPublish << 4, 0xff, 0xff, 0xff, 0xff, 0x7f})))
+	assert.Equal(t, errMalformedPacket, err)
+
+	//the maximum length is announced but the body is missing
+	_, err = readPacket(bufio.NewReader(bytes.NewReader([]byte{packetPublish << 4, 0xff, 0xff, 0xff, 0x7f, 'a'})))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
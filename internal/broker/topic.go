@@ -0,0 +1,26 @@
+package broker
+
+import "strings"
+
+// validTopicName checks if the topic can be published (no wildcards)
+func validTopicName(topic string) bool {
+	return topic != "" && !strings.ContainsAny(topic, "+#")
+}
+
+// validTopicFilter checks if the wildcards of the filter are used correctly
+func validTopicFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,22 @@
+package broker
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidTopicFilter(t *testing.T) {
+	for _, filter := range []string{"a", "a/b", "+", "#", "a/+/c", "a/#", "+/+/#"} {
+		assert.True(t, validTopicFilter(filter), filter)
+	}
+	for _, filter := range []string{"", "a#", "a/#/c", "a/b+", "#/a"} {
+		assert.False(t, validTopicFilter(filter), filter)
+	}
+}
+
+func TestValidTopicName(t *testing.T) {
+	assert.True(t, validTopicName("a/b"))
+	assert.False(t, validTopicName(""))
+	assert.False(t, validTopicName("a/+"))
+	assert.False(t, validTopicName("a/#"))
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"log"
+	"net/url"
 	"os"
 	"path"
 	"strconv"
+	"time"
 )
 
 func ReadConfig(version, revision string) (*Config, int) {
@@ -22,7 +24,8 @@ func ReadConfig(version, revision string) (*Config, int) {
 
 	flag.StringVar(&env, "e", "", "The environment which should be used")
 	flag.StringVar(&envDir, "ed", envDir, "The environment directory")
-	flag.StringVar(&cfg.Broker, "b", "", "The broker URI. ex: tcp://127.0.0.1:1883")
+	flag.Var(&cfg.Broker, "b", "The broker URI(s) separated by comma. ex: tcp://127.0.0.1:1883 (or embedded://[host:port] for an embedded local broker)")
+	flag.StringVar(&cfg.BrokerOrder, "bo", "failover", "In which order the brokers should be tried on (re-)connect: failover, random, round-robin")
 	flag.StringVar(&cfg.CaFile, "ca", "", "MQTT ca file path (if tls is used)")
 	flag.IntVar(&cfg.SubscribeQOS, "sq", 0, "The default Quality of Service for subscription 0,1,2")
 	flag.IntVar(&cfg.PublishQOS, "pq", 1, "The default Quality of Service for publishing 0,1,2")
@@ -30,16 +33,32 @@ func ReadConfig(version, revision string) (*Config, int) {
 	flag.StringVar(&cfg.Password, "p", "", "The password")
 	flag.StringVar(&cfg.ClientId, "c", "mqtt-shell", "The ClientID")
 	flag.BoolVar(&cfg.CleanSession, "cs", true, "Indicating that no messages saved by the broker for this client should be delivered")
+	flag.StringVar(&cfg.SessionStore, "ss", "memory", "Where the unacknowledged QoS 1/2 messages should be stored: memory, file")
+	flag.StringVar(&cfg.SessionStoreDirectory, "ssd", "", "The directory of the file session store (default: <environment directory>/.store/<environment>/<client-id>)")
+	flag.DurationVar(&cfg.ConnectTimeout, "cto", 30*time.Second, "The timeout for establishing a connection to the broker. 0 means no timeout")
+	flag.DurationVar(&cfg.KeepAlive, "ka", 30*time.Second, "The keepalive interval. 0 means no keepalive")
+	flag.BoolVar(&cfg.AutoReconnect, "ar", true, "Should the shell reconnect automatically if the connection is lost")
+	flag.DurationVar(&cfg.MaxReconnectInterval, "mri", 10*time.Minute, "The maximum time between two reconnect attempts")
+	flag.BoolVar(&cfg.ConnectRetry, "cr", false, "Should the initial connection be retried until it succeeds")
+	flag.DurationVar(&cfg.ConnectRetryInterval, "cri", 10*time.Second, "The time between two attempts of the initial connection")
+	flag.BoolVar(&cfg.Protected, "pe", false, "Is this a protected environment (such like production). Retained publishes and replays must be confirmed")
 	flag.BoolVar(&cfg.NonInteractive, "ni", false, "Should this shell be non interactive. Only useful in combination with 'cmd' option")
 	flag.StringVar(&cfg.HistoryFile, "hf", path.Join(envDir, ".history"), "The history file path")
-	flag.StringVar(&cfg.Prompt, "sp", `\033[36m»\033[0m `, "The prompt of the shell")
-
-	var startCommands, macroFiles, colorBlacklist varArgs
+	flag.StringVar(&cfg.Prompt, "sp", `\033[36m»\033[0m `, "The prompt (template) of the shell. ex: {{.Env}}@{{.Broker}} ({{.State}})>")
+	flag.IntVar(&cfg.ChainWorkers, "cw", 0, "The maximum count of parallel executed chains. 0 means that the chains will be executed one after another")
+	flag.BoolVar(&cfg.ChainOrdered, "co", false, "Should the chains of one subscription be executed in order of the incoming messages")
+	flag.DurationVar(&cfg.ChainTimeout, "ct", 0, "The maximum duration of a chain execution. 0 means no timeout")
+	flag.IntVar(&cfg.ChainQueueSize, "cqs", 100, "The size of the queue for messages which are waiting for their chain execution")
+	flag.StringVar(&cfg.ChainQueuePolicy, "cqp", "block", "What should happen if the chain queue is full: block, drop")
+
+	var startCommands, macroFiles, colorBlacklist, confirmTopics, blockTopics varArgs
 	macroFiles.Set(path.Join(envDir, ".macros.yml"))
 
 	flag.Var(&startCommands, "cmd", "The command(s) which should be executed at the beginning")
 	flag.Var(&macroFiles, "m", "The macro file(s) which should be loaded")
 	flag.Var(&colorBlacklist, "cb", "This color(s) will not be used")
+	flag.Var(&confirmTopics, "tc", "The topic filter(s) whose publishes must be confirmed")
+	flag.Var(&blockTopics, "tb", "The topic filter(s) whose publishes will be blocked")
 	flag.Parse()
 
 	if moreHelp {
@@ -62,10 +81,13 @@ func ReadConfig(version, revision string) (*Config, int) {
 	if env != "" {
 		handleFile(envDir, env, &cfg)
 	}
+	cfg.Environment = env
 
 	// overwrite potential config values with argument values
 	startCommands.Reset()
 	colorBlacklist.Reset()
+	confirmTopics.Reset()
+	blockTopics.Reset()
 	flag.Parse()
 	if len(startCommands) > 0 {
 		cfg.StartCommands = startCommands
@@ -73,16 +95,54 @@ func ReadConfig(version, revision string) (*Config, int) {
 	if len(colorBlacklist) > 0 {
 		cfg.ColorBlacklist = colorBlacklist
 	}
+	if len(confirmTopics) > 0 {
+		cfg.ConfirmTopics = confirmTopics
+	}
+	if len(blockTopics) > 0 {
+		cfg.BlockTopics = blockTopics
+	}
 
-	if cfg.Broker == "" {
+	if len(cfg.Broker) == 0 {
 		fmt.Fprint(os.Stderr, "Broker is missing!")
 		return nil, 1
 	}
+	if cfg.BrokerOrder != "failover" && cfg.BrokerOrder != "random" && cfg.BrokerOrder != "round-robin" {
+		fmt.Fprint(os.Stderr, "Invalid broker order!")
+		return nil, 1
+	}
+	if cfg.ChainQueuePolicy != "block" && cfg.ChainQueuePolicy != "drop" {
+		fmt.Fprint(os.Stderr, "Invalid chain queue policy!")
+		return nil, 1
+	}
+	if cfg.SessionStore != "memory" && cfg.SessionStore != "file" {
+		fmt.Fprint(os.Stderr, "Invalid session store!")
+		return nil, 1
+	}
+	if cfg.ConnectTimeout < 0 || cfg.KeepAlive < 0 {
+		fmt.Fprint(os.Stderr, "Invalid connect timeout or keepalive!")
+		return nil, 1
+	}
+	if cfg.MaxReconnectInterval <= 0 || cfg.ConnectRetryInterval <= 0 {
+		fmt.Fprint(os.Stderr, "Invalid reconnect interval!")
+		return nil, 1
+	}
+	if cfg.SessionStore == "file" && cfg.SessionStoreDirectory == "" {
+		cfg.SessionStoreDirectory = sessionStoreDirectory(envDir, env, cfg.ClientId)
+	}
 	loadMacroFiles(&cfg, macroFiles)
 
 	return &cfg, -1
 }
 
+// sessionStoreDirectory returns the default directory of the file session store. Each environment and client-id has
+// its own store because the broker holds one session per client-id.
+func sessionStoreDirectory(envDir, env, clientId string) string {
+	if env == "" {
+		env = "default"
+	}
+	return path.Join(envDir, ".store", url.PathEscape(env), url.PathEscape(clientId))
+}
+
 func handleFile(envDir, env string, cfg *Config) {
 	var suffix string
 	if _, err := os.Stat(path.Join(envDir, env+".yaml")); os.IsNotExist(err) {
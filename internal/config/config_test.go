@@ -7,6 +7,7 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestReadConfig_moreHelp(t *testing.T) {
@@ -81,6 +82,107 @@ func TestReadConfig_brokerIsMandatory(t *testing.T) {
 	assert.Equal(t, "Broker is missing!", string(content))
 }
 
+func TestReadConfig_invalidChainQueuePolicy(t *testing.T) {
+	resetFlags()
+
+	origGetConfigDirectory := getConfigDirectory
+	defer func() {
+		getConfigDirectory = origGetConfigDirectory
+	}()
+	getConfigDirectory = func() string {
+		return t.TempDir()
+	}
+
+	os.Args = []string{"mqtt-shell", "-b", "tcp://127.0.0.1:1883", "-cqp", "ignore"}
+	os.Stderr, _ = os.OpenFile(path.Join(t.TempDir(), "stderr"), os.O_RDWR|os.O_CREATE, 0755)
+
+	result, rc := ReadConfig("<version>", "<revision>")
+
+	assert.Nil(t, result)
+	assert.Equal(t, 1, rc)
+
+	content, err := os.ReadFile(os.Stderr.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "Invalid chain queue policy!", string(content))
+}
+
+func TestReadConfig_invalidBrokerOrder(t *testing.T) {
+	resetFlags()
+
+	origGetConfigDirectory := getConfigDirectory
+	defer func() {
+		getConfigDirectory = origGetConfigDirectory
+	}()
+	getConfigDirectory = func() string {
+		return t.TempDir()
+	}
+
+	os.Args = []string{"mqtt-shell", "-b", "tcp://127.0.0.1:1883", "-bo", "weighted"}
+	os.Stderr, _ = os.OpenFile(path.Join(t.TempDir(), "stderr"), os.O_RDWR|os.O_CREATE, 0755)
+
+	result, rc := ReadConfig("<version>", "<revision>")
+
+	assert.Nil(t, result)
+	assert.Equal(t, 1, rc)
+
+	content, err := os.ReadFile(os.Stderr.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "Invalid broker order!", string(content))
+}
+
+func TestReadConfig_invalidSessionStore(t *testing.T) {
+	resetFlags()
+
+	origGetConfigDirectory := getConfigDirectory
+	defer func() {
+		getConfigDirectory = origGetConfigDirectory
+	}()
+	getConfigDirectory = func() string {
+		return t.TempDir()
+	}
+
+	os.Args = []string{"mqtt-shell", "-b", "tcp://127.0.0.1:1883", "-ss", "redis"}
+	os.Stderr, _ = os.OpenFile(path.Join(t.TempDir(), "stderr"), os.O_RDWR|os.O_CREATE, 0755)
+
+	result, rc := ReadConfig("<version>", "<revision>")
+
+	assert.Nil(t, result)
+	assert.Equal(t, 1, rc)
+
+	content, err := os.ReadFile(os.Stderr.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "Invalid session store!", string(content))
+}
+
+func TestReadConfig_invalidReconnectInterval(t *testing.T) {
+	resetFlags()
+
+	origGetConfigDirectory := getConfigDirectory
+	defer func() {
+		getConfigDirectory = origGetConfigDirectory
+	}()
+	getConfigDirectory = func() string {
+		return t.TempDir()
+	}
+
+	os.Args = []string{"mqtt-shell", "-b", "tcp://127.0.0.1:1883", "-mri", "0"}
+	os.Stderr, _ = os.OpenFile(path.Join(t.TempDir(), "stderr"), os.O_RDWR|os.O_CREATE, 0755)
+
+	result, rc := ReadConfig("<version>", "<revision>")
+
+	assert.Nil(t, result)
+	assert.Equal(t, 1, rc)
+
+	content, err := os.ReadFile(os.Stderr.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "Invalid reconnect interval!", string(content))
+}
+
+func TestSessionStoreDirectory(t *testing.T) {
+	assert.Equal(t, "/cfg/.store/default/mqtt-shell", sessionStoreDirectory("/cfg", "", "mqtt-shell"))
+	assert.Equal(t, "/cfg/.store/prod/client%2F1", sessionStoreDirectory("/cfg", "prod", "client/1"))
+}
+
 func TestReadConfig_defaultValues(t *testing.T) {
 	resetFlags()
 
@@ -98,7 +200,8 @@ func TestReadConfig_defaultValues(t *testing.T) {
 
 	assert.Equal(t, -1, rc)
 	assert.Equal(t, Config{
-		Broker:         "tcp://127.0.0.1:1883",
+		Broker:         BrokerList{"tcp://127.0.0.1:1883"},
+		BrokerOrder:    "failover",
 		CaFile:         "",
 		SubscribeQOS:   0,
 		PublishQOS:     1,
@@ -112,6 +215,21 @@ func TestReadConfig_defaultValues(t *testing.T) {
 		Prompt:         "\x1b[36m»\x1b[0m ",
 		Macros:         nil,
 		ColorBlacklist: nil,
+
+		SessionStore: "memory",
+
+		ConnectTimeout:       30 * time.Second,
+		KeepAlive:            30 * time.Second,
+		AutoReconnect:        true,
+		MaxReconnectInterval: 10 * time.Minute,
+		ConnectRetry:         false,
+		ConnectRetryInterval: 10 * time.Second,
+
+		ChainWorkers:     0,
+		ChainOrdered:     false,
+		ChainTimeout:     0,
+		ChainQueueSize:   100,
+		ChainQueuePolicy: "block",
 	}, *result)
 }
 
@@ -136,7 +254,10 @@ func testReadConfig_readGlobal(t *testing.T, fileName string) {
 	}
 
 	err := os.WriteFile(path.Join(cfgDir, fileName), []byte(strings.ReplaceAll(strings.TrimSpace(`
-broker: tcp://127.0.0.1:1883
+broker:
+	- tcp://127.0.0.1:1883
+	- tcp://127.0.0.2:1883
+broker-order: round-robin
 ca: /tmp/ca.pam
 subscribe-qos: 1
 publish-qos: 2
@@ -144,6 +265,19 @@ username: rainu
 password: secret
 client-id: rainu-shell
 clean-session: false
+session-store: file
+connect-timeout: 5s
+keepalive: 1m
+auto-reconnect: false
+max-reconnect-interval: 30s
+connect-retry: true
+connect-retry-interval: 2s
+protected: true
+confirm-topics:
+	- config/#
+block-topics:
+	- system/#
+	- $SYS/#
 commands:
 	- help
 non-interactive: true
@@ -160,6 +294,11 @@ macros:
 		script: its a script
 color-blacklist:
 	- "00,11,22"
+chain-workers: 4
+chain-ordered: true
+chain-timeout: 10s
+chain-queue-size: 10
+chain-queue-policy: drop
 	`), "\t", "  ")), 0755)
 	assert.Nil(t, err)
 
@@ -179,7 +318,8 @@ example:
 
 	assert.Equal(t, -1, rc)
 	assert.Equal(t, Config{
-		Broker:         "tcp://127.0.0.1:1883",
+		Broker:         BrokerList{"tcp://127.0.0.1:1883", "tcp://127.0.0.2:1883"},
+		BrokerOrder:    "round-robin",
 		CaFile:         "/tmp/ca.pam",
 		SubscribeQOS:   1,
 		PublishQOS:     2,
@@ -208,9 +348,53 @@ example:
 			},
 		},
 		ColorBlacklist: []string{"00,11,22"},
+
+		SessionStore:          "file",
+		SessionStoreDirectory: path.Join(cfgDir, ".store", "default", "rainu-shell"),
+
+		ConnectTimeout:       5 * time.Second,
+		KeepAlive:            1 * time.Minute,
+		AutoReconnect:        false,
+		MaxReconnectInterval: 30 * time.Second,
+		ConnectRetry:         true,
+		ConnectRetryInterval: 2 * time.Second,
+
+		Protected:     true,
+		ConfirmTopics: []string{"config/#"},
+		BlockTopics:   []string{"system/#", "$SYS/#"},
+
+		ChainWorkers:     4,
+		ChainOrdered:     true,
+		ChainTimeout:     10 * time.Second,
+		ChainQueueSize:   10,
+		ChainQueuePolicy: "drop",
 	}, *result)
 }
 
+func TestReadConfig_environment(t *testing.T) {
+	resetFlags()
+
+	origGetConfigDirectory := getConfigDirectory
+	defer func() {
+		getConfigDirectory = origGetConfigDirectory
+	}()
+	cfgDir := t.TempDir()
+	getConfigDirectory = func() string {
+		return cfgDir
+	}
+
+	err := os.WriteFile(path.Join(cfgDir, "staging.yml"), []byte(`broker: tcp://staging:1883`), 0755)
+	assert.Nil(t, err)
+
+	os.Args = []string{"mqtt-shell", "-e", "staging", "-ss", "file"}
+	result, rc := ReadConfig("<version>", "<revision>")
+
+	assert.Equal(t, -1, rc)
+	assert.Equal(t, "staging", result.Environment)
+	assert.Equal(t, BrokerList{"tcp://staging:1883"}, result.Broker)
+	assert.Equal(t, path.Join(cfgDir, ".store", "staging", "mqtt-shell"), result.SessionStoreDirectory)
+}
+
 func TestReadConfig_argsOverridesConfigFiles(t *testing.T) {
 	resetFlags()
 
@@ -232,6 +416,19 @@ username: rainu
 password: secret
 client-id: rainu-shell
 clean-session: false
+session-store: file
+session-store-directory: /tmp/store
+connect-timeout: 5s
+keepalive: 1m
+auto-reconnect: false
+max-reconnect-interval: 30s
+connect-retry: true
+connect-retry-interval: 2s
+protected: true
+confirm-topics:
+	- config/#
+block-topics:
+	- system/#
 commands:
 	- help
 non-interactive: false
@@ -239,12 +436,18 @@ history-file: /tmp/history
 prompt: =>
 color-blacklist:
 	- "00,11,22"
+chain-workers: 4
+chain-ordered: true
+chain-timeout: 10s
+chain-queue-size: 10
+chain-queue-policy: drop
 	`), "\t", "  ")), 0755)
 	assert.Nil(t, err)
 
 	os.Args = []string{
 		"mqtt-shell",
-		"-b", "tcp://8.8.8.8:1883",
+		"-b", "tcp://8.8.8.8:1883,tcp://8.8.4.4:1883",
+		"-bo", "random",
 		"-ca", "/home/ca.pam",
 		"-sq", "2",
 		"-pq", "1",
@@ -252,17 +455,35 @@ color-blacklist:
 		"-p", "password",
 		"-c", "test-shell",
 		"-cs",
+		"-ss", "memory",
+		"-ssd", "/home/store",
+		"-cto", "1s",
+		"-ka", "0",
+		"-ar",
+		"-mri", "1m",
+		"-cr=false",
+		"-cri", "1s",
+		"-pe=false",
+		"-tc", "devices/+/config",
+		"-tc", "firmware/#",
+		"-tb", "$SYS/#",
 		"-cmd", "test",
 		"-ni",
 		"-hf", "/home/history",
 		"-sp", "$>",
 		"-cb", "13,12,89",
+		"-cw", "2",
+		"-co=false",
+		"-ct", "1m",
+		"-cqs", "1",
+		"-cqp", "block",
 	}
 	result, rc := ReadConfig("<version>", "<revision>")
 
 	assert.Equal(t, -1, rc)
 	assert.Equal(t, Config{
-		Broker:         "tcp://8.8.8.8:1883",
+		Broker:         BrokerList{"tcp://8.8.8.8:1883", "tcp://8.8.4.4:1883"},
+		BrokerOrder:    "random",
 		CaFile:         "/home/ca.pam",
 		SubscribeQOS:   2,
 		PublishQOS:     1,
@@ -276,6 +497,26 @@ color-blacklist:
 		Prompt:         "$>",
 		Macros:         nil,
 		ColorBlacklist: []string{"13,12,89"},
+
+		SessionStore:          "memory",
+		SessionStoreDirectory: "/home/store",
+
+		ConnectTimeout:       1 * time.Second,
+		KeepAlive:            0,
+		AutoReconnect:        true,
+		MaxReconnectInterval: 1 * time.Minute,
+		ConnectRetry:         false,
+		ConnectRetryInterval: 1 * time.Second,
+
+		Protected:     false,
+		ConfirmTopics: []string{"devices/+/config", "firmware/#"},
+		BlockTopics:   []string{"$SYS/#"},
+
+		ChainWorkers:     2,
+		ChainOrdered:     false,
+		ChainTimeout:     1 * time.Minute,
+		ChainQueueSize:   1,
+		ChainQueuePolicy: "block",
 	}, *result)
 }
 
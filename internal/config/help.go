@@ -13,6 +13,7 @@ var helpText = `\u001b[7mSetting files\u001b[0m
   For example (\u001b[1mexample.yml\u001b[0m):
   
     broker: tls://127.0.0.1:8883
+    broker-order: failover
     ca: /tmp/my.ca
     subscribe-qos: 1
     publish-qos: 2
@@ -20,6 +21,17 @@ var helpText = `\u001b[7mSetting files\u001b[0m
     password:  secret
     client-id: my-mqtt-shell
     clean-session: true
+    session-store: memory
+    connect-timeout: 30s
+    keepalive: 30s
+    auto-reconnect: true
+    max-reconnect-interval: 10m
+    connect-retry: false
+    protected: false
+    confirm-topics:
+      - config/#
+    block-topics:
+      - $SYS/#
     commands: 
       - sub #
     non-interactive: false
@@ -34,9 +46,50 @@ var helpText = `\u001b[7mSetting files\u001b[0m
           - pub test $1
     color-blacklist:
       - "38;5;237"
+    chain-workers: 4
+    chain-ordered: true
+    chain-timeout: 30s
+    chain-queue-size: 100
+    chain-queue-policy: drop
 
   \u001b[4m$ ./mqtt-shell -e example\u001b[0m
 
+\u001b[7mPrompt\u001b[0m
+  The prompt is a template which will be rendered again on each change of the connection, the subscriptions and the 
+  jobs. The following variables can be used: \u001b[1m{{.Env}}\u001b[0m, \u001b[1m{{.Broker}}\u001b[0m, \u001b[1m{{.ClientId}}\u001b[0m, \u001b[1m{{.State}}\u001b[0m, \u001b[1m{{.Subscriptions}}\u001b[0m, 
+  \u001b[1m{{.Jobs}}\u001b[0m and \u001b[1m{{.Protected}}\u001b[0m.
+
+    prompt: "{{.Env}}@{{.Broker}} ({{.State}}) \033[36m»\033[0m "
+
+\u001b[7mProtected environments\u001b[0m
+  Publishes to topics which are matching \u001b[1mblock-topics\u001b[0m will be rejected. Publishes to topics which are matching 
+  \u001b[1mconfirm-topics\u001b[0m must be confirmed by typing "yes". In a protected environment (\u001b[1mprotected: true\u001b[0m) each 
+  retained publish, each import or clear of retained messages and each replay must be confirmed too. A protected 
+  environment is marked in front of the prompt.
+
+  \u001b[4m$ ./mqtt-shell -e prod -pe -tc config/# -tb $SYS/#\u001b[0m
+
+\u001b[7mEmbedded broker\u001b[0m
+  For offline testing the shell can start its own MQTT broker (\u001b[1mbroker: embedded://[host:port]\u001b[0m). By default it 
+  listens on 127.0.0.1:1883. It supports MQTT 3.1 and 3.1.1 without authentication and TLS.
+
+  \u001b[4m$ ./mqtt-shell -b embedded://\u001b[0m
+
+\u001b[7mBroker failover\u001b[0m
+  Multiple brokers can be given (separated by comma or as yaml list). If the connection is lost, the shell reconnects 
+  to the next available broker. The order can be set by \u001b[1mbroker-order\u001b[0m: failover (the first available broker), 
+  random or round-robin (beginning with the broker after the last tried one).
+
+  \u001b[4m$ ./mqtt-shell -b tcp://broker-1:1883,tcp://broker-2:1883 -bo round-robin\u001b[0m
+
+\u001b[7mPersistent session\u001b[0m
+  By default the unacknowledged QoS 1/2 messages are only held in memory and are lost if the shell exits. With the 
+  file session store (\u001b[1msession-store: file\u001b[0m) they are written into \u001b[1m__CONFIG_DIR__/.store/<environment>/<client-id>\u001b[0m 
+  (or \u001b[1msession-store-directory\u001b[0m) and will be delivered after the next start. This is only useful without 
+  clean session.
+
+  \u001b[4m$ ./mqtt-shell -e example -cs=false -ss file\u001b[0m
+
 \u001b[7mMacros\u001b[0m
   Macros can be a list of commands which should be executed. Or it can be a more complex but more powerful script. 
   Macros can have their own arguments. They can be defined in the environment file (\u001b[1m__CONFIG_DIR__/my-env.yml\u001b[0m), the 
@@ -1,16 +1,38 @@
 package config
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type Config struct {
-	Broker       string `yaml:"broker"`
-	CaFile       string `yaml:"ca"`
-	SubscribeQOS int    `yaml:"subscribe-qos"`
-	PublishQOS   int    `yaml:"publish-qos"`
-	Username     string `yaml:"username"`
-	Password     string `yaml:"password"`
-	ClientId     string `yaml:"client-id"`
-	CleanSession bool   `yaml:"clean-session"`
+	// Environment is the name of the used environment (it can only be given by argument)
+	Environment string `yaml:"-"`
+
+	Broker       BrokerList `yaml:"broker"`
+	BrokerOrder  string     `yaml:"broker-order"`
+	CaFile       string     `yaml:"ca"`
+	SubscribeQOS int        `yaml:"subscribe-qos"`
+	PublishQOS   int        `yaml:"publish-qos"`
+	Username     string     `yaml:"username"`
+	Password     string     `yaml:"password"`
+	ClientId     string     `yaml:"client-id"`
+	CleanSession bool       `yaml:"clean-session"`
+
+	SessionStore          string `yaml:"session-store"`
+	SessionStoreDirectory string `yaml:"session-store-directory"`
+
+	ConnectTimeout       time.Duration `yaml:"connect-timeout"`
+	KeepAlive            time.Duration `yaml:"keepalive"`
+	AutoReconnect        bool          `yaml:"auto-reconnect"`
+	MaxReconnectInterval time.Duration `yaml:"max-reconnect-interval"`
+	ConnectRetry         bool          `yaml:"connect-retry"`
+	ConnectRetryInterval time.Duration `yaml:"connect-retry-interval"`
+
+	Protected     bool     `yaml:"protected"`
+	ConfirmTopics []string `yaml:"confirm-topics"`
+	BlockTopics   []string `yaml:"block-topics"`
 
 	StartCommands  []string         `yaml:"commands"`
 	NonInteractive bool             `yaml:"non-interactive"`
@@ -18,6 +40,12 @@ type Config struct {
 	Prompt         string           `yaml:"prompt"`
 	Macros         map[string]Macro `yaml:"macros"`
 	ColorBlacklist []string         `yaml:"color-blacklist"`
+
+	ChainWorkers     int           `yaml:"chain-workers"`
+	ChainOrdered     bool          `yaml:"chain-ordered"`
+	ChainTimeout     time.Duration `yaml:"chain-timeout"`
+	ChainQueueSize   int           `yaml:"chain-queue-size"`
+	ChainQueuePolicy string        `yaml:"chain-queue-policy"`
 }
 
 type Macro struct {
@@ -28,6 +56,37 @@ type Macro struct {
 	Script      string   `yaml:"script"`
 }
 
+// BrokerList contains the broker URIs in failover order. It can be given as comma separated value or as yaml list.
+type BrokerList []string
+
+func (b *BrokerList) String() string {
+	return strings.Join(*b, ",")
+}
+
+func (b *BrokerList) Set(value string) error {
+	*b = nil
+	for _, broker := range strings.Split(value, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			*b = append(*b, broker)
+		}
+	}
+	return nil
+}
+
+func (b *BrokerList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var brokers []string
+	if err := unmarshal(&brokers); err == nil {
+		*b = brokers
+		return nil
+	}
+
+	var broker string
+	if err := unmarshal(&broker); err != nil {
+		return err
+	}
+	return b.Set(broker)
+}
+
 type varArgs []string
 
 func (i *varArgs) String() string {
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+	"testing"
+)
+
+func TestBrokerList_Set(t *testing.T) {
+	toTest := BrokerList{"tcp://old:1883"}
+
+	assert.NoError(t, toTest.Set("tcp://a:1883, tcp://b:1883,,"))
+	assert.Equal(t, BrokerList{"tcp://a:1883", "tcp://b:1883"}, toTest)
+	assert.Equal(t, "tcp://a:1883,tcp://b:1883", toTest.String())
+}
+
+func TestBrokerList_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected BrokerList
+	}{
+		{"broker: tcp://a:1883", BrokerList{"tcp://a:1883"}},
+		{"broker: tcp://a:1883,tcp://b:1883", BrokerList{"tcp://a:1883", "tcp://b:1883"}},
+		{"broker: [tcp://a:1883, tcp://b:1883]", BrokerList{"tcp://a:1883", "tcp://b:1883"}},
+	}
+	for _, test := range tests {
+		t.Run(test.content, func(t *testing.T) {
+			cfg := Config{}
+			assert.NoError(t, yaml.Unmarshal([]byte(test.content), &cfg))
+			assert.Equal(t, test.expected, cfg.Broker)
+		})
+	}
+
+	assert.Error(t, yaml.Unmarshal([]byte("broker: {a: b}"), &Config{}))
+}
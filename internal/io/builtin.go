@@ -0,0 +1,314 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// maxPayloadSize is the maximum size of a mqtt payload (256MB)
+const maxPayloadSize = 256 * 1024 * 1024
+
+// stage is a part of a chain which will be executed in-process
+type stage func(in io.Reader, out io.Writer) error
+
+// builtinCommand creates a new stage for the given arguments
+type builtinCommand func(env chainEnv, args []string) (stage, error)
+
+var builtinCommands = map[string]builtinCommand{
+	builtinSelect:   newSelectStage,
+	builtinTemplate: newTemplateStage,
+	builtinPub:      newPubStage,
+}
+
+// sinks are builtin commands which will consume their input without producing any output
+var sinks = map[string]bool{
+	builtinPub: true,
+}
+
+func isBuiltin(name string) bool {
+	_, ok := builtinCommands[name]
+	return ok
+}
+
+func isSink(name string) bool {
+	return sinks[name]
+}
+
+func newSelectStage(_ chainEnv, args []string) (stage, error) {
+	if len(args) != 1 {
+		return nil, errors.New(builtinSelect + ": invalid arguments\nUsage: " + builtinSelect + " <jsonpath>")
+	}
+
+	path, err := parseJsonPath(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", builtinSelect, err)
+	}
+
+	return func(in io.Reader, out io.Writer) error {
+		return forEachJson(builtinSelect, in, func(value interface{}) error {
+			selected := path.resolve(value)
+
+			if s, isString := selected.(string); isString {
+				//strings will be written raw (without quotes)
+				_, err := out.Write([]byte(s + "\n"))
+				return err
+			}
+
+			raw, err := json.Marshal(selected)
+			if err != nil {
+				return err
+			}
+			_, err = out.Write(append(raw, '\n'))
+			return err
+		})
+	}, nil
+}
+
+func newTemplateStage(_ chainEnv, args []string) (stage, error) {
+	if len(args) == 0 {
+		return nil, errors.New(builtinTemplate + ": invalid arguments\nUsage: " + builtinTemplate + " <template>")
+	}
+
+	tmpl, err := template.New(builtinTemplate).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			raw, err := json.Marshal(v)
+			return string(raw), err
+		},
+	}).Parse(strings.Join(args, " "))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", builtinTemplate, err)
+	}
+
+	return func(in io.Reader, out io.Writer) error {
+		return forEachJson(builtinTemplate, in, func(value interface{}) error {
+			buf := &bytes.Buffer{}
+			if err := tmpl.Execute(buf, value); err != nil {
+				return err
+			}
+			if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+				buf.WriteString("\n")
+			}
+
+			_, err := out.Write(buf.Bytes())
+			return err
+		})
+	}, nil
+}
+
+func newPubStage(env chainEnv, args []string) (st stage, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s: %s\nUsage: "+builtinPub+" [-r] [-q 0|1|2] [-a] <topic>", builtinPub, err.Error())
+		}
+	}()
+
+	var topic string
+	qos := 0
+	retained := false
+	all := false
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-r":
+			retained = true
+		case "-a":
+			all = true
+		case "-q":
+			if i+1 >= len(args) {
+				return nil, errors.New("invalid arguments")
+			}
+			qos, err = strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid qos level: %w", err)
+			}
+			if qos < 0 || qos > 2 {
+				return nil, errors.New("invalid qos level")
+			}
+			i++
+		default:
+			if topic != "" {
+				return nil, errors.New("invalid arguments")
+			}
+			topic = env.expand([]string{args[i]})[0]
+		}
+	}
+
+	if topic == "" {
+		return nil, errors.New("invalid arguments")
+	}
+	//nobody can be asked for a confirmation while the chain is running
+	if err := env.guard.check(retained, topic); err != nil {
+		return nil, err
+	}
+
+	//the tokens of the published messages which are not completed yet
+	var pending []mqtt.Token
+
+	//check checks the errors of the completed tokens. If wait is true, it waits until all tokens are completed.
+	check := func(wait bool) error {
+		remaining := pending[:0]
+		for _, token := range pending {
+			if wait {
+				token.Wait()
+			} else if !tokenDone(token) {
+				remaining = append(remaining, token)
+				continue
+			}
+			if err := token.Error(); err != nil {
+				return err
+			}
+		}
+		pending = remaining
+		return nil
+	}
+
+	publish := func(payload []byte) error {
+		if len(payload) == 0 {
+			return nil
+		}
+
+		//do not wait for the acknowledgement of each message: the following messages can be published meanwhile
+		pending = append(pending, env.client.Publish(topic, byte(qos), retained, payload))
+		return check(false)
+	}
+
+	//at the end all acknowledgements will be awaited (except inside a message handler: then only the already
+	//completed ones can be checked)
+	finish := func(err error) error {
+		if err != nil {
+			return err
+		}
+		return check(!env.synchronous)
+	}
+
+	return func(in io.Reader, _ io.Writer) error {
+		if all {
+			//the complete input is one message
+			payload, err := ioutil.ReadAll(in)
+			if err != nil {
+				return err
+			}
+			return finish(publish(bytes.TrimSuffix(payload, []byte("\n"))))
+		}
+
+		//each line is one message
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxPayloadSize)
+		for scanner.Scan() {
+			if err := publish(scanner.Bytes()); err != nil {
+				return err
+			}
+		}
+		return finish(scanner.Err())
+	}, nil
+}
+
+// forEachJson decodes all json values of the given input stream. The values can
+// be separated by newlines (long term chains) or can span over multiple lines.
+func forEachJson(name string, in io.Reader, fn func(interface{}) error) error {
+	decoder := json.NewDecoder(in)
+	decoder.UseNumber()
+
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return nil
+		}
+
+		var syntaxError *json.SyntaxError
+		if errors.As(err, &syntaxError) || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%s: unable to parse json input: %w", name, err)
+		} else if err != nil {
+			//read errors will be passed through
+			return err
+		}
+
+		if err := fn(value); err != nil {
+			return err
+		}
+	}
+}
+
+// jsonPath is a list of object keys (string) and array indices (int)
+type jsonPath []interface{}
+
+// parseJsonPath parses paths such like: .key.sub[0]["key with spaces"]
+func parseJsonPath(raw string) (jsonPath, error) {
+	path := jsonPath{}
+	rest := strings.TrimPrefix(strings.TrimSpace(raw), "$")
+
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest, `"]`)
+			if end == -1 {
+				return nil, fmt.Errorf("invalid path '%s': unterminated key", raw)
+			}
+			path = append(path, rest[2:end])
+			rest = rest[end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("invalid path '%s': unterminated index", raw)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path '%s': invalid index: %w", raw, err)
+			}
+			path = append(path, index)
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			if end > 0 {
+				path = append(path, rest[:end])
+			}
+			rest = rest[end:]
+		default:
+			return nil, fmt.Errorf("invalid path '%s': unexpected '%s'", raw, rest)
+		}
+	}
+
+	return path, nil
+}
+
+// resolve returns the value behind the path or nil if there is no such value
+func (p jsonPath) resolve(value interface{}) interface{} {
+	for _, part := range p {
+		switch key := part.(type) {
+		case string:
+			obj, isObj := value.(map[string]interface{})
+			if !isObj {
+				return nil
+			}
+			value = obj[key]
+		case int:
+			arr, isArr := value.([]interface{})
+			if !isArr {
+				return nil
+			}
+			if key < 0 {
+				key += len(arr)
+			}
+			if key < 0 || key >= len(arr) {
+				return nil
+			}
+			value = arr[key]
+		}
+	}
+
+	return value
+}
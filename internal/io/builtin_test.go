@@ -0,0 +1,252 @@
+package io
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestParseJsonPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected jsonPath
+		err      string
+	}{
+		{".", jsonPath{}, ""},
+		{"$", jsonPath{}, ""},
+		{".key", jsonPath{"key"}, ""},
+		{"$.key.sub", jsonPath{"key", "sub"}, ""},
+		{".key[1].sub", jsonPath{"key", 1, "sub"}, ""},
+		{`.key["with space"][-1]`, jsonPath{"key", "with space", -1}, ""},
+		{".key[NAN]", nil, `invalid path '.key[NAN]': invalid index: strconv.Atoi: parsing "NAN": invalid syntax`},
+		{".key[1", nil, `invalid path '.key[1': unterminated index`},
+		{`.key["1]`, nil, `invalid path '.key["1]': unterminated key`},
+		{"key", nil, `invalid path 'key': unexpected 'key'`},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestParseJsonPath_%d", i), func(t *testing.T) {
+			result, err := parseJsonPath(test.path)
+
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, test.err, err.Error())
+			}
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestSelectStage(t *testing.T) {
+	tests := []struct {
+		path     string
+		input    string
+		expected string
+	}{
+		{".value", `{"value": 12.5}`, "12.5\n"},
+		{".value", `{"value": "text"}`, "text\n"},
+		{".value", `{"other": "text"}`, "null\n"},
+		{".values[1]", `{"values": [1, 2, 3]}`, "2\n"},
+		{".values[-1]", `{"values": [1, 2, 3]}`, "3\n"},
+		{".values[5]", `{"values": [1, 2, 3]}`, "null\n"},
+		{".obj", `{"obj": {"a": 1}}`, `{"a":1}` + "\n"},
+		{".value", "{\"value\": 1}\n{\"value\": 2}\n", "1\n2\n"},
+		{".value", "{\n\"value\": 1\n}", "1\n"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestSelectStage_%d", i), func(t *testing.T) {
+			toTest, err := newSelectStage(chainEnv{}, []string{test.path})
+			assert.NoError(t, err)
+
+			output := &bytes.Buffer{}
+			assert.NoError(t, toTest(strings.NewReader(test.input), output))
+			assert.Equal(t, test.expected, output.String())
+		})
+	}
+}
+
+func TestSelectStage_invalidArguments(t *testing.T) {
+	_, err := newSelectStage(chainEnv{}, nil)
+	assert.Equal(t, "select: invalid arguments\nUsage: select <jsonpath>", err.Error())
+
+	_, err = newSelectStage(chainEnv{}, []string{"invalid"})
+	assert.Equal(t, "select: invalid path 'invalid': unexpected 'invalid'", err.Error())
+}
+
+func TestSelectStage_invalidInput(t *testing.T) {
+	toTest, err := newSelectStage(chainEnv{}, []string{".value"})
+	assert.NoError(t, err)
+
+	err = toTest(strings.NewReader(`no json`), &bytes.Buffer{})
+	assert.Equal(t, "select: unable to parse json input: invalid character 'o' in literal null (expecting 'u')", err.Error())
+}
+
+func TestTemplateStage(t *testing.T) {
+	toTest, err := newTemplateStage(chainEnv{}, []string{"{{.name}}:", "{{.value}}", "{{json .obj}}"})
+	assert.NoError(t, err)
+
+	output := &bytes.Buffer{}
+	input := `{"name": "temp", "value": 12.5, "obj": {"a": 1}}` + "\n" + `{"name": "hum", "value": 50, "obj": null}`
+	assert.NoError(t, toTest(strings.NewReader(input), output))
+	assert.Equal(t, "temp: 12.5 {\"a\":1}\nhum: 50 null\n", output.String())
+}
+
+func TestTemplateStage_invalidArguments(t *testing.T) {
+	_, err := newTemplateStage(chainEnv{}, nil)
+	assert.Equal(t, "template: invalid arguments\nUsage: template <template>", err.Error())
+
+	_, err = newTemplateStage(chainEnv{}, []string{"{{"})
+	assert.Equal(t, "template: template: template:1: unclosed action", err.Error())
+}
+
+func TestPubStage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	done := make(chan struct{})
+	close(done)
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Done().Return(done).Times(2)
+	mockToken.EXPECT().Error().Return(nil).Times(2)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Publish(gomock.Eq("out/topic"), gomock.Eq(byte(1)), gomock.Eq(true), gomock.Eq([]byte("line1"))).Return(mockToken)
+	mockMqtt.EXPECT().Publish(gomock.Eq("out/topic"), gomock.Eq(byte(1)), gomock.Eq(true), gomock.Eq([]byte("line2"))).Return(mockToken)
+
+	toTest, err := newPubStage(chainEnv{client: mockMqtt}, []string{"-r", "-q", "1", "out/topic"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, toTest(strings.NewReader("line1\n\nline2\n"), nil))
+}
+
+func TestPubStage_placeholders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	done := make(chan struct{})
+	close(done)
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("in/device")
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Done().Return(done)
+	mockToken.EXPECT().Error().Return(nil)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Publish(gomock.Eq("out/in/device"), gomock.Eq(byte(0)), gomock.Eq(false), gomock.Eq([]byte("line1"))).Return(mockToken)
+
+	toTest, err := newPubStage(chainEnv{client: mockMqtt, message: testMessage}, []string{"out/{topic}"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, toTest(strings.NewReader("line1\n"), nil))
+}
+
+func TestPubStage_all(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Done().Return(make(chan struct{}))
+	mockToken.EXPECT().Wait().Return(true)
+	mockToken.EXPECT().Error().Return(nil)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Publish(gomock.Eq("out/topic"), gomock.Eq(byte(0)), gomock.Eq(false), gomock.Eq([]byte("line1\nline2"))).Return(mockToken)
+
+	toTest, err := newPubStage(chainEnv{client: mockMqtt}, []string{"-a", "out/topic"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, toTest(strings.NewReader("line1\nline2\n"), nil))
+}
+
+func TestPubStage_asyncError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Done().Return(make(chan struct{})).Times(3)
+	mockToken.EXPECT().Wait().Return(true).Times(2)
+	gomock.InOrder(
+		mockToken.EXPECT().Error().Return(nil),
+		mockToken.EXPECT().Error().Return(errors.New("someError")),
+	)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Publish(gomock.Eq("out/topic"), gomock.Eq(byte(1)), gomock.Eq(false), gomock.Any()).Return(mockToken).Times(2)
+
+	toTest, err := newPubStage(chainEnv{client: mockMqtt}, []string{"-q", "1", "out/topic"})
+	assert.NoError(t, err)
+
+	//the failure of the acknowledgement will be known after the stage has published all messages
+	assert.EqualError(t, toTest(strings.NewReader("line1\nline2\n"), nil), "someError")
+}
+
+func TestPubStage_synchronous(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Done().Return(make(chan struct{})).Times(2)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Publish(gomock.Eq("out/topic"), gomock.Eq(byte(1)), gomock.Eq(false), gomock.Eq([]byte("line1"))).Return(mockToken)
+
+	toTest, err := newPubStage(chainEnv{client: mockMqtt, synchronous: true}, []string{"-q", "1", "out/topic"})
+	assert.NoError(t, err)
+
+	//inside a message handler the stage must not wait for the acknowledgement
+	assert.NoError(t, toTest(strings.NewReader("line1\n"), nil))
+}
+
+func TestPubStage_publishError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	done := make(chan struct{})
+	close(done)
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Done().Return(done)
+	mockToken.EXPECT().Error().Return(errors.New("someError"))
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Publish(gomock.Eq("out/topic"), gomock.Eq(byte(0)), gomock.Eq(false), gomock.Eq([]byte("line1"))).Return(mockToken)
+
+	toTest, err := newPubStage(chainEnv{client: mockMqtt}, []string{"out/topic"})
+	assert.NoError(t, err)
+
+	assert.EqualError(t, toTest(strings.NewReader("line1\nline2\n"), nil), "someError")
+}
+
+func TestPubStage_guard(t *testing.T) {
+	env := chainEnv{guard: &PublishGuard{Protected: true, BlockTopics: []string{"system/#"}}}
+
+	_, err := newPubStage(env, []string{"system/topic"})
+	assert.EqualError(t, err, "pub: publishing to system/topic is blocked\nUsage: pub [-r] [-q 0|1|2] [-a] <topic>")
+
+	//nobody can confirm the publish while the chain is running
+	_, err = newPubStage(env, []string{"-r", "out/topic"})
+	assert.EqualError(t, err, "pub: publishing to out/topic requires a confirmation\nUsage: pub [-r] [-q 0|1|2] [-a] <topic>")
+
+	_, err = newPubStage(env, []string{"out/topic"})
+	assert.NoError(t, err)
+}
+
+func TestPubStage_invalidArguments(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{}, "pub: invalid arguments\nUsage: pub [-r] [-q 0|1|2] [-a] <topic>"},
+		{[]string{"-q"}, "pub: invalid arguments\nUsage: pub [-r] [-q 0|1|2] [-a] <topic>"},
+		{[]string{"-q", "NAN", "topic"}, "pub: invalid qos level: strconv.Atoi: parsing \"NAN\": invalid syntax\nUsage: pub [-r] [-q 0|1|2] [-a] <topic>"},
+		{[]string{"-q", "3", "topic"}, "pub: invalid qos level\nUsage: pub [-r] [-q 0|1|2] [-a] <topic>"},
+		{[]string{"topic", "payload"}, "pub: invalid arguments\nUsage: pub [-r] [-q 0|1|2] [-a] <topic>"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestPubStage_invalidArguments_%d", i), func(t *testing.T) {
+			_, err := newPubStage(chainEnv{}, test.args)
+			assert.EqualError(t, err, test.expected)
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	captureFormatJson      = "jsonl"
+	captureFormatBinary    = "binary"
+	captureFormatMosquitto = "mosquitto"
+	captureFormatCsv       = "csv"
+	captureFormatPcap      = "pcap"
+)
+
+// captureMaxPayloadSize is the maximum size of a payload inside a binary capture file (the maximum size of a mqtt packet)
+const captureMaxPayloadSize = 268435455
+
+// captureMagic is the header of binary capture files
+var captureMagic = []byte("MQSC\x01")
+
+// captureReader returns the next record of a capture file (or io.EOF at its end)
+type captureReader func() (captureRecord, error)
+
+// captureFormat describes how a capture file will be written and read
+type captureFormat struct {
+	//header will be written at the beginning of each capture file
+	header []byte
+
+	//encode is nil if the format can not be written by the shell
+	encode captureEncoder
+	decode func(r *bufio.Reader) (captureReader, error)
+}
+
+var captureFormats = map[string]captureFormat{
+	captureFormatJson:      {encode: encodeJsonRecord, decode: decodeJsonCapture},
+	captureFormatBinary:    {header: captureMagic, encode: encodeBinaryRecord, decode: decodeBinaryCapture},
+	captureFormatMosquitto: {encode: encodeMosquittoRecord, decode: decodeMosquittoCapture},
+	captureFormatCsv:       {header: []byte(strings.Join(csvCaptureColumns, ",") + "\n"), encode: encodeCsvRecord, decode: decodeCsvCapture},
+	captureFormatPcap:      {decode: decodePcapCapture},
+}
+
+// openCapture opens the capture file. If no format is given, it will be detected automatically.
+func openCapture(file, format string) (captureReader, io.Closer, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := bufio.NewReader(f)
+	if format == "" {
+		format = detectCaptureFormat(r, file)
+	}
+
+	next, err := captureFormats[format].decode(r)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return next, f, nil
+}
+
+// detectCaptureFormat detects the format by the magic number of the file, its extension or its first byte
+func detectCaptureFormat(r *bufio.Reader, file string) string {
+	if header, _ := r.Peek(len(captureMagic)); bytes.Equal(header, captureMagic) {
+		return captureFormatBinary
+	}
+	if header, _ := r.Peek(4); isPcapMagic(header) {
+		return captureFormatPcap
+	}
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		return captureFormatCsv
+	}
+	if first, _ := r.Peek(1); len(first) == 1 && first[0] == '{' {
+		return captureFormatJson
+	}
+	return captureFormatMosquitto
+}
+
+func decodeJsonCapture(r *bufio.Reader) (captureReader, error) {
+	decoder := json.NewDecoder(r)
+	number := 0
+	return func() (record captureRecord, err error) {
+		if err = decoder.Decode(&record); err != nil {
+			return
+		}
+		number++
+
+		if record.Qos > 2 {
+			return record, fmt.Errorf("invalid qos in record %d", number)
+		}
+		return record, nil
+	}, nil
+}
+
+func decodeBinaryCapture(r *bufio.Reader) (captureReader, error) {
+	header := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header, captureMagic) {
+		return nil, errors.New("invalid binary capture file")
+	}
+
+	number := 0
+	return func() (record captureRecord, err error) {
+		header := make([]byte, 8+1+2)
+		if _, err = io.ReadFull(r, header); err != nil {
+			//EOF will only be returned if there is no (partial) record
+			return
+		}
+		number++
+		record.Time = time.Unix(0, int64(binary.BigEndian.Uint64(header))).UTC()
+		record.Qos = header[8] & 0x03
+		record.Retained = header[8]&0x04 != 0
+		if record.Qos > 2 {
+			return record, fmt.Errorf("invalid qos in record %d", number)
+		}
+
+		topic := make([]byte, binary.BigEndian.Uint16(header[9:]))
+		if _, err = io.ReadFull(r, topic); err != nil {
+			return record, unexpectedEOF(err)
+		}
+		record.Topic = string(topic)
+
+		length := make([]byte, 4)
+		if _, err = io.ReadFull(r, length); err != nil {
+			return record, unexpectedEOF(err)
+		}
+		size := binary.BigEndian.Uint32(length)
+		if size > captureMaxPayloadSize {
+			return record, fmt.Errorf("invalid binary capture file: the payload size %d exceeds the maximum of %d", size, captureMaxPayloadSize)
+		}
+		//the buffer grows with the read data: a corrupt size will not allocate the memory at once
+		payload := &bytes.Buffer{}
+		if _, err = io.CopyN(payload, r, int64(size)); err != nil {
+			return record, unexpectedEOF(err)
+		}
+		record.PayloadBase64 = payload.Bytes()
+
+		return record, nil
+	}, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// encodeMosquittoRecord writes the record like "mosquitto_sub -v" does: <topic> <payload>
+func encodeMosquittoRecord(w io.Writer, record captureRecord) error {
+	line := make([]byte, 0, len(record.Topic)+1+len(record.payload())+1)
+	line = append(line, record.Topic...)
+	line = append(line, ' ')
+	line = append(line, record.payload()...)
+	line = append(line, '\n')
+
+	_, err := w.Write(line)
+	return err
+}
+
+// mosquittoTimeLayouts are the layouts of the optional leading timestamp (mosquitto_sub -F "%I %t %p")
+var mosquittoTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05-0700"}
+
+// decodeMosquittoCapture reads the output of "mosquitto_sub -v". Each line may start with a timestamp.
+func decodeMosquittoCapture(r *bufio.Reader) (captureReader, error) {
+	return func() (record captureRecord, err error) {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil && (err != io.EOF || line == "") {
+				return record, err
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" {
+				continue
+			}
+
+			fields := strings.SplitN(line, " ", 2)
+			for _, layout := range mosquittoTimeLayouts {
+				if t, tErr := time.Parse(layout, fields[0]); tErr == nil && len(fields) == 2 {
+					record.Time = t
+					fields = strings.SplitN(fields[1], " ", 2)
+					break
+				}
+			}
+
+			record.Topic = fields[0]
+			payload := ""
+			if len(fields) == 2 {
+				payload = fields[1]
+			}
+			record.Payload = &payload
+
+			return record, nil
+		}
+	}, nil
+}
+
+var csvCaptureColumns = []string{"time", "topic", "qos", "retained", "payload", "payloadBase64"}
+
+// encodeCsvRecord writes the record as csv line (see csvCaptureColumns)
+func encodeCsvRecord(w io.Writer, record captureRecord) error {
+	payload, payloadBase64 := "", ""
+	if record.Payload != nil {
+		payload = *record.Payload
+	} else {
+		payloadBase64 = base64.StdEncoding.EncodeToString(record.PayloadBase64)
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		record.Time.Format(time.RFC3339Nano),
+		record.Topic,
+		strconv.Itoa(int(record.Qos)),
+		strconv.FormatBool(record.Retained),
+		payload,
+		payloadBase64,
+	})
+	writer.Flush()
+	return writer.Error()
+}
+
+// decodeCsvCapture reads csv files with a header line. Only the topic column is mandatory, the order of
+// the columns does not matter.
+func decodeCsvCapture(r *bufio.Reader) (captureReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	if _, ok := columns["topic"]; !ok {
+		return nil, errors.New("invalid csv header: missing topic column")
+	}
+
+	number := 0
+	return func() (record captureRecord, err error) {
+		line, err := reader.Read()
+		if err != nil {
+			return record, err
+		}
+		number++
+
+		value := func(column string) string {
+			if i, ok := columns[strings.ToLower(column)]; ok && i < len(line) {
+				return line[i]
+			}
+			return ""
+		}
+
+		record.Topic = value("topic")
+		if v := value("time"); v != "" {
+			if record.Time, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				return record, fmt.Errorf("invalid time in record %d: %w", number, err)
+			}
+		}
+		if v := value("qos"); v != "" {
+			qos, err := strconv.ParseUint(v, 10, 8)
+			if err != nil || qos > 2 {
+				return record, fmt.Errorf("invalid qos in record %d", number)
+			}
+			record.Qos = byte(qos)
+		}
+		if v := value("retained"); v != "" {
+			if record.Retained, err = strconv.ParseBool(v); err != nil {
+				return record, fmt.Errorf("invalid retained flag in record %d", number)
+			}
+		}
+		if v := value("payloadBase64"); v != "" {
+			if record.PayloadBase64, err = base64.StdEncoding.DecodeString(v); err != nil {
+				return record, fmt.Errorf("invalid base64 payload in record %d", number)
+			}
+		} else {
+			payload := value("payload")
+			record.Payload = &payload
+		}
+
+		return record, nil
+	}, nil
+}
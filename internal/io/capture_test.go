@@ -0,0 +1,209 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenCapture(t *testing.T) {
+	records := []captureRecord{
+		testCaptureRecord(0, "a/topic", []byte("PAYLOAD"), 1, true),
+		testCaptureRecord(time.Second, "b/topic", []byte{0xff, 0x00}, 2, false),
+		testCaptureRecord(2*time.Second, "c/topic", []byte{}, 0, false),
+	}
+
+	tests := []struct {
+		file   string
+		format string
+	}{
+		{"capture.jsonl", captureFormatJson},
+		{"capture.bin", captureFormatBinary},
+		{"capture.csv", captureFormatCsv},
+	}
+	for _, test := range tests {
+		t.Run("TestOpenCapture_"+test.format, func(t *testing.T) {
+			captureFile := path.Join(os.TempDir(), test.file)
+			defer os.Remove(captureFile)
+
+			writeTestCapture(t, captureFile, test.format, records...)
+
+			next, closer, err := openCapture(captureFile, "")
+			assert.NoError(t, err)
+			defer closer.Close()
+
+			for _, expected := range records {
+				record, err := next()
+				assert.NoError(t, err)
+				assert.Equal(t, expected.Time, record.Time)
+				assert.Equal(t, expected.Topic, record.Topic)
+				assert.Equal(t, expected.Qos, record.Qos)
+				assert.Equal(t, expected.Retained, record.Retained)
+				assert.Equal(t, string(expected.payload()), string(record.payload()))
+			}
+
+			_, err = next()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestOpenCapture_truncated(t *testing.T) {
+	captureFile := path.Join(os.TempDir(), "truncated.bin")
+	defer os.Remove(captureFile)
+
+	writeTestCapture(t, captureFile, captureFormatBinary, testCaptureRecord(0, "a/topic", []byte("PAYLOAD"), 1, true))
+	content, _ := os.ReadFile(captureFile)
+	os.WriteFile(captureFile, content[:len(content)-2], 0644)
+
+	next, closer, err := openCapture(captureFile, "")
+	assert.NoError(t, err)
+	defer closer.Close()
+
+	_, err = next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestDecodeBinaryCapture_payloadTooLarge(t *testing.T) {
+	content := append([]byte{}, captureMagic...)
+	content = append(content, make([]byte, 8)...)          //time
+	content = append(content, 0x00, 0x00, 0x01, 'a')       //flags and topic
+	content = append(content, 0xff, 0xff, 0xff, 0xff, 'P') //payload size and (truncated) payload
+
+	next, err := decodeBinaryCapture(bufio.NewReader(bytes.NewReader(content)))
+	assert.NoError(t, err)
+
+	_, err = next()
+	assert.EqualError(t, err, "invalid binary capture file: the payload size 4294967295 exceeds the maximum of 268435455")
+}
+
+func TestDecodeJsonCapture_invalidQos(t *testing.T) {
+	content := `{"topic":"a","qos":1,"payload":"1"}
+{"topic":"a","qos":3,"payload":"2"}
+`
+	next, err := decodeJsonCapture(bufio.NewReader(strings.NewReader(content)))
+	assert.NoError(t, err)
+
+	_, err = next()
+	assert.NoError(t, err)
+	_, err = next()
+	assert.EqualError(t, err, "invalid qos in record 2")
+}
+
+func TestDecodeBinaryCapture_invalidQos(t *testing.T) {
+	content := append([]byte{}, captureMagic...)
+	content = append(content, make([]byte, 8)...)     //time
+	content = append(content, 0x03, 0x00, 0x01, 'a')  //flags (qos 3) and topic
+	content = append(content, 0x00, 0x00, 0x00, 0x00) //payload size
+
+	next, err := decodeBinaryCapture(bufio.NewReader(bytes.NewReader(content)))
+	assert.NoError(t, err)
+
+	_, err = next()
+	assert.EqualError(t, err, "invalid qos in record 1")
+}
+
+func TestOpenCapture_explicitFormat(t *testing.T) {
+	captureFile := path.Join(os.TempDir(), "capture.txt")
+	defer os.Remove(captureFile)
+
+	os.WriteFile(captureFile, []byte("topic,payload\na/topic,PAYLOAD\n"), 0644)
+
+	next, closer, err := openCapture(captureFile, captureFormatCsv)
+	assert.NoError(t, err)
+	defer closer.Close()
+
+	record, err := next()
+	assert.NoError(t, err)
+	assert.Equal(t, "a/topic", record.Topic)
+	assert.Equal(t, "PAYLOAD", string(record.payload()))
+}
+
+func TestDetectCaptureFormat(t *testing.T) {
+	tests := []struct {
+		file     string
+		content  string
+		expected string
+	}{
+		{"capture", string(captureMagic), captureFormatBinary},
+		{"capture", "\xd4\xc3\xb2\xa1\x02\x00", captureFormatPcap},
+		{"capture", "\xa1\xb2\x3c\x4d\x00\x02", captureFormatPcap},
+		{"capture.CSV", "time,topic", captureFormatCsv},
+		{"capture", `{"topic":"a"}`, captureFormatJson},
+		{"capture.log", "a/topic payload", captureFormatMosquitto},
+		{"capture", "", captureFormatMosquitto},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestDetectCaptureFormat_%d", i), func(t *testing.T) {
+			assert.Equal(t, test.expected, detectCaptureFormat(bufio.NewReader(strings.NewReader(test.content)), test.file))
+		})
+	}
+}
+
+func TestDecodeMosquittoCapture(t *testing.T) {
+	next, err := decodeMosquittoCapture(bufio.NewReader(strings.NewReader("a/topic some payload\r\n" +
+		"\n" +
+		"b/topic\n" +
+		"2021-06-01T12:00:00+0000 c/topic 1\n" +
+		"2021-06-01T12:00:01.5Z d/topic {\"a\": 1}")))
+	assert.NoError(t, err)
+
+	expected := []struct {
+		time    time.Time
+		topic   string
+		payload string
+	}{
+		{time.Time{}, "a/topic", "some payload"},
+		{time.Time{}, "b/topic", ""},
+		{time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC), "c/topic", "1"},
+		{time.Date(2021, 6, 1, 12, 0, 1, 500000000, time.UTC), "d/topic", `{"a": 1}`},
+	}
+	for _, e := range expected {
+		record, err := next()
+		assert.NoError(t, err)
+		assert.True(t, e.time.Equal(record.Time), "%s != %s", e.time, record.Time)
+		assert.Equal(t, e.topic, record.Topic)
+		assert.Equal(t, e.payload, string(record.payload()))
+	}
+
+	_, err = next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestEncodeMosquittoRecord(t *testing.T) {
+	sb := &strings.Builder{}
+
+	assert.NoError(t, encodeMosquittoRecord(sb, testCaptureRecord(0, "a/topic", []byte("PAYLOAD"), 1, true)))
+	assert.Equal(t, "a/topic PAYLOAD\n", sb.String())
+}
+
+func TestDecodeCsvCapture_invalid(t *testing.T) {
+	_, err := decodeCsvCapture(bufio.NewReader(strings.NewReader("time,payload\n")))
+	assert.EqualError(t, err, "invalid csv header: missing topic column")
+
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"yesterday,a,0,false", `invalid time in record 1: parsing time "yesterday" as "2006-01-02T15:04:05.999999999Z07:00": cannot parse "yesterday" as "2006"`},
+		{",a,3,false", "invalid qos in record 1"},
+		{",a,0,maybe", "invalid retained flag in record 1"},
+		{",a,0,false,,!!!", "invalid base64 payload in record 1"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestDecodeCsvCapture_invalid_%d", i), func(t *testing.T) {
+			next, err := decodeCsvCapture(bufio.NewReader(strings.NewReader(strings.Join(csvCaptureColumns, ",") + "\n" + test.line + "\n")))
+			assert.NoError(t, err)
+
+			_, err = next()
+			assert.EqualError(t, err, test.expected)
+		})
+	}
+}
@@ -9,4 +9,22 @@ const (
 	commandUnsub      = "unsub"
 	commandMacro      = ".macro"
 	commandListColors = ".lsc"
+	commandFwd        = "fwd"
+	commandJobs       = "jobs"
+	commandKill       = "kill"
+	commandTree       = ".tree"
+	commandRetained   = "retained"
+	commandLast       = "last"
+	commandDiff       = "diff"
+	commandStats      = ".stats"
+	commandStatus     = ".status"
+	commandPing       = "ping"
+	commandRecord     = "record"
+	commandReplay     = "replay"
+)
+
+const (
+	builtinSelect   = "select"
+	builtinTemplate = "template"
+	builtinPub      = commandPub
 )
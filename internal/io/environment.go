@@ -0,0 +1,98 @@
+package io
+
+import (
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var placeholderRegex = regexp.MustCompile(`\\?{(topic|date|[0-9]+)}`)
+
+// wrap function for monkey patching purposes (unit tests)
+var timeNow = time.Now
+
+// chainEnv contains everything a chain needs for its execution
+type chainEnv struct {
+	client mqtt.Client
+
+	//the guard of the publishes (can be nil)
+	guard *PublishGuard
+
+	//the message which causes the execution (only available for short term chains)
+	message mqtt.Message
+
+	//if set, all started processes of the chain will be collected
+	processes *processList
+
+	//true if the chain is executed inside the message handler of the mqtt client. Then it must not wait for any
+	//acknowledgement: the client would not be able to process it.
+	synchronous bool
+}
+
+// variables returns the environment variables (key-value pairs) for the external applications
+func (e chainEnv) variables() []interface{} {
+	if e.message == nil {
+		return nil
+	}
+
+	topic := e.message.Topic()
+	variables := []interface{}{
+		"MQTT_TOPIC", topic,
+		"MQTT_QOS", e.message.Qos(),
+		"MQTT_RETAINED", e.message.Retained(),
+		"MQTT_MESSAGE_ID", e.message.MessageID(),
+	}
+	for i, level := range strings.Split(topic, "/") {
+		variables = append(variables, fmt.Sprintf("MQTT_TOPIC_%d", i+1), level)
+	}
+
+	return variables
+}
+
+// expand replaces the placeholders ({date}, {topic} and the topic levels {1}...{n}) in all given arguments.
+// Placeholders can be escaped by a leading backslash. The topic placeholders are only available if there
+// is a message.
+func (e chainEnv) expand(args []string) []string {
+	if len(args) == 0 {
+		return args
+	}
+
+	var topic string
+	var levels []string
+	if e.message != nil {
+		topic = e.message.Topic()
+		levels = strings.Split(topic, "/")
+	}
+
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = placeholderRegex.ReplaceAllStringFunc(arg, func(placeholder string) string {
+			if strings.HasPrefix(placeholder, "\\") {
+				//escaped placeholder
+				return placeholder[1:]
+			}
+
+			name := placeholder[1 : len(placeholder)-1]
+			if name == "date" {
+				return timeNow().Format("2006-01-02")
+			}
+			if e.message == nil {
+				return placeholder
+			}
+			if name == "topic" {
+				return topic
+			}
+
+			level, _ := strconv.Atoi(name)
+			if level < 1 || level > len(levels) {
+				return ""
+			}
+			return levels[level-1]
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,63 @@
+package io
+
+import (
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestChainEnv_variables(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("a/b/c")
+	testMessage.EXPECT().Qos().Return(byte(1))
+	testMessage.EXPECT().Retained().Return(true)
+	testMessage.EXPECT().MessageID().Return(uint16(13))
+
+	toTest := chainEnv{message: testMessage}
+
+	assert.Equal(t, []interface{}{
+		"MQTT_TOPIC", "a/b/c",
+		"MQTT_QOS", byte(1),
+		"MQTT_RETAINED", true,
+		"MQTT_MESSAGE_ID", uint16(13),
+		"MQTT_TOPIC_1", "a",
+		"MQTT_TOPIC_2", "b",
+		"MQTT_TOPIC_3", "c",
+	}, toTest.variables())
+}
+
+func TestChainEnv_variables_noMessage(t *testing.T) {
+	assert.Nil(t, chainEnv{}.variables())
+}
+
+func TestChainEnv_expand(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("a/b/c")
+
+	toTest := chainEnv{message: testMessage}
+
+	assert.Equal(t,
+		[]string{"a/b/c", "a-b-c", "", "", "{other}", "out/{topic}"},
+		toTest.expand([]string{"{topic}", "{1}-{2}-{3}", "{4}", "{0}", "{other}", "out/\\{topic}"}),
+	)
+}
+
+func TestChainEnv_expand_noMessage(t *testing.T) {
+	otn := timeNow
+	defer func() {
+		timeNow = otn
+	}()
+	timeNow = func() time.Time {
+		return time.Date(2021, 8, 15, 16, 15, 0, 0, time.UTC)
+	}
+
+	assert.Equal(t, []string{"{topic}", "{1}", "2021-08-15"}, chainEnv{}.expand([]string{"{topic}", "{1}", "{date}"}))
+}
@@ -0,0 +1,146 @@
+package io
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ExecutionOptions controls the execution of short term chains
+type ExecutionOptions struct {
+	//the maximum count of parallel executions. If it is zero, the chains will be executed
+	//synchronously inside the message handler (one after another).
+	Workers int
+
+	//if true, the messages of one subscription will be executed one after another
+	Ordered bool
+
+	//the maximum duration of one execution (zero means no timeout)
+	Timeout time.Duration
+
+	//the size of the queue for messages which are waiting for their execution
+	QueueSize int
+
+	//if true, messages will be dropped if the queue is full. Otherwise the message handler will block.
+	Drop bool
+}
+
+type executionStats struct {
+	//must be the first fields (64bit alignment for atomic operations)
+	executed uint64
+	dropped  uint64
+	timedOut uint64
+}
+
+func (s *executionStats) String() string {
+	return fmt.Sprintf("executed: %d, dropped: %d, timed out: %d",
+		atomic.LoadUint64(&s.executed), atomic.LoadUint64(&s.dropped), atomic.LoadUint64(&s.timedOut))
+}
+
+// executor executes the short term chains with limited concurrency
+type executor struct {
+	opts ExecutionOptions
+
+	//limits the count of parallel executions over all lanes
+	slots chan struct{}
+
+	//in unordered mode all subscriptions share the same lane
+	shared *lane
+}
+
+// lane is a queue of tasks which will be executed by one or more workers
+type lane struct {
+	tasks chan func()
+	done  chan interface{}
+}
+
+func newExecutor(opts ExecutionOptions) *executor {
+	e := &executor{opts: opts}
+	if opts.Workers <= 0 {
+		return e
+	}
+
+	e.slots = make(chan struct{}, opts.Workers)
+	if !opts.Ordered {
+		e.shared = e.startLane(opts.Workers)
+	}
+	return e
+}
+
+// newLane returns the lane for a new subscription. Returns nil if the chains should be executed synchronously.
+func (e *executor) newLane() *lane {
+	if e.opts.Workers <= 0 {
+		return nil
+	}
+	if e.shared != nil {
+		return e.shared
+	}
+
+	//each subscription has its own queue so that the messages will be executed in order
+	return e.startLane(1)
+}
+
+func (e *executor) startLane(workers int) *lane {
+	queueSize := e.opts.QueueSize
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	l := &lane{
+		tasks: make(chan func(), queueSize),
+		done:  make(chan interface{}),
+	}
+	for i := 0; i < workers; i++ {
+		go e.work(l)
+	}
+	return l
+}
+
+func (e *executor) work(l *lane) {
+	for {
+		select {
+		case <-l.done:
+			return
+		case task := <-l.tasks:
+			e.slots <- struct{}{}
+			task()
+			<-e.slots
+		}
+	}
+}
+
+// submit adds the task to the queue of the lane. Returns false if the task was dropped.
+func (l *lane) submit(task func(), drop bool) bool {
+	if drop {
+		select {
+		case l.tasks <- task:
+			return true
+		case <-l.done:
+			return false
+		default:
+			return false
+		}
+	}
+
+	select {
+	case l.tasks <- task:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// stop stops all workers of the lane. Tasks which are still queued will not be executed.
+func (l *lane) stop() {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+}
+
+func (e *executor) close() {
+	if e.shared != nil {
+		e.shared.stop()
+	}
+}
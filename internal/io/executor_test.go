@@ -0,0 +1,224 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecutor_synchronous(t *testing.T) {
+	toTest := newExecutor(ExecutionOptions{})
+
+	assert.Nil(t, toTest.newLane())
+}
+
+func TestExecutor_unordered_maxConcurrency(t *testing.T) {
+	toTest := newExecutor(ExecutionOptions{Workers: 2, QueueSize: 10})
+	defer toTest.close()
+
+	lane := toTest.newLane()
+	assert.Same(t, lane, toTest.newLane(), "all subscriptions should share the same lane")
+
+	running, maxRunning := int32(0), int32(0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		assert.True(t, lane.submit(func() {
+			defer wg.Done()
+
+			current := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}, false))
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), maxRunning)
+}
+
+func TestExecutor_ordered(t *testing.T) {
+	toTest := newExecutor(ExecutionOptions{Workers: 4, Ordered: true, QueueSize: 10})
+	defer toTest.close()
+
+	lane := toTest.newLane()
+	defer lane.stop()
+	assert.NotSame(t, lane, toTest.newLane(), "each subscription should have its own lane")
+
+	mutex := sync.Mutex{}
+	var executed []int
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(1)
+		lane.submit(func() {
+			defer wg.Done()
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			executed = append(executed, i)
+		}, false)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, executed)
+}
+
+func TestExecutor_dropIfQueueIsFull(t *testing.T) {
+	toTest := newExecutor(ExecutionOptions{Workers: 1, QueueSize: 1})
+	defer toTest.close()
+
+	lane := toTest.newLane()
+	block := make(chan struct{})
+	started := make(chan struct{})
+
+	assert.True(t, lane.submit(func() {
+		close(started)
+		<-block
+	}, true))
+	<-started
+
+	assert.True(t, lane.submit(func() {}, true), "the queue has space for one task")
+	assert.False(t, lane.submit(func() {}, true), "the queue is full")
+
+	close(block)
+}
+
+func TestGenSubHandler_shortTermSub_timeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ognd := getNextDecorator
+	defer func() {
+		getNextDecorator = ognd
+	}()
+	getNextDecorator = func() decorator {
+		return []string{"1"}
+	}
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+	toTest.Execution.Timeout = 100 * time.Millisecond
+
+	//the child process of the shell must be killed too (otherwise the output would be kept open)
+	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | sh -c "sleep 10; echo done"`, commandSub))
+	assert.NoError(t, err)
+
+	fn, err := genSubHandler(toTest, "a/topic", testChain, subOptions{})
+	assert.NoError(t, err)
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("a/topic").AnyTimes()
+	testMessage.EXPECT().Qos().Return(byte(1)).AnyTimes()
+	testMessage.EXPECT().Retained().Return(false).AnyTimes()
+	testMessage.EXPECT().MessageID().Return(uint16(1)).AnyTimes()
+	testMessage.EXPECT().Payload().Return([]byte("PAYLOAD"))
+
+	start := time.Now()
+	fn(nil, testMessage)
+
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, "\x1b[1ma/topic |\x1b[0m execution timed out after 100ms\n", output.String())
+	assert.Equal(t, "executed: 1, dropped: 0, timed out: 1", toTest.executions["a/topic"].stats.String())
+}
+
+func TestGenSubHandler_shortTermSub_workers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ognd := getNextDecorator
+	defer func() {
+		getNextDecorator = ognd
+	}()
+	getNextDecorator = func() decorator {
+		return []string{"1"}
+	}
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+	toTest.Execution = ExecutionOptions{Workers: 1, QueueSize: 1, Drop: true}
+
+	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | sleep 0.2`, commandSub))
+	assert.NoError(t, err)
+
+	fn, err := genSubHandler(toTest, "a/topic", testChain, subOptions{})
+	assert.NoError(t, err)
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("a/topic").AnyTimes()
+	testMessage.EXPECT().Qos().Return(byte(1)).AnyTimes()
+	testMessage.EXPECT().Retained().Return(false).AnyTimes()
+	testMessage.EXPECT().MessageID().Return(uint16(1)).AnyTimes()
+	testMessage.EXPECT().Payload().Return([]byte("PAYLOAD")).AnyTimes()
+
+	stats := toTest.executions["a/topic"].stats
+
+	//the handler must not block: the messages will be dropped if the queue is full
+	start := time.Now()
+	assert.Eventually(t, func() bool {
+		fn(nil, testMessage)
+		return atomic.LoadUint64(&stats.dropped) > 0
+	}, 1*time.Second, 1*time.Millisecond)
+	assert.True(t, time.Since(start) < 200*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadUint64(&stats.executed) >= 1
+	}, 1*time.Second, 10*time.Millisecond)
+
+	toTest.subscribedTopics["a/topic"] = subscription{}
+	toTest.Process(filledChan(commandList + " -l"))
+	assert.Regexp(t, `^a/topic \(executed: [1-9], dropped: [1-9][0-9]*, timed out: 0\)\n$`, output.String())
+}
+
+func TestGenSubHandler_shortTermSub_sharedLaneAfterUnsub(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+	toTest.Execution = ExecutionOptions{Workers: 1, QueueSize: 10}
+
+	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | cat`, commandSub))
+	assert.NoError(t, err)
+
+	fn, err := genSubHandler(toTest, "a/topic", testChain, subOptions{})
+	assert.NoError(t, err)
+
+	//occupy the only worker of the shared lane
+	block := make(chan struct{})
+	started := make(chan struct{})
+	assert.True(t, toTest.executor.shared.submit(func() {
+		close(started)
+		<-block
+	}, false))
+	<-started
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("a/topic").AnyTimes()
+	for i := 0; i < 3; i++ {
+		fn(nil, testMessage)
+	}
+
+	exec := toTest.executions["a/topic"]
+	exec.stop()
+	close(block)
+
+	//the shared lane is still working: but the queued tasks of the stopped subscription must be skipped
+	done := make(chan struct{})
+	assert.True(t, toTest.executor.shared.submit(func() { close(done) }, false))
+	<-done
+
+	assert.Equal(t, uint64(0), atomic.LoadUint64(&exec.stats.executed))
+	assert.Equal(t, "", output.String())
+}
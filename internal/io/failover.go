@@ -0,0 +1,105 @@
+package io
+
+import (
+	"crypto/tls"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	brokerOrderFailover   = "failover"
+	brokerOrderRandom     = "random"
+	brokerOrderRoundRobin = "round-robin"
+)
+
+// OrderBrokers controls the order in which the brokers of the options will be tried on each (re-)connect. With
+// "failover" they are always tried in the given order (so the first available broker will be used), with "random" in
+// random order and with "round-robin" beginning with the broker after the last tried one. Existing handlers of the
+// options will be wrapped. So it must be called after all other handlers are set.
+//
+// The order is applied before each reconnect. The retries of the initial connection (ConnectRetry) are made by paho
+// without any handler call: so they will always use the initial order (which is random already with "random").
+func OrderBrokers(opts *mqtt.ClientOptions, order string) {
+	if order == brokerOrderFailover || len(opts.Servers) < 2 {
+		return
+	}
+
+	b := &brokerOrder{
+		order:  order,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if order == brokerOrderRandom {
+		opts.Servers = b.shuffle(opts.Servers)
+	}
+
+	onConnectAttempt := opts.OnConnectAttempt
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		b.attempted(broker)
+		if onConnectAttempt != nil {
+			return onConnectAttempt(broker, tlsCfg)
+		}
+		return tlsCfg
+	})
+
+	onReconnecting := opts.OnReconnecting
+	opts.SetReconnectingHandler(func(client mqtt.Client, options *mqtt.ClientOptions) {
+		//the client options are passed by reference: so the servers can be reordered before the next attempt. paho
+		//reads them (under its own lock, which is not accessible) in the same routine which calls this handler. The
+		//order is always applied to a copy: so a slice which was read before will never be changed.
+		options.Servers = b.reorder(options.Servers)
+		if onReconnecting != nil {
+			onReconnecting(client, options)
+		}
+	})
+}
+
+type brokerOrder struct {
+	order  string
+	random *rand.Rand
+
+	mutex sync.Mutex
+	last  string
+}
+
+func (b *brokerOrder) attempted(broker *url.URL) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.last = broker.String()
+}
+
+// reorder returns the servers in the order of the next attempt. The given slice will not be changed.
+func (b *brokerOrder) reorder(servers []*url.URL) []*url.URL {
+	switch b.order {
+	case brokerOrderRandom:
+		return b.shuffle(servers)
+	case brokerOrderRoundRobin:
+		return b.rotate(servers)
+	}
+	return servers
+}
+
+func (b *brokerOrder) shuffle(servers []*url.URL) []*url.URL {
+	shuffled := append([]*url.URL{}, servers...)
+	b.random.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// rotate returns the servers rotated so that the server after the last tried one is the first
+func (b *brokerOrder) rotate(servers []*url.URL) []*url.URL {
+	b.mutex.Lock()
+	last := b.last
+	b.mutex.Unlock()
+
+	for i, server := range servers {
+		if server.String() == last {
+			return append(append([]*url.URL{}, servers[i+1:]...), servers[:i+1]...)
+		}
+	}
+	return servers
+}
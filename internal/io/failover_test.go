@@ -0,0 +1,83 @@
+package io
+
+import (
+	"crypto/tls"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"sort"
+	"testing"
+)
+
+func testBrokers(opts *mqtt.ClientOptions) []string {
+	brokers := make([]string, len(opts.Servers))
+	for i, server := range opts.Servers {
+		brokers[i] = server.String()
+	}
+	return brokers
+}
+
+func TestOrderBrokers_failover(t *testing.T) {
+	opts := mqtt.NewClientOptions().AddBroker("tcp://a:1883").AddBroker("tcp://b:1883")
+
+	OrderBrokers(opts, brokerOrderFailover)
+
+	assert.Nil(t, opts.OnReconnecting, "the brokers should be tried in the given order")
+	assert.Equal(t, []string{"tcp://a:1883", "tcp://b:1883"}, testBrokers(opts))
+}
+
+func TestOrderBrokers_roundRobin(t *testing.T) {
+	opts := mqtt.NewClientOptions().AddBroker("tcp://a:1883").AddBroker("tcp://b:1883").AddBroker("tcp://c:1883")
+
+	var attempts []string
+	reconnects := 0
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		attempts = append(attempts, broker.String())
+		return tlsCfg
+	})
+	opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+		reconnects++
+	})
+
+	OrderBrokers(opts, brokerOrderRoundRobin)
+	assert.Equal(t, []string{"tcp://a:1883", "tcp://b:1883", "tcp://c:1883"}, testBrokers(opts))
+
+	//connected to "a"
+	read := opts.Servers
+	opts.OnConnectAttempt(opts.Servers[0], nil)
+	opts.OnReconnecting(nil, opts)
+	assert.Equal(t, []string{"tcp://b:1883", "tcp://c:1883", "tcp://a:1883"}, testBrokers(opts))
+	assert.Equal(t, "tcp://a:1883", read[0].String(), "a slice which was read before must not be changed")
+
+	//"b" is not available, connected to "c"
+	opts.OnConnectAttempt(opts.Servers[0], nil)
+	opts.OnConnectAttempt(opts.Servers[1], nil)
+	opts.OnReconnecting(nil, opts)
+	assert.Equal(t, []string{"tcp://a:1883", "tcp://b:1883", "tcp://c:1883"}, testBrokers(opts))
+
+	assert.Equal(t, []string{"tcp://a:1883", "tcp://b:1883", "tcp://c:1883"}, attempts, "the existing handler should be called")
+	assert.Equal(t, 2, reconnects, "the existing handler should be called")
+}
+
+func TestOrderBrokers_random(t *testing.T) {
+	opts := mqtt.NewClientOptions()
+	for _, broker := range []string{"tcp://a:1883", "tcp://b:1883", "tcp://c:1883", "tcp://d:1883"} {
+		opts.AddBroker(broker)
+	}
+
+	read := opts.Servers
+	OrderBrokers(opts, brokerOrderRandom)
+
+	orders := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		opts.OnReconnecting(nil, opts)
+
+		brokers := testBrokers(opts)
+		orders[brokers[0]] = true
+
+		sort.Strings(brokers)
+		assert.Equal(t, []string{"tcp://a:1883", "tcp://b:1883", "tcp://c:1883", "tcp://d:1883"}, brokers)
+	}
+	assert.Len(t, orders, 4, "each broker should be tried first at some point")
+	assert.Equal(t, "tcp://a:1883", read[0].String(), "a slice which was read before must not be changed")
+}
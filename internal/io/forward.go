@@ -0,0 +1,257 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	retainKeep = iota
+	retainForce
+	retainNever
+)
+
+type rewriteRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+type forwarding struct {
+	//must be the first fields (64bit alignment for atomic operations)
+	forwarded uint64
+	dropped   uint64
+
+	source   string
+	prefix   string
+	qos      int
+	retain   int
+	rewrites []rewriteRule
+
+	//the broker is only set if the messages should be forwarded to another broker
+	broker string
+	target mqtt.Client
+
+	//the sources of all forwardings on the own broker (can be nil)
+	sources *forwardSources
+	//guards the publishes on the own broker (can be nil)
+	guard *PublishGuard
+}
+
+// forwardSources contains the source filters of all forwardings on the own broker. It has its own lock because it
+// will be read by the message handlers (which must not wait for the processor's lock).
+type forwardSources struct {
+	mutex   sync.RWMutex
+	filters map[string]bool
+}
+
+func newForwardSources() *forwardSources {
+	return &forwardSources{filters: map[string]bool{}}
+}
+
+func (s *forwardSources) add(filter string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.filters[filter] = true
+}
+
+func (s *forwardSources) remove(filter string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.filters, filter)
+}
+
+// matching checks if the given topic would be received by any forwarding
+func (s *forwardSources) matching(topic string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for filter := range s.filters {
+		if topicMatches(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *forwarding) destination(topic string) string {
+	for _, rule := range f.rewrites {
+		topic = rule.pattern.ReplaceAllString(topic, rule.replacement)
+	}
+	return f.prefix + topic
+}
+
+func (f *forwarding) handle(_ mqtt.Client, message mqtt.Message) {
+	dst := f.destination(message.Topic())
+
+	if f.broker == "" && (topicMatches(f.source, dst) || f.sources.matching(dst)) {
+		//the forwarded message would be received again by our own (or another) forwarding -> endless loop
+		atomic.AddUint64(&f.dropped, 1)
+		return
+	}
+
+	qos := message.Qos()
+	if f.qos >= 0 {
+		qos = byte(f.qos)
+	}
+	retained := message.Retained()
+	switch f.retain {
+	case retainForce:
+		retained = true
+	case retainNever:
+		retained = false
+	}
+
+	if f.broker == "" && f.guard.check(retained, dst) != nil {
+		//nobody can confirm the publish of a forwarded message
+		atomic.AddUint64(&f.dropped, 1)
+		return
+	}
+
+	if err := publishNoWait(f.target, dst, qos, retained, message.Payload()); err != nil {
+		atomic.AddUint64(&f.dropped, 1)
+		return
+	}
+	atomic.AddUint64(&f.forwarded, 1)
+}
+
+func (f *forwarding) String() string {
+	target := f.prefix
+	if f.broker != "" {
+		target = f.broker + " " + target
+	}
+
+	return fmt.Sprintf("%s -> %s (forwarded: %d, dropped: %d)", f.source, target,
+		atomic.LoadUint64(&f.forwarded), atomic.LoadUint64(&f.dropped))
+}
+
+func (f *forwarding) close() {
+	if f.broker != "" {
+		f.target.Disconnect(250)
+	}
+}
+
+func (p *processor) handleFwd(chain Chain) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: "+commandFwd+" [-q 0|1|2] [-r|-nr] [-t <regex> <replacement>]... [-b <broker>] <src-filter> <dst-prefix>", err.Error())
+		}
+	}()
+
+	args := chain.Commands[0].Arguments
+	if len(args) == 0 {
+		//list all forwardings
+		sources := make([]string, 0, len(p.forwards))
+		for source := range p.forwards {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+
+		for _, source := range sources {
+			p.out.Write([]byte(p.forwards[source].String() + "\n"))
+		}
+		return nil
+	}
+
+	fwd := &forwarding{qos: -1, retain: retainKeep, target: p.client, sources: p.forwardSources}
+	positional := make([]string, 0, 2)
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-r":
+			fwd.retain = retainForce
+		case "-nr":
+			fwd.retain = retainNever
+		case "-q":
+			if i+1 >= len(args) {
+				return errors.New("invalid arguments")
+			}
+			fwd.qos, err = strconv.Atoi(args[i+1])
+			if err != nil {
+				return fmt.Errorf("invalid qos level: %w", err)
+			}
+			if fwd.qos < 0 || fwd.qos > 2 {
+				return errors.New("invalid qos level")
+			}
+			i++
+		case "-t":
+			if i+2 >= len(args) {
+				return errors.New("invalid arguments")
+			}
+			pattern, err := regexp.Compile(args[i+1])
+			if err != nil {
+				return fmt.Errorf("invalid rewrite rule: %w", err)
+			}
+			fwd.rewrites = append(fwd.rewrites, rewriteRule{pattern: pattern, replacement: args[i+2]})
+			i += 2
+		case "-b":
+			if i+1 >= len(args) {
+				return errors.New("invalid arguments")
+			}
+			fwd.broker = args[i+1]
+			i++
+		default:
+			positional = append(positional, args[i])
+		}
+	}
+
+	if len(positional) != 2 {
+		return errors.New("invalid arguments")
+	}
+	fwd.source, fwd.prefix = positional[0], positional[1]
+
+	if _, ok := p.subscribedTopics[fwd.source]; ok {
+		return fmt.Errorf("%s is already subscribed", fwd.source)
+	}
+	if fwd.broker == "" {
+		//the rewritten topics will be checked for each message
+		if err := p.Guard.check(fwd.retain == retainForce, fwd.prefix); err != nil {
+			return err
+		}
+		fwd.guard = p.Guard
+	}
+
+	if fwd.broker != "" {
+		if p.ClientFactory == nil {
+			return errors.New("forwarding to another broker is not supported")
+		}
+		fwd.target, err = p.ClientFactory(fwd.broker)
+		if err != nil {
+			return fmt.Errorf("unable to connect to broker: %w", err)
+		}
+	}
+
+	//without an explicit qos level the configured one is used (the forwarded messages will keep their received qos)
+	qos := fwd.qos
+	if qos < 0 {
+		qos = int(p.ForwardQos)
+	}
+	callback := p.observe(fwd.source, fwd.handle)
+	if token := p.client.Subscribe(fwd.source, byte(qos), callback); !token.Wait() {
+		fwd.close()
+		return token.Error()
+	}
+
+	if fwd.broker == "" {
+		p.forwardSources.add(fwd.source)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.forwards[fwd.source] = fwd
+	p.subscribedTopics[fwd.source] = subscription{qos: byte(qos), callback: callback}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package io
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestProcessor_Process_fwdCommand_invalidArguments(t *testing.T) {
+	usage := "\nUsage: fwd [-q 0|1|2] [-r|-nr] [-t <regex> <replacement>]... [-b <broker>] <src-filter> <dst-prefix>\n"
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"src/#"}, "invalid arguments" + usage},
+		{[]string{"src/#", "dst/", "more"}, "invalid arguments" + usage},
+		{[]string{"-q"}, "invalid arguments" + usage},
+		{[]string{"-q", "3", "src/#", "dst/"}, "invalid qos level" + usage},
+		{[]string{"-t", "a", "src/#", "dst/"}, "invalid arguments" + usage},
+		{[]string{"-t", "(", "", "src/#", "dst/"}, "invalid rewrite rule: error parsing regexp: missing closing ): `(`" + usage},
+		{[]string{"-b", "tcp://other:1883", "src/#", "dst/"}, "forwarding to another broker is not supported" + usage},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestProcessor_Process_fwdCommand_invalidArguments_%d", i), func(t *testing.T) {
+			oil := interpretLine
+			defer func() {
+				interpretLine = oil
+			}()
+
+			interpretLine = func(line string) (Chain, error) {
+				return Chain{Commands: []Command{{Name: commandFwd, Arguments: test.args}}}, nil
+			}
+
+			output := &bytes.Buffer{}
+			toTest := NewProcessor(output, nil)
+
+			toTest.Process(filledChan("<inputLine>"))
+
+			assert.Equal(t, test.expected, output.String())
+		})
+	}
+}
+
+func TestProcessor_Process_fwdCommand(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var handler mqtt.MessageHandler
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("prod/#"), gomock.Eq(byte(2)), gomock.Any()).DoAndReturn(func(topic string, qos byte, clb mqtt.MessageHandler) mqtt.Token {
+		handler = clb
+		return mockToken
+	})
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+	toTest.ForwardQos = 2
+
+	toTest.Process(filledChan(`fwd -r -t ^prod/ "" prod/# test/`, `fwd`))
+
+	assert.Equal(t, "prod/# -> test/ (forwarded: 0, dropped: 0)\n", output.String())
+	assert.Contains(t, toTest.subscribedTopics, "prod/#")
+	assert.NotNil(t, handler)
+
+	done := make(chan struct{})
+	close(done)
+	pubToken := mock_io.NewMockToken(ctrl)
+	pubToken.EXPECT().Done().Return(done)
+	pubToken.EXPECT().Error().Return(nil)
+	mockMqtt.EXPECT().Publish(gomock.Eq("test/device/1"), gomock.Eq(byte(1)), gomock.Eq(true), gomock.Eq([]byte("PAYLOAD"))).Return(pubToken)
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("prod/device/1").MinTimes(1)
+	testMessage.EXPECT().Qos().Return(byte(1))
+	testMessage.EXPECT().Retained().Return(false).MinTimes(1)
+	testMessage.EXPECT().Payload().Return([]byte("PAYLOAD")).MinTimes(1)
+	testMessage.EXPECT().Duplicate().Return(false)
+	handler(mockMqtt, testMessage)
+
+	assert.Equal(t, "prod/# -> test/ (forwarded: 1, dropped: 0)", toTest.forwards["prod/#"].String())
+	assert.Contains(t, toTest.topics.matching("#"), "prod/device/1", "the forwarded messages should be observed")
+}
+
+func TestForwarding_loopProtection(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	toTest := &forwarding{source: "a/#", prefix: "a/mirror/", qos: -1, target: mockMqtt}
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("a/topic")
+	toTest.handle(mockMqtt, testMessage)
+
+	assert.Equal(t, "a/# -> a/mirror/ (forwarded: 0, dropped: 1)", toTest.String())
+}
+
+func TestForwarding_loopProtection_otherForwarding(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	sources := newForwardSources()
+	sources.add("a/#")
+	sources.add("b/#")
+	toTest := &forwarding{source: "a/#", prefix: "b/", qos: -1, target: mockMqtt, sources: sources}
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("a/topic")
+	toTest.handle(mockMqtt, testMessage)
+
+	assert.Equal(t, "a/# -> b/ (forwarded: 0, dropped: 1)", toTest.String())
+}
+
+func TestForwarding_guard(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	guard := &PublishGuard{ConfirmTopics: []string{"b/+/config/#"}, BlockTopics: []string{"b/+/secret/#"}}
+	toTest := &forwarding{source: "a/#", prefix: "b/", qos: -1, retain: retainKeep, target: mockMqtt, guard: guard}
+
+	for _, topic := range []string{"a/config/1", "a/secret/1"} {
+		testMessage := mock_io.NewMockMessage(ctrl)
+		testMessage.EXPECT().Topic().Return(topic)
+		testMessage.EXPECT().Qos().Return(byte(0))
+		testMessage.EXPECT().Retained().Return(false)
+		toTest.handle(mockMqtt, testMessage)
+	}
+
+	assert.Equal(t, "a/# -> b/ (forwarded: 0, dropped: 2)", toTest.String(), "the guarded topics should not be published")
+}
+
+func TestProcessor_Process_fwdCommand_guarded(t *testing.T) {
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+	toTest.Guard = &PublishGuard{Protected: true, BlockTopics: []string{"prod/#"}}
+
+	toTest.Process(filledChan(`fwd a/# prod/`, `fwd -r a/# test/`))
+
+	usage := "\nUsage: fwd [-q 0|1|2] [-r|-nr] [-t <regex> <replacement>]... [-b <broker>] <src-filter> <dst-prefix>\n"
+	assert.Equal(t, "publishing to prod/ is blocked"+usage+"publishing to test/ requires a confirmation"+usage, output.String())
+	assert.Empty(t, toTest.forwards)
+}
+
+func TestProcessor_Process_fwdCommand_alreadySubscribed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("a/#"), gomock.Eq(byte(0)), gomock.Any()).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	toTest.Process(filledChan(`sub a/#`, `fwd a/# b/`))
+
+	assert.Equal(t, "a/# is already subscribed\nUsage: fwd [-q 0|1|2] [-r|-nr] [-t <regex> <replacement>]... [-b <broker>] <src-filter> <dst-prefix>\n", output.String())
+	assert.Empty(t, toTest.forwards)
+}
+
+func TestProcessor_Process_subCommand_alreadyForwarded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("a/#"), gomock.Eq(byte(1)), gomock.Any()).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+	toTest.ForwardQos = 1
+
+	toTest.Process(filledChan(`fwd a/# b/`, `sub a/#`))
+
+	assert.True(t, strings.HasPrefix(output.String(), "a/# is already forwarded\nUsage: sub "), output.String())
+	assert.Contains(t, toTest.forwards, "a/#")
+	assert.True(t, toTest.forwardSources.matching("a/topic"))
+}
+
+func TestForwarding_otherBroker(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ownMqtt := mock_io.NewMockClient(ctrl)
+	otherMqtt := mock_io.NewMockClient(ctrl)
+
+	oil := interpretLine
+	defer func() {
+		interpretLine = oil
+	}()
+	interpretLine = func(line string) (Chain, error) {
+		return Chain{Commands: []Command{{Name: commandFwd, Arguments: []string{"-q", "0", "-nr", "-b", "tcp://other:1883", "a/#", "a/"}}}}, nil
+	}
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true)
+	ownMqtt.EXPECT().Subscribe(gomock.Eq("a/#"), gomock.Eq(byte(0)), gomock.Any()).Return(mockToken)
+	otherMqtt.EXPECT().Disconnect(gomock.Any())
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, ownMqtt)
+	toTest.ClientFactory = func(broker string) (mqtt.Client, error) {
+		assert.Equal(t, "tcp://other:1883", broker)
+		return otherMqtt, nil
+	}
+
+	toTest.Process(filledChan("<inputLine>"))
+	assert.Equal(t, "", output.String())
+
+	//the same topic is allowed on another broker
+	done := make(chan struct{})
+	close(done)
+	pubToken := mock_io.NewMockToken(ctrl)
+	pubToken.EXPECT().Done().Return(done)
+	pubToken.EXPECT().Error().Return(errors.New("someError"))
+	otherMqtt.EXPECT().Publish(gomock.Eq("a/topic"), gomock.Eq(byte(0)), gomock.Eq(false), gomock.Eq([]byte("PAYLOAD"))).Return(pubToken)
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("topic")
+	testMessage.EXPECT().Qos().Return(byte(2))
+	testMessage.EXPECT().Retained().Return(true)
+	testMessage.EXPECT().Payload().Return([]byte("PAYLOAD"))
+	toTest.forwards["a/#"].handle(ownMqtt, testMessage)
+
+	assert.Equal(t, "a/# -> tcp://other:1883 a/ (forwarded: 0, dropped: 1)", toTest.forwards["a/#"].String())
+}
@@ -0,0 +1,109 @@
+package io
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"unicode/utf8"
+)
+
+// framing converts a message into the bytes which will be written into the input of a long term chain
+type framing func(message mqtt.Message) []byte
+
+const (
+	framingNewline = "newline"
+	framingNul     = "nul"
+	framingLength  = "length"
+	framingJson    = "json"
+)
+
+var framings = map[string]framing{
+	framingNewline: terminatedFraming('\n'),
+	framingNul:     terminatedFraming(0),
+	framingLength:  lengthPrefixedFraming,
+	framingJson:    jsonFraming,
+}
+
+// messageEnvelope is the file representation of a message (json framing, capture files and retained exports)
+type messageEnvelope struct {
+	Topic    string `json:"topic" yaml:"topic"`
+	Qos      byte   `json:"qos" yaml:"qos"`
+	Retained bool   `json:"retained" yaml:"retained"`
+
+	//binary payloads (which are not valid utf8) will be encoded as base64
+	Payload       *string       `json:"payload,omitempty" yaml:"payload,omitempty"`
+	PayloadBase64 base64Payload `json:"payloadBase64,omitempty" yaml:"payloadBase64,omitempty"`
+}
+
+func newMessageEnvelope(message mqtt.Message) messageEnvelope {
+	envelope := messageEnvelope{
+		Topic:    message.Topic(),
+		Qos:      message.Qos(),
+		Retained: message.Retained(),
+	}
+
+	payload := message.Payload()
+	if utf8.Valid(payload) {
+		sPayload := string(payload)
+		envelope.Payload = &sPayload
+	} else {
+		envelope.PayloadBase64 = payload
+	}
+	return envelope
+}
+
+func (e *messageEnvelope) payload() []byte {
+	if e.Payload != nil {
+		return []byte(*e.Payload)
+	}
+	return e.PayloadBase64
+}
+
+// base64Payload is a binary payload. json encodes it as base64 by itself, yaml needs some help.
+type base64Payload []byte
+
+func (p base64Payload) MarshalYAML() (interface{}, error) {
+	return base64.StdEncoding.EncodeToString(p), nil
+}
+
+func (p *base64Payload) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var encoded string
+	if err := unmarshal(&encoded); err != nil {
+		return err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	*p = decoded
+	return nil
+}
+
+func terminatedFraming(terminator byte) framing {
+	return func(message mqtt.Message) []byte {
+		payload := message.Payload()
+
+		frame := make([]byte, len(payload), len(payload)+1)
+		copy(frame, payload)
+		return append(frame, terminator)
+	}
+}
+
+// lengthPrefixedFraming prepends the payload length as 32bit unsigned integer (big endian)
+func lengthPrefixedFraming(message mqtt.Message) []byte {
+	payload := message.Payload()
+
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	return frame
+}
+
+// jsonFraming wraps the message into a json envelope (one per line)
+func jsonFraming(message mqtt.Message) []byte {
+	//the envelope contains only marshallable types
+	frame, _ := json.Marshal(newMessageEnvelope(message))
+	return append(frame, '\n')
+}
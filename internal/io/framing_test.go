@@ -0,0 +1,88 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFramings(t *testing.T) {
+	tests := []struct {
+		framing  string
+		payload  []byte
+		expected []byte
+	}{
+		{framingNewline, []byte("PAYLOAD"), []byte("PAYLOAD\n")},
+		{framingNul, []byte("multi\nline"), []byte("multi\nline\x00")},
+		{framingLength, []byte("PAYLOAD"), []byte("\x00\x00\x00\x07PAYLOAD")},
+		{framingLength, []byte{}, []byte{0, 0, 0, 0}},
+		{framingJson, []byte("{\n\"a\": 1\n}"), []byte(`{"topic":"a/topic","qos":1,"retained":true,"payload":"{\n\"a\": 1\n}"}` + "\n")},
+		{framingJson, []byte{}, []byte(`{"topic":"a/topic","qos":1,"retained":true,"payload":""}` + "\n")},
+		{framingJson, []byte{0xff, 0xfe}, []byte(`{"topic":"a/topic","qos":1,"retained":true,"payloadBase64":"//4="}` + "\n")},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestFramings_%d", i), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			testMessage := mock_io.NewMockMessage(ctrl)
+			testMessage.EXPECT().Topic().Return("a/topic").AnyTimes()
+			testMessage.EXPECT().Qos().Return(byte(1)).AnyTimes()
+			testMessage.EXPECT().Retained().Return(true).AnyTimes()
+			testMessage.EXPECT().Payload().Return(test.payload)
+
+			assert.Equal(t, test.expected, framings[test.framing](testMessage))
+		})
+	}
+}
+
+func TestGenSubHandler_longTermSub_framing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	outputFile := path.Join(os.TempDir(), "framing.txt")
+	defer os.Remove(outputFile)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	testChain, err := interpretLine(fmt.Sprintf(`%s a/# | select .topic > %s &`, commandSub, outputFile))
+	assert.NoError(t, err)
+
+	fn, err := genSubHandler(toTest, "a/#", testChain, subOptions{framing: framings[framingJson]})
+	assert.NoError(t, err)
+
+	for _, topic := range []string{"a/first", "a/second"} {
+		testMessage := mock_io.NewMockMessage(ctrl)
+		testMessage.EXPECT().Topic().Return(topic)
+		testMessage.EXPECT().Qos().Return(byte(0))
+		testMessage.EXPECT().Retained().Return(false)
+		testMessage.EXPECT().Payload().Return([]byte("multi\nline"))
+		fn(nil, testMessage)
+	}
+
+	job := toTest.longTermCommands["a/#"]
+	job.w.Close()
+	waitForJob(t, job)
+
+	fileContent, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "a/first\na/second\n", string(fileContent))
+	assert.Equal(t, "", output.String())
+}
+
+func TestGenSubHandler_framingForShortTermChain(t *testing.T) {
+	toTest := NewProcessor(&bytes.Buffer{}, nil)
+
+	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | cat`, commandSub))
+	assert.NoError(t, err)
+
+	_, err = genSubHandler(toTest, "a/topic", testChain, subOptions{framing: framings[framingNul]})
+	assert.EqualError(t, err, "a framing is only available for long term chains")
+}
@@ -0,0 +1,106 @@
+package io
+
+import (
+	"fmt"
+	"strings"
+)
+
+// confirmAnswer is the answer which must be given to confirm a guarded publish
+const confirmAnswer = "yes"
+
+// PublishGuard protects the topics of an environment against accidental publishes. Publishes to blocked topics will
+// be rejected, publishes to topics which require a confirmation will be done only after the user has confirmed them.
+// In a protected environment each retained publish (incl. the clearing of retained messages) and each replay requires
+// a confirmation.
+type PublishGuard struct {
+	// Protected marks the environment as protected (e.g. production)
+	Protected bool
+
+	// ConfirmTopics are the topic filters whose publishes require a confirmation
+	ConfirmTopics []string
+
+	// BlockTopics are the topic filters whose publishes will be rejected
+	BlockTopics []string
+}
+
+// blocked returns the first of the given topics which must not be published
+func (g *PublishGuard) blocked(topics ...string) (string, bool) {
+	if g == nil {
+		return "", false
+	}
+
+	for _, topic := range topics {
+		for _, filter := range g.BlockTopics {
+			if topicMatches(filter, topic) {
+				return topic, true
+			}
+		}
+	}
+	return "", false
+}
+
+// needsConfirmation checks if a publish to the given topics requires a confirmation of the user
+func (g *PublishGuard) needsConfirmation(retained bool, topics ...string) bool {
+	if g == nil {
+		return false
+	}
+	if g.Protected && retained {
+		return true
+	}
+
+	for _, topic := range topics {
+		for _, filter := range g.ConfirmTopics {
+			if topicMatches(filter, topic) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// needsReplayConfirmation checks if a replay requires a confirmation of the user. The topics of a replay are not known
+// in advance: so it must be confirmed if any publish could require a confirmation.
+func (g *PublishGuard) needsReplayConfirmation() bool {
+	return g != nil && (g.Protected || len(g.ConfirmTopics) > 0)
+}
+
+// check checks if the publish to the given topics is allowed without any confirmation
+func (g *PublishGuard) check(retained bool, topics ...string) error {
+	if topic, blocked := g.blocked(topics...); blocked {
+		return fmt.Errorf("publishing to %s is blocked", topic)
+	}
+	if g.needsConfirmation(retained, topics...) {
+		return fmt.Errorf("publishing to %s requires a confirmation", strings.Join(topics, ", "))
+	}
+	return nil
+}
+
+// guard checks if the publish to the given topics is allowed. If a confirmation is required, the user will be asked
+// for it (with the given action as question). It returns false if the publish must not be done. The reason is already
+// written to the output then.
+func (p *processor) guard(action string, retained bool, topics ...string) bool {
+	if topic, blocked := p.Guard.blocked(topics...); blocked {
+		p.out.Write([]byte(fmt.Sprintf("publishing to %s is blocked\n", topic)))
+		return false
+	}
+	if !p.Guard.needsConfirmation(retained, topics...) {
+		return true
+	}
+	return p.confirm(action)
+}
+
+// confirm asks the user for a confirmation of the given action. Without anybody who can be asked (such like in the
+// non-interactive mode) the action will be rejected.
+func (p *processor) confirm(action string) bool {
+	if p.Ask == nil {
+		p.out.Write([]byte(fmt.Sprintf("unable to %s: a confirmation is required\n", action)))
+		return false
+	}
+
+	answer, ok := p.Ask(fmt.Sprintf("Do you really want to %s? Type %q to confirm.", action, confirmAnswer))
+	if !ok || strings.TrimSpace(answer) != confirmAnswer {
+		p.out.Write([]byte("aborted\n"))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func testPublishGuard() *PublishGuard {
+	return &PublishGuard{
+		Protected:     true,
+		ConfirmTopics: []string{"devices/+/config"},
+		BlockTopics:   []string{"system/#"},
+	}
+}
+
+func TestPublishGuard_check(t *testing.T) {
+	tests := []struct {
+		guard    *PublishGuard
+		retained bool
+		topics   []string
+		expected string
+	}{
+		{nil, true, []string{"system/a"}, ""},
+		{&PublishGuard{}, true, []string{"system/a"}, ""},
+		{testPublishGuard(), false, []string{"sensors/a"}, ""},
+		{testPublishGuard(), false, []string{"sensors/a", "system/a"}, "publishing to system/a is blocked"},
+		{testPublishGuard(), true, []string{"system/a"}, "publishing to system/a is blocked"},
+		{testPublishGuard(), true, []string{"sensors/a"}, "publishing to sensors/a requires a confirmation"},
+		{testPublishGuard(), false, []string{"devices/1/config"}, "publishing to devices/1/config requires a confirmation"},
+		{&PublishGuard{ConfirmTopics: []string{"config/#"}}, true, []string{"sensors/a"}, ""},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestPublishGuard_check_%d", i), func(t *testing.T) {
+			err := test.guard.check(test.retained, test.topics...)
+			if test.expected == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestPublishGuard_needsReplayConfirmation(t *testing.T) {
+	assert.False(t, (*PublishGuard)(nil).needsReplayConfirmation())
+	assert.False(t, (&PublishGuard{BlockTopics: []string{"system/#"}}).needsReplayConfirmation())
+	assert.True(t, (&PublishGuard{Protected: true}).needsReplayConfirmation())
+	assert.True(t, (&PublishGuard{ConfirmTopics: []string{"config/#"}}).needsReplayConfirmation())
+}
+
+// answers returns an ask function which writes the question into the output and answers with the given lines one
+// after another
+func answers(output io.Writer, lines ...string) func(string) (string, bool) {
+	return func(question string) (string, bool) {
+		output.Write([]byte(question + "\n"))
+		if len(lines) == 0 {
+			return "", false
+		}
+		answer := lines[0]
+		lines = lines[1:]
+		return answer, true
+	}
+}
+
+func TestProcessor_Process_pub_guard(t *testing.T) {
+	tests := []struct {
+		line      string
+		answers   []string
+		published bool
+		expected  string
+	}{
+		{commandPub + " sensors/a 1", nil, true, ""},
+		{commandPub + " system/a 1", nil, false, "publishing to system/a is blocked\n"},
+		{commandPub + " -r system/a 1", []string{"yes"}, false, "publishing to system/a is blocked\n"},
+		{commandPub + " -r sensors/a 1", []string{"yes"}, true, "Do you really want to publish a retained message to sensors/a? Type \"yes\" to confirm.\n"},
+		{commandPub + " -r sensors/a 1", []string{" yes "}, true, "Do you really want to publish a retained message to sensors/a? Type \"yes\" to confirm.\n"},
+		{commandPub + " devices/1/config 1", []string{"yes"}, true, "Do you really want to publish to devices/1/config? Type \"yes\" to confirm.\n"},
+		{commandPub + " -r sensors/a 1", []string{"y"}, false, "Do you really want to publish a retained message to sensors/a? Type \"yes\" to confirm.\naborted\n"},
+		{commandPub + " -r sensors/a 1", nil, false, "Do you really want to publish a retained message to sensors/a? Type \"yes\" to confirm.\naborted\n"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestProcessor_Process_pub_guard_%d", i), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockMqtt := mock_io.NewMockClient(ctrl)
+			if test.published {
+				mockToken := mock_io.NewMockToken(ctrl)
+				mockToken.EXPECT().Wait().Return(true)
+				mockMqtt.EXPECT().Publish(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Eq("1")).Return(mockToken)
+			}
+
+			output := &bytes.Buffer{}
+			toTest := NewProcessor(output, mockMqtt)
+			toTest.Guard = testPublishGuard()
+			toTest.Ask = answers(output, test.answers...)
+
+			toTest.Process(filledChan(test.line))
+
+			assert.Equal(t, test.expected, output.String())
+		})
+	}
+}
+
+func TestProcessor_Process_pub_guardWithoutAnswer(t *testing.T) {
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+	toTest.Guard = testPublishGuard()
+
+	toTest.Process(filledChan(commandPub + " -r sensors/a 1"))
+
+	assert.Equal(t, "unable to publish a retained message to sensors/a: a confirmation is required\n", output.String())
+}
+
+func TestProcessor_Process_ping_guard(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	//neither a subscription nor a publish is expected
+	mockMqtt := mock_io.NewMockClient(ctrl)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+	toTest.Guard = testPublishGuard()
+	toTest.Ask = answers(output, "no")
+
+	toTest.Process(filledChan(commandPing+" system/ping", commandPing+" devices/1/config"))
+
+	assert.Equal(t, "publishing to system/ping is blocked\n"+
+		"Do you really want to send ping probes to devices/1/config? Type \"yes\" to confirm.\naborted\n", output.String())
+}
@@ -24,9 +24,11 @@ var helpText = `\u001b[7mPublishing a message\u001b[0m
 
 \u001b[7mSubscribe to a topic\u001b[0m
 
-  \u001b[1msub [-q 0|1|2] <topic> [...topicN]\u001b[0m
+  \u001b[1msub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\u001b[0m
 
-    -q [0|1|2]  QualityOfService (QoS) level
+    -q [0|1|2]                              QualityOfService (QoS) level
+    -restart [never|on-failure|always]      Restart policy for long term chains (default: never)
+    -f [newline|nul|length|json]            Framing of the messages for long term chains (default: newline)
 
   \u001b[7mCommand chaining\u001b[0m
     One powerful feature of this shell is to chain incoming messages to external applications. 
@@ -40,22 +42,193 @@ var helpText = `\u001b[7mPublishing a message\u001b[0m
 
       \u001b[1msub test/topic | myExternalApplication |& grep "Message"\u001b[0m
 
+    \u001b[4mThe topic of the incoming message can be used as argument ({topic} or the topic levels {1}...{n}):\u001b[0m
+
+      \u001b[1msub test/+/value | tee /tmp/{2}.value\u001b[0m
+
+    \u001b[4mThe following environment variables are available for the external applications:\u001b[0m
+
+      MQTT_TOPIC, MQTT_QOS, MQTT_RETAINED, MQTT_MESSAGE_ID and MQTT_TOPIC_1...MQTT_TOPIC_n (topic levels)
+
     \u001b[4mNormally the external applications will be started on each incoming Message.\u001b[0m
     \u001b[4mIf you want to stream all incoming messages to a single started application:\u001b[0m
 
       \u001b[1msub test/topic | grep "Message" &\u001b[0m
 
+    \u001b[4mA long term chain can be restarted automatically if it exits (with a growing delay between the restarts).\u001b[0m
+    \u001b[4mThe messages which are received in the meantime will be passed to the restarted chain:\u001b[0m
+
+      \u001b[1msub -restart on-failure test/topic | myConsumer > /tmp/consumer.log &\u001b[0m
+
+    \u001b[4mThe messages can be separated by newline (default), NUL, a length prefix (32bit big endian) or can be\u001b[0m
+    \u001b[4mwrapped into a json envelope (one per line) which contains the topic, qos and retained flag:\u001b[0m
+
+      \u001b[1msub -f json test/# | myConsumer > /tmp/consumer.log &\u001b[0m
+
     \u001b[4mIf you want to write all incoming messages into files:\u001b[0m
 
       \u001b[1msub test/topic >> /tmp/test.msg\u001b[0m
 
+    \u001b[4mThe file path can contain placeholders ({topic}, {1}...{n} and {date}). Furthermore the files can be rotated\u001b[0m
+    \u001b[4mby size (-s) and/or by time (-i). The rotated files can be compressed (-z):\u001b[0m
+
+      \u001b[1msub test/# >> /tmp/{topic}/{date}.log -s 10M -i 1h -z\u001b[0m
+
+  \u001b[7mBuiltin commands\u001b[0m
+    The following commands can be used inside a chain. They will be executed in-process (no external application
+    will be started). Both expect json as input.
+
+    \u001b[4mSelect a field by a path (strings will be written without quotes):\u001b[0m
+
+      \u001b[1msub test/topic | select .sensors[0].value\u001b[0m
+
+    \u001b[4mTransform the input by a golang template (https://pkg.go.dev/text/template):\u001b[0m
+
+      \u001b[1msub test/topic | template "{{.name}}: {{.value}}"\u001b[0m
+
+    \u001b[4mPublish each line of the output to another topic (-a publishes the complete output as one message):\u001b[0m
+
+      \u001b[1msub in/topic | jq .value | pub [-r] [-q 0|1|2] [-a] out/topic\u001b[0m
+
+\u001b[7mForward messages to another topic tree\u001b[0m
+
+  \u001b[1mfwd [-q 0|1|2] [-r|-nr] [-t <regex> <replacement>]... [-b <broker>] <src-filter> <dst-prefix>\u001b[0m
+
+    -q [0|1|2]                  Override the QualityOfService (QoS) level
+    -r                          Publish all messages as retained
+    -nr                         Publish all messages as not retained
+    -t <regex> <replacement>    Rewrite the source topic before the destination prefix will be prepended
+    -b <broker>                 Forward the messages to another broker
+
+    Messages which would be received again by the forwarding itself (or by any other forwarding) will be dropped
+    (loop protection). A source filter which is already subscribed can not be forwarded.
+    Without any argument all forwardings will be listed. A forwarding can be stopped with \u001b[1munsub <src-filter>\u001b[0m
+
+    \u001b[4mMirror all messages from prod/# into test/prod/#\u001b[0m
+
+      \u001b[1mfwd prod/# test/\u001b[0m
+
+    \u001b[4mMirror all messages from prod/# into test/# of another broker\u001b[0m
+
+      \u001b[1mfwd -t ^prod/ "" -b tcp://test-broker:1883 prod/# test/\u001b[0m
+
+\u001b[7mList all long term chains (jobs)\u001b[0m
+
+  \u001b[1mjobs [-c]\u001b[0m
+
+    Shows the id, topic, process ids, uptime, count of fed and dropped messages, the count of restarts
+    and the status of each job.
+    If a job dies unexpectedly, it will be reported in the shell.
+    Killed and unsubscribed jobs are listed with their final status until they are cleared (-c).
+
+\u001b[7mKill a job\u001b[0m
+
+  \u001b[1mkill <job-id|topic> [...job-idN|topicN]\u001b[0m
+
+    Kills all processes of the job and unsubscribes its topic.
+
+\u001b[7mShow the topic tree\u001b[0m
+
+  \u001b[1m.tree [-w <duration>] [filter]\u001b[0m
+
+    -w <duration>    Subscribe the filter for the given duration (default: 2s if no messages were seen before).
+                     The shell waits until the duration is over.
+
+    Shows the hierarchy of all topics which are matching the filter (default: #) with the count of messages,
+    the preview of the last payload and a marker for retained messages.
+
+\u001b[7mList, export, import or clear retained messages\u001b[0m
+
+  \u001b[1mretained [-w <duration>] [-e <file>|-c] <filter>\u001b[0m
+  \u001b[1mretained -i <file> [filter]\u001b[0m
+
+    -w <duration>    The duration of waiting for retained messages (default: 1s)
+    -e <file>        Export the retained messages into the given file (json, yml or yaml)
+    -i <file>        Re-publish (retained) all messages of the given file which are matching the filter
+    -c               Clear all retained messages which are matching the filter
+
+    \u001b[4mSnapshot and restore the device configuration\u001b[0m
+
+      \u001b[1mretained -e /tmp/config.yml config/#\u001b[0m
+      \u001b[1mretained -i /tmp/config.yml\u001b[0m
+
+    In a protected environment each import and clear must be confirmed.
+
+\u001b[7mShow the last message of a topic\u001b[0m
+
+  \u001b[1mlast <topic|filter>\u001b[0m
+
+    Shows the last message (with its age and the count of messages) of each topic which was seen by any subscription.
+
+\u001b[7mShow the changes between the last two messages of a topic\u001b[0m
+
+  \u001b[1mdiff <topic>\u001b[0m
+
+    Json payloads will be compared field by field. All other payloads will be compared line by line.
+
+\u001b[7mShow the message statistics\u001b[0m
+
+  \u001b[1m.stats [-r <interval>] [filter]\u001b[0m
+
+    -r <interval>    Refresh the statistics in background with the given interval (0 stops the refresh)
+
+    Shows per subscription and per topic (which are matching the filter): the count of messages, the rate of the last
+    second and the last minute, the amount of bytes, the min/max/avg payload size, the last seen and the duplicate count.
+
+\u001b[7mShow the connection status\u001b[0m
+
+  \u001b[1m.status\u001b[0m
+
+    Shows the state of the connection, the broker, the uptime of the connection, the count of reconnects and the
+    last connection error.
+
+\u001b[7mMeasure the round-trip latency\u001b[0m
+
+  \u001b[1mping [-n count] [-q 0|1|2] [-i interval] [-t timeout] [topic]\u001b[0m
+
+    -n count       The count of probes per QoS level (default: 5)
+    -q 0|1|2       Measure only the given QoS level (default: all levels)
+    -i interval    The interval between two probes (default: 100ms)
+    -t timeout     The maximum duration of waiting for a probe (default: 1s)
+
+    Publishes probes to the topic (default: a random topic under mqtt-shell/ping/) and measures the duration until
+    they will be received again.
+
+\u001b[7mRecord the received messages\u001b[0m
+
+  \u001b[1mrecord start [-f jsonl|binary|mosquitto|csv] <file> [filter]\u001b[0m
+  \u001b[1mrecord stop\u001b[0m
+
+    -f jsonl|binary|mosquitto|csv    The format of the capture file (default: jsonl)
+
+    Writes all received messages (with timestamp, topic, QoS, retained flag and payload) into the capture file.
+    If a filter is given, it will be subscribed during the recording. Otherwise all messages of the existing
+    subscriptions will be recorded. Without any argument the status of the recording will be shown.
+
+\u001b[7mReplay a capture file\u001b[0m
+
+  \u001b[1mreplay [-i <format>] [-s <factor>|-fast] [-f <filter>] [-t <regex> <replacement>]... <file>\u001b[0m
+  \u001b[1mreplay stop\u001b[0m
+
+    -i <format>                     The format of the capture file: jsonl, binary, mosquitto, csv or pcap
+                                    (default: detected by the file content)
+    -s <factor>                     Replays the messages faster (or slower) by the given factor (default: 1)
+    -fast                           Replays the messages as fast as possible
+    -f <filter>                     Replays only the messages whose topic matches the filter
+    -t <regex> <replacement>        Rewrites the topics before publishing (can be given multiple times)
+
+    Republishes the messages of a capture file with their original timing, QoS and retained flag.
+    The replay runs in background. Without any argument the status of the replay will be shown.
+
 \u001b[7mUnsubscribe a topic\u001b[0m
 
   \u001b[1munsub <topic> [...topicN]\u001b[0m
 
 \u001b[7mList all available commands\u001b[0m
 
-  \u001b[1m.ls\u001b[0m
+  \u001b[1m.ls [-l]\u001b[0m
+
+    -l    Show the count of executed, dropped and timed out chains of each subscription
 
 \u001b[7mList all available macros\u001b[0m
 
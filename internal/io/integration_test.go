@@ -0,0 +1,399 @@
+package io
+
+import (
+	"errors"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rainu/mqtt-shell/internal/broker"
+	"github.com/rainu/mqtt-shell/internal/config"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var sgrRegex = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// integrationHarness wires the shell, the macro manager and the processor like the main does. But instead of a
+// real broker an embedded one will be used and the lines of the shell are read from a channel.
+type integrationHarness struct {
+	t       *testing.T
+	address string
+	broker  *broker.Server
+
+	client    mqtt.Client
+	shell     *shell
+	processor *processor
+	output    *lockedBuffer
+
+	lines chan string
+	done  chan struct{}
+}
+
+func newIntegrationHarness(t *testing.T, macros map[string]config.Macro, modify ...func(*mqtt.ClientOptions)) *integrationHarness {
+	ognd := getNextDecorator
+	getNextDecorator = func() decorator {
+		return []string{}
+	}
+
+	h := &integrationHarness{
+		t:      t,
+		output: &lockedBuffer{},
+		lines:  make(chan string),
+		done:   make(chan struct{}),
+	}
+	t.Cleanup(func() {
+		h.close()
+		getNextDecorator = ognd
+	})
+
+	var err error
+	h.broker, err = broker.Listen("127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	h.address = h.broker.Addr().String()
+
+	macroManager := &MacroManager{MacroSpecs: macros, Output: h.output}
+	if !assert.NoError(t, macroManager.ValidateAndInitMacros()) {
+		t.FailNow()
+	}
+
+	sh, err := NewShell("", path.Join(os.TempDir(), "mqtt-shell-integration-history"), macroManager, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	sh.targetOut = h.output
+	h.shell = sh
+	sh.readline = func() (string, error) {
+		line, ok := <-h.lines
+		if !ok {
+			return "", errors.New("EOF")
+		}
+		return line, nil
+	}
+
+	//the processor will be created after the first connect
+	status := NewConnectionStatus(h.output)
+	h.client = h.connect("mqtt-shell", func(opts *mqtt.ClientOptions) {
+		opts.SetAutoReconnect(true)
+		opts.SetMaxReconnectInterval(100 * time.Millisecond)
+		status.Observe(opts)
+		for _, m := range modify {
+			m(opts)
+		}
+	})
+	status.Connected()
+	h.processor = NewProcessor(sh, h.client)
+	h.processor.Status = status
+	h.processor.Ask = sh.Ask
+	status.SetReconnectListener(h.processor)
+
+	err = sh.SetPromptTemplate("{{.State}} {{.Subscriptions}}> ", func() PromptData {
+		return PromptData{
+			State:         status.State(),
+			Subscriptions: len(h.processor.GetSubscriptions()),
+		}
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	status.OnChange(sh.RefreshPrompt)
+	h.processor.OnChange = sh.RefreshPrompt
+
+	input := sh.Start()
+	go func() {
+		defer close(h.done)
+		h.processor.Process(input)
+	}()
+
+	return h
+}
+
+// connect connects a new client to the embedded broker
+func (h *integrationHarness) connect(clientId string, modify ...func(*mqtt.ClientOptions)) mqtt.Client {
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker("tcp://" + h.address)
+	opts.SetClientID(clientId)
+	opts.SetAutoReconnect(false)
+	for _, m := range modify {
+		m(opts)
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !assert.True(h.t, token.WaitTimeout(time.Second)) || !assert.NoError(h.t, token.Error()) {
+		h.t.FailNow()
+	}
+	h.t.Cleanup(func() {
+		client.Disconnect(0)
+	})
+	return client
+}
+
+// receive subscribes the filter with an additional client and returns the channel of the received messages
+func (h *integrationHarness) receive(filter string, qos byte) chan mqtt.Message {
+	messages := make(chan mqtt.Message, 100)
+	client := h.connect("receiver-" + filter)
+	token := client.Subscribe(filter, qos, func(_ mqtt.Client, m mqtt.Message) {
+		messages <- m
+	})
+	assert.True(h.t, token.WaitTimeout(time.Second))
+	return messages
+}
+
+// publish publishes a message with an additional client
+func (h *integrationHarness) publish(topic string, qos byte, retained bool, payload string) {
+	token := h.connect("publisher-"+topic).Publish(topic, qos, retained, payload)
+	assert.True(h.t, token.WaitTimeout(time.Second))
+	assert.NoError(h.t, token.Error())
+}
+
+// enter enters the line into the shell
+func (h *integrationHarness) enter(line string) {
+	h.lines <- line
+}
+
+// text returns the output of the shell without colors
+func (h *integrationHarness) text() string {
+	return sgrRegex.ReplaceAllString(h.output.String(), "")
+}
+
+// waitFor waits until the output of the shell contains the given text. Because each write of the shell clears the
+// current line, the text must be written at once.
+func (h *integrationHarness) waitFor(text string) bool {
+	return assert.Eventually(h.t, func() bool {
+		return strings.Contains(h.text(), text)
+	}, 5*time.Second, 5*time.Millisecond, "the output should contain %q", text)
+}
+
+// waitForSubscription waits until the processor is subscribed to the topic
+func (h *integrationHarness) waitForSubscription(topic string) bool {
+	return assert.Eventually(h.t, func() bool {
+		for _, subscription := range h.processor.GetSubscriptions() {
+			if subscription == topic {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond, "the topic %q should be subscribed", topic)
+}
+
+// waitForPrompt waits until the shell shows the given prompt
+func (h *integrationHarness) waitForPrompt(prompt string) bool {
+	return assert.Eventually(h.t, func() bool {
+		h.shell.writeMutex.Lock()
+		defer h.shell.writeMutex.Unlock()
+
+		return h.shell.prompt == prompt
+	}, 5*time.Second, 5*time.Millisecond, "the prompt should be %q", prompt)
+}
+
+// restartBroker simulates a broker restart: all sessions, subscriptions and retained messages will be lost
+func (h *integrationHarness) restartBroker() {
+	h.broker.Close()
+
+	var err error
+	h.broker, err = broker.Listen(h.address)
+	if !assert.NoError(h.t, err) {
+		h.t.FailNow()
+	}
+}
+
+func (h *integrationHarness) close() {
+	select {
+	case <-h.done:
+	default:
+		h.enter(commandExit)
+		<-h.done
+	}
+	h.broker.Close()
+}
+
+func expectReceived(t *testing.T, messages chan mqtt.Message) mqtt.Message {
+	select {
+	case m := <-messages:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestIntegration_publishAndSubscribe(t *testing.T) {
+	h := newIntegrationHarness(t, nil)
+
+	h.enter(commandSub + " sensors/#")
+	h.waitForSubscription("sensors/#")
+
+	h.publish("sensors/kitchen", 1, false, "21.5")
+	h.waitFor("sensors/kitchen | 21.5\n")
+
+	messages := h.receive("commands/#", 2)
+	h.enter(commandPub + " -q 2 commands/light on")
+
+	m := expectReceived(t, messages)
+	assert.Equal(t, "commands/light", m.Topic())
+	assert.Equal(t, "on", string(m.Payload()))
+	assert.Equal(t, byte(2), m.Qos())
+
+	h.enter(commandUnsub + " sensors/#")
+	assert.Eventually(t, func() bool {
+		return !h.processor.HasSubscriptions()
+	}, time.Second, time.Millisecond)
+
+	h.publish("sensors/kitchen", 1, false, "unsubscribed")
+	h.enter(commandPub + " commands/sync done")
+	expectReceived(t, messages)
+	assert.NotContains(t, h.text(), "unsubscribed")
+}
+
+func TestIntegration_retained(t *testing.T) {
+	h := newIntegrationHarness(t, nil)
+
+	h.enter(commandPub + ` -r -q 1 config/device/1 '{"interval":10}'`)
+	h.enter(commandRetained + " -w 200ms config/#")
+	h.waitFor("TOPIC            SIZE  PAYLOAD\n" +
+		`config/device/1  15    "{\"interval\":10}"` + "\n")
+
+	h.enter(commandSub + " config/#")
+	h.waitFor("config/device/1 | {\"interval\":10}\n")
+}
+
+func TestIntegration_macros(t *testing.T) {
+	h := newIntegrationHarness(t, map[string]config.Macro{
+		"switch": {
+			Arguments: []string{"device", "state"},
+			Commands:  []string{commandPub + " devices/$1/set $2"},
+		},
+		"switch-all": {
+			Arguments: []string{"state", "device"},
+			Varargs:   true,
+			Script:    commandPub + " devices/{{.Arg2}}/set {{.Arg1}}",
+		},
+	})
+
+	messages := h.receive("devices/+/set", 1)
+
+	h.enter("switch lamp on")
+	m := expectReceived(t, messages)
+	assert.Equal(t, "devices/lamp/set", m.Topic())
+	assert.Equal(t, "on", string(m.Payload()))
+
+	h.enter("switch-all off a b")
+	for _, device := range []string{"a", "b"} {
+		m := expectReceived(t, messages)
+		assert.Equal(t, "devices/"+device+"/set", m.Topic())
+		assert.Equal(t, "off", string(m.Payload()))
+	}
+}
+
+func TestIntegration_resubscribeAfterReconnect(t *testing.T) {
+	h := newIntegrationHarness(t, nil)
+
+	h.enter(commandSub + " -q 1 alarms/#")
+	h.waitForSubscription("alarms/#")
+
+	h.restartBroker()
+
+	//the broker has lost all subscriptions: the processor must subscribe again after the reconnect
+	assert.Eventually(t, func() bool {
+		h.publish("alarms/fire", 1, false, "!!!")
+		return strings.Contains(h.text(), "alarms/fire | !!!\n")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	h.enter(commandStatus)
+	h.waitFor("STATE       connected\n" +
+		"BROKER      tcp://" + h.address + "\n")
+	h.waitFor("RECONNECTS  1\n")
+	assert.Contains(t, h.text(), "Connection to broker lost: EOF. Reconnecting...\n")
+	assert.Contains(t, h.text(), "Successfully re-connected to mqtt broker.\n")
+}
+
+func TestIntegration_brokerFailover(t *testing.T) {
+	secondary, err := broker.Listen("127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer secondary.Close()
+	secondaryUri := "tcp://" + secondary.Addr().String()
+
+	h := newIntegrationHarness(t, nil, func(opts *mqtt.ClientOptions) {
+		opts.AddBroker(secondaryUri)
+		OrderBrokers(opts, brokerOrderFailover)
+	})
+
+	h.enter(commandSub + " -q 1 alarms/#")
+	h.waitForSubscription("alarms/#")
+
+	//the primary broker goes down: the shell must connect to the next one
+	h.broker.Close()
+	h.waitFor("Successfully re-connected to mqtt broker.\n")
+
+	h.enter(commandStatus)
+	h.waitFor("STATE       connected\n" +
+		"BROKER      " + secondaryUri + "\n")
+
+	opts := mqtt.NewClientOptions().AddBroker(secondaryUri).SetClientID("publisher")
+	publisher := mqtt.NewClient(opts)
+	if !assert.True(t, publisher.Connect().WaitTimeout(time.Second)) {
+		t.FailNow()
+	}
+	defer publisher.Disconnect(0)
+
+	assert.Eventually(t, func() bool {
+		publisher.Publish("alarms/fire", 1, false, "!!!").Wait()
+		return strings.Contains(h.text(), "alarms/fire | !!!\n")
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestIntegration_prompt(t *testing.T) {
+	h := newIntegrationHarness(t, nil)
+	h.waitForPrompt("connected 0> ")
+
+	h.enter(commandSub + " -q 1 alarms/#")
+	h.waitForPrompt("connected 1> ")
+
+	h.broker.Close()
+	h.waitForPrompt("reconnecting 1> ")
+
+	var err error
+	h.broker, err = broker.Listen(h.address)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	h.waitForPrompt("connected 1> ")
+
+	h.enter(commandUnsub + " alarms/#")
+	h.waitForPrompt("connected 0> ")
+}
+
+func TestIntegration_protected(t *testing.T) {
+	h := newIntegrationHarness(t, nil)
+	//the guard is set before the first line is entered: so the processing routine will see it
+	h.processor.Guard = &PublishGuard{Protected: true, BlockTopics: []string{"system/#"}}
+
+	messages := h.receive("#", 1)
+	question := "Do you really want to publish a retained message to config/device/1? Type \"yes\" to confirm.\n"
+
+	h.enter(commandPub + " system/reboot now")
+	h.waitFor("publishing to system/reboot is blocked\n")
+
+	h.enter(commandPub + " -r config/device/1 on")
+	h.waitFor(question)
+	h.enter("no")
+	h.waitFor("aborted\n")
+
+	h.enter(commandPub + " -r config/device/1 off")
+	assert.Eventually(t, func() bool {
+		return strings.Count(h.text(), question) == 2
+	}, 5*time.Second, 5*time.Millisecond)
+	h.enter("yes")
+
+	m := expectReceived(t, messages)
+	assert.Equal(t, "config/device/1", m.Topic())
+	assert.Equal(t, "off", string(m.Payload()))
+	assert.NotContains(t, h.text(), "unknown macro", "the answers should not be interpreted as macros")
+}
@@ -2,10 +2,10 @@ package io
 
 import (
 	"errors"
+	"fmt"
 	"github.com/kballard/go-shellquote"
 	cmdchain "github.com/rainu/go-command-chain"
 	"io"
-	"os"
 	"regexp"
 	"strings"
 )
@@ -108,47 +108,28 @@ var interpretLine = func(line string) (Chain, error) {
 	return chain, nil
 }
 
-func (c *Chain) ToCommand(input io.Reader, outputs ...io.Writer) (cmdchain.FinalizedBuilder, func(), error) {
+func (c *Chain) ToCommand(env chainEnv, input io.Reader, outputs ...io.Writer) (runnable, func(), error) {
 	appending := c.IsAppending()
 
-	b := cmdchain.Builder().WithInput(input)
 	to := len(c.Commands)
 	if appending {
 		//the last "command" is not a command but an output file target
 		to--
 	}
 
-	for i := 1; i < to; i++ {
-		cmd := b.Join(c.Commands[i].Name, c.Commands[i].Arguments...)
-
-		//is not last command, check the link to the next command
-		if i+1 < len(c.Commands) {
-			if c.Links[i] == linkOutAndErr {
-				cmd.ForwardError()
-			}
-		}
-		b = cmd
-	}
-
 	//callback func will be called after the command is finished
 	callbackFn := func() {}
 	errOutputs := make([]io.Writer, 0, 1)
 
 	if appending {
-		flags := os.O_WRONLY | os.O_CREATE
-
-		if strings.HasPrefix(c.Links[len(c.Links)-1], ">>") {
-			flags = flags | os.O_APPEND
-		} else {
-			flags = flags | os.O_TRUNC
-		}
+		link := c.Links[len(c.Links)-1]
 
-		outFile, err := os.OpenFile(c.Commands[len(c.Commands)-1].Name, flags, 0644)
+		outFile, err := newRedirectFile(env, c.Commands[len(c.Commands)-1], strings.HasPrefix(link, ">>"))
 		if err != nil {
 			return nil, callbackFn, err
 		}
 
-		if strings.Contains(c.Commands[len(c.Commands)-1].Name, "&") {
+		if strings.HasSuffix(link, "&") {
 			errOutputs = append(errOutputs, outFile)
 		}
 
@@ -159,11 +140,128 @@ func (c *Chain) ToCommand(input io.Reader, outputs ...io.Writer) (cmdchain.Final
 		outputs = append(outputs, outFile)
 	}
 
-	return b.Finalize().
-			WithGlobalErrorChecker(cmdchain.IgnoreExitErrors()).
-			WithOutput(outputs...).
-			WithError(errOutputs...),
-		callbackFn, nil
+	p, err := c.toPipeline(env, 1, to, input, outputs, errOutputs)
+	if err != nil {
+		callbackFn()
+		return nil, func() {}, err
+	}
+
+	return p, callbackFn, nil
+}
+
+// toPipeline splits the commands into segments of external applications and builtin stages
+func (c *Chain) toPipeline(env chainEnv, from, to int, input io.Reader, outputs, errOutputs []io.Writer) (*pipeline, error) {
+	p := &pipeline{}
+
+	if from >= to {
+		//there are no commands: pass through the input
+		p.segments = append(p.segments, segment{
+			run: func() error {
+				_, err := io.Copy(io.MultiWriter(outputs...), input)
+				return err
+			},
+		})
+		return p, nil
+	}
+
+	segIn := input
+	for i := from; i < to; {
+		end := i + 1
+		if !isBuiltin(c.Commands[i].Name) {
+			for end < to && !isBuiltin(c.Commands[end].Name) {
+				end++
+			}
+		}
+
+		seg := segment{}
+		segOutputs, segErrOutputs := outputs, errOutputs
+		var nextIn io.Reader
+
+		if end < to {
+			//there is a following segment: connect them with a pipe
+			r, w := io.Pipe()
+			seg.out = w
+			nextIn = r
+			segOutputs = []io.Writer{w}
+			segErrOutputs = nil
+			if c.Links[end-1] == linkOutAndErr {
+				segErrOutputs = []io.Writer{w}
+			}
+		}
+		if pr, isPipe := segIn.(*io.PipeReader); isPipe && i > from {
+			seg.in = pr
+		}
+
+		if isBuiltin(c.Commands[i].Name) {
+			if isSink(c.Commands[i].Name) && end < to {
+				return nil, fmt.Errorf("%s must be the last command of the chain", c.Commands[i].Name)
+			}
+
+			//the builtin commands expand their arguments by their own (if they support placeholders at all)
+			st, err := builtinCommands[c.Commands[i].Name](env, c.Commands[i].Arguments)
+			if err != nil {
+				return nil, err
+			}
+
+			in, out := segIn, io.MultiWriter(segOutputs...)
+			seg.run = func() error {
+				return st(in, out)
+			}
+			seg.description = "[BI] " + strings.Join(append([]string{c.Commands[i].Name}, c.Commands[i].Arguments...), " ")
+		} else {
+			var group *processGroup
+			if env.processes != nil {
+				group = env.processes.newGroup()
+				segOutputs = []io.Writer{group.wrap(io.MultiWriter(segOutputs...))}
+			}
+
+			b := c.toCommandChain(env, group, i, end, segIn)
+			f := b.Finalize().
+				WithGlobalErrorChecker(cmdchain.IgnoreExitErrors()).
+				WithOutput(segOutputs...).
+				WithError(segErrOutputs...)
+
+			seg.run = f.Run
+			if group != nil {
+				seg.run = func() error {
+					defer group.markFinished()
+					return f.Run()
+				}
+			}
+			seg.description = f.String()
+		}
+
+		p.segments = append(p.segments, seg)
+		segIn = nextIn
+		i = end
+	}
+
+	return p, nil
+}
+
+func (c *Chain) toCommandChain(env chainEnv, group *processGroup, from, to int, input io.Reader) cmdchain.ChainBuilder {
+	var b cmdchain.ChainBuilder = cmdchain.Builder().WithInput(input)
+	variables := env.variables()
+
+	for i := from; i < to; i++ {
+		cmd := b.Join(c.Commands[i].Name, env.expand(c.Commands[i].Arguments)...)
+		if len(variables) > 0 {
+			cmd = cmd.WithAdditionalEnvironment(variables...)
+		}
+		if group != nil {
+			cmd = cmd.ApplyBeforeStart(group.add)
+		}
+
+		//is not last command, check the link to the next command
+		if i+1 < to {
+			if c.Links[i] == linkOutAndErr {
+				cmd.ForwardError()
+			}
+		}
+		b = cmd
+	}
+
+	return b
 }
 
 func (c *Chain) IsAppending() bool {
@@ -173,6 +271,27 @@ func (c *Chain) IsAppending() bool {
 	return false
 }
 
+// HasShellOutput returns true if the output of the chain should be written to the shell
+func (c *Chain) HasShellOutput() bool {
+	if c.IsAppending() {
+		return false
+	}
+	return !isSink(c.Commands[len(c.Commands)-1].Name) || len(c.Commands) == 1
+}
+
+// String returns the (quoted) line of the chain
+func (c *Chain) String() string {
+	parts := make([]string, len(c.RawLine))
+	for i, part := range c.RawLine {
+		if links[part] || (part == "&" && i == len(c.RawLine)-1) {
+			parts[i] = part
+		} else {
+			parts[i] = shellquote.Join(part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
 func (c *Chain) IsLongTerm() bool {
 	//if the last sign is "&"
 	return c.RawLine[len(c.RawLine)-1] == "&"
@@ -3,7 +3,12 @@ package io
 import (
 	"bytes"
 	"fmt"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -82,7 +87,7 @@ func TestChain_ToCommand(t *testing.T) {
 	testInput := &bytes.Buffer{}
 	testOutput := &bytes.Buffer{}
 
-	cmd, fn, err := toTest.ToCommand(testInput, testOutput)
+	cmd, fn, err := toTest.ToCommand(chainEnv{}, testInput, testOutput)
 	defer fn()
 
 	assert.NoError(t, err)
@@ -102,14 +107,14 @@ func TestChain_ToCommand_Appending(t *testing.T) {
 	testInput := &bytes.Buffer{}
 	testOutput := &bytes.Buffer{}
 
-	cmd, fn, err := toTest.ToCommand(testInput, testOutput)
+	cmd, fn, err := toTest.ToCommand(chainEnv{}, testInput, testOutput)
 	defer fn()
 
 	assert.NoError(t, err)
 
 	assert.Equal(t,
 		`[IS] *bytes.Buffer ╮
-[OS]               │                                                               ╭ *bytes.Buffer, *os.File
+[OS]               │                                                               ╭ *bytes.Buffer, *io.redirectFile
 [SO]               │                      ╭╮                   ╭╮                  │
 [CM]               ╰ /usr/bin/echo "test" ╡╞ /usr/bin/grep "t" ╡╰ /usr/bin/wc "-l" ╡
 [SE]                                      ╰╯                   ╽                   ╽`, cmd.String())
@@ -121,3 +126,120 @@ func TestChain_IsLongTerm(t *testing.T) {
 
 	assert.True(t, toTest.IsLongTerm())
 }
+
+func TestChain_ToCommand_builtin(t *testing.T) {
+	toTest, err := interpretLine(`sub test | select .value | grep 1 | template "value: {{.}}"`)
+	assert.NoError(t, err)
+
+	testInput := bytes.NewBufferString(`{"value": 1}` + "\n" + `{"value": 2}` + "\n" + `{"value": 11}`)
+	testOutput := &bytes.Buffer{}
+
+	cmd, fn, err := toTest.ToCommand(chainEnv{}, testInput, testOutput)
+	defer fn()
+
+	assert.NoError(t, err)
+	assert.Equal(t, `[BI] select .value
+[IS] *io.PipeReader ╮
+[OS]                │                  *io.PipeWriter
+[SO]                │
+[CM]                ╰ /usr/bin/grep "1"
+[BI] template value: {{.}}`, cmd.String())
+
+	assert.NoError(t, cmd.Run())
+	assert.Equal(t, "value: 1\nvalue: 11\n", testOutput.String())
+}
+
+func TestChain_ToCommand_builtinClosedByNext(t *testing.T) {
+	toTest, err := interpretLine(`sub test | select .value | head -1`)
+	assert.NoError(t, err)
+
+	testInput := bytes.NewBufferString(strings.Repeat(`{"value": 1}`+"\n", 100000))
+	testOutput := &bytes.Buffer{}
+
+	cmd, fn, err := toTest.ToCommand(chainEnv{}, testInput, testOutput)
+	defer fn()
+
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Run(), "an early end of the next segment is not an error")
+	assert.Equal(t, "1\n", testOutput.String())
+}
+
+func TestChain_ToCommand_placeholders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("a/b").AnyTimes()
+	testMessage.EXPECT().Qos().Return(byte(0)).AnyTimes()
+	testMessage.EXPECT().Retained().Return(false).AnyTimes()
+	testMessage.EXPECT().MessageID().Return(uint16(1)).AnyTimes()
+
+	toTest, err := interpretLine(`sub test | jq -c '\{topic}' | template "{topic}: {{.topic}}" | sed s#^#{topic}-#`)
+	assert.NoError(t, err)
+
+	testOutput := &bytes.Buffer{}
+
+	cmd, fn, err := toTest.ToCommand(chainEnv{message: testMessage}, bytes.NewBufferString(`{"topic": "t"}`), testOutput)
+	defer fn()
+
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Run())
+	assert.Equal(t, "a/b-{topic}: t\n", testOutput.String(), "only the arguments of external applications should be expanded")
+}
+
+func TestChain_ToCommand_builtinError(t *testing.T) {
+	toTest, err := interpretLine(`sub test | select .value | wc -l`)
+	assert.NoError(t, err)
+
+	cmd, fn, err := toTest.ToCommand(chainEnv{}, bytes.NewBufferString(`no json`), &bytes.Buffer{})
+	defer fn()
+
+	assert.NoError(t, err)
+	assert.EqualError(t, cmd.Run(), "one or more command has returned an error: [0 - select: unable to parse json input: invalid character 'o' in literal null (expecting 'u')]")
+}
+
+func TestChain_ToCommand_onlyFile(t *testing.T) {
+	outputFile := path.Join(t.TempDir(), "output")
+	toTest, err := interpretLine(`sub test > ` + outputFile)
+	assert.NoError(t, err)
+
+	cmd, fn, err := toTest.ToCommand(chainEnv{}, bytes.NewBufferString("payload"))
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Run())
+	fn()
+
+	content, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "payload", string(content))
+}
+
+func TestChain_ToCommand_sinkIsNotLast(t *testing.T) {
+	toTest, err := interpretLine(`sub test | pub out/topic | wc -l`)
+	assert.NoError(t, err)
+
+	_, fn, err := toTest.ToCommand(chainEnv{}, &bytes.Buffer{})
+	defer fn()
+
+	assert.EqualError(t, err, "pub must be the last command of the chain")
+}
+
+func TestChain_HasShellOutput(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected bool
+	}{
+		{`sub test`, true},
+		{`sub test | grep t`, true},
+		{`sub test | grep t > /tmp/test`, false},
+		{`sub test | grep t | pub out/topic`, false},
+		{`sub test | pub out/topic &`, false},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestChain_HasShellOutput_%d", i), func(t *testing.T) {
+			toTest, err := interpretLine(test.line)
+			assert.NoError(t, err)
+
+			assert.Equal(t, test.expected, toTest.HasShellOutput())
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package io
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// jobBufferSize is the count of messages which will be buffered for a job (for example while it is restarting)
+const jobBufferSize = 1000
+
+// wrap variables for monkey patching purposes (unit tests)
+var restartBackoff = 1 * time.Second
+var maxRestartBackoff = 1 * time.Minute
+
+type restartPolicy int
+
+const (
+	restartNever restartPolicy = iota
+	restartOnFailure
+	restartAlways
+)
+
+var restartPolicies = map[string]restartPolicy{
+	"never":      restartNever,
+	"on-failure": restartOnFailure,
+	"always":     restartAlways,
+}
+
+func (r restartPolicy) shouldRestart(failure error) bool {
+	switch r {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return failure != nil
+	default:
+		return false
+	}
+}
+
+// errJobBufferFull will be returned if a message can not be buffered for a job
+var errJobBufferFull = errors.New("the buffer of the job is full")
+
+// commandHandle is a long term chain (job) which is running in background
+type commandHandle struct {
+	//must be the first fields (64bit alignment for atomic operations)
+	fed     uint64
+	dropped uint64
+
+	id      int
+	topic   string
+	line    string
+	started time.Time
+	restart restartPolicy
+
+	w         io.Closer
+	closeChan chan interface{}
+
+	mutex     sync.Mutex
+	processes *processList
+	restarts  int
+	waiting   bool
+	killed    bool
+	finished  time.Time
+	err       error
+}
+
+// jobInstance is one execution of the chain of a job
+type jobInstance struct {
+	cmd       runnable
+	clb       func()
+	processes *processList
+
+	//the input pipe of the chain
+	r, w *os.File
+}
+
+func (h *commandHandle) newInstance(env chainEnv, chain Chain) (*jobInstance, error) {
+	//use a os pipe so that the death of a process will be recognised immediately
+	//(the execution would wait for the next input otherwise)
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	inst := &jobInstance{processes: &processList{}, r: r, w: w}
+	env.processes = inst.processes
+
+	inst.cmd, inst.clb, err = chain.ToCommand(env, r)
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+
+	return inst, nil
+}
+
+func (h *commandHandle) isRunning() bool {
+	if h.closeChan == nil {
+		return false
+	}
+
+	select {
+	case <-h.closeChan:
+		return false
+	default:
+		return true
+	}
+}
+
+func (h *commandHandle) status() string {
+	running := h.isRunning()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if running {
+		if h.waiting {
+			return "restarting"
+		}
+		return "running"
+	}
+	if h.killed {
+		return "killed"
+	}
+	if h.err != nil {
+		return "failed: " + h.err.Error()
+	}
+	return "exited"
+}
+
+func (h *commandHandle) uptime() time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	end := timeNow()
+	if !h.finished.IsZero() {
+		end = h.finished
+	}
+	return end.Sub(h.started).Truncate(time.Second)
+}
+
+func (h *commandHandle) restartCount() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.restarts
+}
+
+func (h *commandHandle) currentProcesses() *processList {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.processes
+}
+
+func (h *commandHandle) pids() string {
+	processes := h.currentProcesses()
+	if processes == nil || !h.isRunning() {
+		return "-"
+	}
+
+	pids := processes.pids()
+	if len(pids) == 0 {
+		return "-"
+	}
+
+	rawPids := make([]string, len(pids))
+	for i, pid := range pids {
+		rawPids[i] = strconv.Itoa(pid)
+	}
+	return strings.Join(rawPids, ",")
+}
+
+// setProcesses sets the processes of the current instance
+func (h *commandHandle) setProcesses(processes *processList) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.processes = processes
+	if h.killed {
+		processes.kill()
+	}
+}
+
+// kill closes the input of the job and kills all of its processes
+func (h *commandHandle) kill() {
+	h.mutex.Lock()
+	h.killed = true
+	processes := h.processes
+	h.mutex.Unlock()
+
+	h.w.Close()
+	if processes != nil {
+		processes.kill()
+	}
+}
+
+// jobInput is the input of a job. The messages will be buffered so that they can be
+// replayed into a restarted chain.
+type jobInput struct {
+	messages  chan []byte
+	closing   chan interface{}
+	closeOnce sync.Once
+
+	//will be closed if the job is finished
+	done chan interface{}
+}
+
+func newJobInput(done chan interface{}) *jobInput {
+	return &jobInput{
+		messages: make(chan []byte, jobBufferSize),
+		closing:  make(chan interface{}),
+		done:     done,
+	}
+}
+
+// push adds the given message to the buffer. It will be called by the message handler: so it must not block. If the
+// buffer is full (for example while the job is restarting), the message will be rejected.
+func (j *jobInput) push(msg []byte) error {
+	select {
+	case <-j.closing:
+		return io.ErrClosedPipe
+	case <-j.done:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	select {
+	case j.messages <- msg:
+		return nil
+	default:
+		return errJobBufferFull
+	}
+}
+
+func (j *jobInput) Close() error {
+	j.closeOnce.Do(func() {
+		close(j.closing)
+	})
+	return nil
+}
+
+func (j *jobInput) isClosed() bool {
+	select {
+	case <-j.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// feed writes the buffered messages into the given pipe until the input is closed or the instance is done.
+// If the pipe is broken, the unwritten message will be returned so that it can be replayed later.
+func (j *jobInput) feed(w io.WriteCloser, pending []byte, instanceDone chan interface{}) []byte {
+	defer w.Close()
+
+	if pending != nil {
+		if _, err := w.Write(pending); err != nil {
+			return pending
+		}
+	}
+
+	for {
+		select {
+		case msg := <-j.messages:
+			if _, err := w.Write(msg); err != nil {
+				return msg
+			}
+		case <-instanceDone:
+			return nil
+		case <-j.closing:
+			//write all remaining messages
+			for {
+				select {
+				case msg := <-j.messages:
+					if _, err := w.Write(msg); err != nil {
+						return nil
+					}
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// runJob runs the given instance of the job and restarts it (depending on the restart policy)
+func (p *processor) runJob(job *commandHandle, input *jobInput, env chainEnv, chain Chain, inst *jobInstance) {
+	defer p.changed()
+	defer close(job.closeChan)
+
+	finish := func(err error) {
+		job.mutex.Lock()
+		defer job.mutex.Unlock()
+
+		job.finished = timeNow()
+		job.err = err
+	}
+
+	backoff := restartBackoff
+	var pending []byte
+
+	for {
+		started := timeNow()
+		instanceDone := make(chan interface{})
+		feedDone := make(chan []byte)
+		go func(w *os.File, pending []byte) {
+			feedDone <- input.feed(w, pending, instanceDone)
+		}(inst.w, pending)
+
+		//the command chain will be finished if the underlying pipe is closed
+		err := inst.cmd.Run()
+		inst.r.Close()
+		close(instanceDone)
+		pending = <-feedDone
+		inst.clb()
+
+		failure := err
+		if failure == nil {
+			failure = inst.processes.exitError()
+		}
+
+		if input.isClosed() {
+			//the job was stopped regularly (unsub, kill, exit)
+			if err != nil {
+				p.out.Write([]byte(err.Error() + "\n"))
+			}
+			finish(failure)
+			return
+		}
+
+		//nobody has closed the input: the chain has died by itself
+		report := fmt.Sprintf("job [%d] %s exited unexpectedly", job.id, job.topic)
+		if failure != nil {
+			report = fmt.Sprintf("job [%d] %s died: %s", job.id, job.topic, failure.Error())
+		}
+
+		if !job.restart.shouldRestart(failure) {
+			p.out.Write([]byte(report + "\n"))
+			finish(failure)
+			return
+		}
+
+		if timeNow().Sub(started) > maxRestartBackoff {
+			//the instance was running long enough: it seems to be a new problem
+			backoff = restartBackoff
+		}
+		p.out.Write([]byte(fmt.Sprintf("%s (restarting in %s)\n", report, backoff)))
+
+		job.mutex.Lock()
+		job.waiting = true
+		job.mutex.Unlock()
+
+		select {
+		case <-time.After(backoff):
+		case <-input.closing:
+			finish(failure)
+			return
+		}
+
+		backoff *= 2
+		if backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+
+		inst, err = job.newInstance(env, chain)
+		if err != nil {
+			p.out.Write([]byte(fmt.Sprintf("job [%d] %s: unable to restart: %s\n", job.id, job.topic, err.Error())))
+			finish(err)
+			return
+		}
+
+		job.mutex.Lock()
+		job.restarts++
+		job.waiting = false
+		job.mutex.Unlock()
+		job.setProcesses(inst.processes)
+	}
+}
+
+func (p *processor) sortedJobs() []*commandHandle {
+	jobs := make([]*commandHandle, 0, len(p.longTermCommands)+len(p.retiredJobs))
+	for _, job := range p.longTermCommands {
+		jobs = append(jobs, job)
+	}
+	jobs = append(jobs, p.retiredJobs...)
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].id < jobs[j].id
+	})
+
+	return jobs
+}
+
+func (p *processor) handleJobs(chain Chain) error {
+	args := chain.Commands[0].Arguments
+	if len(args) == 1 && args[0] == "-c" {
+		p.clearRetiredJobs()
+		return nil
+	}
+	if len(args) > 0 {
+		return errors.New("invalid arguments\nUsage: " + commandJobs + " [-c]")
+	}
+
+	//the table will be written at once: the tabwriter writes each cell separately
+	buf := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTOPIC\tPID\tUPTIME\tFED\tDROPPED\tRESTARTS\tSTATUS\tCOMMAND")
+	for _, job := range p.sortedJobs() {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n",
+			job.id, job.topic, job.pids(), job.uptime(), atomic.LoadUint64(&job.fed), atomic.LoadUint64(&job.dropped),
+			job.restartCount(), job.status(), job.line)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := p.out.Write(buf.Bytes())
+	return err
+}
+
+// clearRetiredJobs removes the finished jobs which are not subscribed anymore from the listing
+func (p *processor) clearRetiredJobs() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var running []*commandHandle
+	for _, job := range p.retiredJobs {
+		if job.isRunning() {
+			running = append(running, job)
+		}
+	}
+	p.retiredJobs = running
+}
+
+func (p *processor) handleKill(chain Chain) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: "+commandKill+" <job-id|topic> [...job-idN|topicN]", err.Error())
+		}
+	}()
+
+	if len(chain.Commands[0].Arguments) == 0 {
+		return errors.New("invalid arguments")
+	}
+
+	for _, arg := range chain.Commands[0].Arguments {
+		job := p.findJob(arg)
+		if job == nil {
+			return fmt.Errorf("no such job: %s", arg)
+		}
+
+		job.kill()
+		if err := p.unsubscribe(job.topic); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findJob finds the job by its id or by its topic
+func (p *processor) findJob(idOrTopic string) *commandHandle {
+	if job, ok := p.longTermCommands[idOrTopic]; ok {
+		return job
+	}
+
+	id, err := strconv.Atoi(idOrTopic)
+	if err != nil {
+		return nil
+	}
+	for _, job := range p.longTermCommands {
+		if job.id == id {
+			return job
+		}
+	}
+	return nil
+}
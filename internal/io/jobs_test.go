@@ -0,0 +1,307 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func startTestJob(t *testing.T, toTest *processor, topic, line string) (*commandHandle, func([]byte)) {
+	ctrl := gomock.NewController(t)
+
+	testChain, err := interpretLine(line)
+	assert.NoError(t, err)
+
+	fn, err := genSubHandler(toTest, topic, testChain, subOptions{})
+	assert.NoError(t, err)
+
+	return toTest.longTermCommands[topic], func(payload []byte) {
+		testMessage := mock_io.NewMockMessage(ctrl)
+		testMessage.EXPECT().Payload().Return(payload)
+		fn(nil, testMessage)
+	}
+}
+
+func waitForJob(t *testing.T, job *commandHandle) {
+	select {
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "timout reached while waiting for job to end")
+	case <-job.closeChan:
+	}
+}
+
+func TestProcessor_Process_jobsCommand(t *testing.T) {
+	otn := timeNow
+	defer func() {
+		timeNow = otn
+	}()
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+
+	outputFile := path.Join(t.TempDir(), "jobs.txt")
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	job, feed := startTestJob(t, toTest, "a/topic", fmt.Sprintf(`%s a/topic | cat > %s &`, commandSub, outputFile))
+	feed([]byte("PAYLOAD"))
+	feed([]byte("payload"))
+	assert.Eventually(t, func() bool {
+		return len(job.processes.pids()) == 1
+	}, 1*time.Second, 10*time.Millisecond)
+
+	job.started = now.Add(-90 * time.Second)
+	toTest.Process(filledChan(commandJobs))
+
+	assert.Regexp(t, `^ID  TOPIC    PID +UPTIME  FED  DROPPED  RESTARTS  STATUS   COMMAND
+1   a/topic  [0-9]+ +1m30s   2    0        0         running  sub a/topic \| cat > .*jobs.txt &
+$`, output.String())
+
+	//Process will close all job inputs
+	waitForJob(t, job)
+
+	output.Reset()
+	toTest.Process(filledChan(commandJobs))
+
+	assert.Regexp(t, `^ID  TOPIC    PID  UPTIME  FED  DROPPED  RESTARTS  STATUS  COMMAND
+1   a/topic  -    1m30s   2    0        0         exited  sub a/topic \| cat > .*jobs.txt &
+$`, output.String())
+}
+
+func TestProcessor_RunningJobs(t *testing.T) {
+	outputFile := path.Join(t.TempDir(), "jobs.txt")
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+	changes := make(chan bool, 10)
+	toTest.OnChange = func() {
+		changes <- true
+	}
+
+	assert.Equal(t, 0, toTest.RunningJobs())
+
+	job, _ := startTestJob(t, toTest, "a/topic", fmt.Sprintf(`%s a/topic | cat > %s &`, commandSub, outputFile))
+	assert.Equal(t, 1, toTest.RunningJobs())
+
+	toTest.Process(filledChan(commandJobs))
+	assert.Len(t, changes, 1, "each processed command should be reported")
+
+	//Process will close all job inputs
+	waitForJob(t, job)
+	assert.Eventually(t, func() bool {
+		return len(changes) == 2
+	}, time.Second, time.Millisecond, "the finished job should be reported")
+	assert.Equal(t, 0, toTest.RunningJobs())
+}
+
+func TestProcessor_Process_jobsCommand_invalidArguments(t *testing.T) {
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	toTest.Process(filledChan(commandJobs + " 1"))
+
+	assert.Equal(t, "invalid arguments\nUsage: jobs [-c]\n", output.String())
+}
+
+func TestProcessor_Process_killCommand(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	outputFile := path.Join(t.TempDir(), "jobs.txt")
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).Times(2)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Unsubscribe(gomock.Eq("a/topic")).Return(mockToken)
+	mockMqtt.EXPECT().Unsubscribe(gomock.Eq("b/topic")).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	jobA, _ := startTestJob(t, toTest, "a/topic", fmt.Sprintf(`%s a/topic | sleep 10 > %s &`, commandSub, outputFile))
+	jobB, _ := startTestJob(t, toTest, "b/topic", fmt.Sprintf(`%s b/topic | sleep 10 > %s &`, commandSub, outputFile))
+
+	toTest.Process(filledChan(commandKill+" 1", commandKill+" b/topic"))
+
+	waitForJob(t, jobA)
+	waitForJob(t, jobB)
+
+	assert.Empty(t, toTest.longTermCommands)
+	assert.Equal(t, "", output.String())
+}
+
+func TestProcessor_Process_killCommand_jobsListing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	outputFile := path.Join(t.TempDir(), "jobs.txt")
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Unsubscribe(gomock.Eq("a/topic")).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	job, _ := startTestJob(t, toTest, "a/topic", fmt.Sprintf(`%s a/topic | sleep 10 > %s &`, commandSub, outputFile))
+
+	toTest.Process(filledChan(commandKill + " 1"))
+	waitForJob(t, job)
+
+	toTest.Process(filledChan(commandJobs))
+
+	assert.Regexp(t, `^ID  TOPIC    PID  UPTIME  FED  DROPPED  RESTARTS  STATUS  COMMAND
+1   a/topic  -    [0-9]+s +0    0        0         killed  sub a/topic \| sleep 10 > .*jobs.txt &
+$`, output.String())
+	assert.Equal(t, 0, toTest.RunningJobs())
+
+	output.Reset()
+	toTest.Process(filledChan(commandJobs+" -c", commandJobs))
+
+	assert.Equal(t, "ID  TOPIC  PID  UPTIME  FED  DROPPED  RESTARTS  STATUS  COMMAND\n", output.String())
+}
+
+func TestProcessor_Process_killCommand_unknownJob(t *testing.T) {
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	toTest.Process(filledChan(commandKill+" 13", commandKill))
+
+	assert.Equal(t, "no such job: 13\nUsage: kill <job-id|topic> [...job-idN|topicN]\n"+
+		"invalid arguments\nUsage: kill <job-id|topic> [...job-idN|topicN]\n", output.String())
+}
+
+func TestGenSubHandler_longTermSub_diedUnexpectedly(t *testing.T) {
+	outputFile := path.Join(t.TempDir(), "jobs.txt")
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	job, feed := startTestJob(t, toTest, "a/topic", fmt.Sprintf(`%s a/topic | head -n 1 > %s &`, commandSub, outputFile))
+	feed([]byte("first"))
+
+	waitForJob(t, job)
+
+	assert.Equal(t, "job [1] a/topic exited unexpectedly\n", output.String())
+	assert.Equal(t, "exited", job.status())
+}
+
+func TestGenSubHandler_longTermSub_restart(t *testing.T) {
+	orb := restartBackoff
+	defer func() {
+		restartBackoff = orb
+	}()
+	restartBackoff = 50 * time.Millisecond
+
+	outputFile := path.Join(t.TempDir(), "jobs.txt")
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | sh -c "head -n 1; exit 3" >> %s &`, commandSub, outputFile))
+	assert.NoError(t, err)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	fn, err := genSubHandler(toTest, "a/topic", testChain, subOptions{restart: restartOnFailure})
+	assert.NoError(t, err)
+	job := toTest.longTermCommands["a/topic"]
+
+	feed := func(payload string) {
+		testMessage := mock_io.NewMockMessage(ctrl)
+		testMessage.EXPECT().Payload().Return([]byte(payload))
+		fn(nil, testMessage)
+	}
+
+	feed("first")
+	assert.Eventually(t, func() bool {
+		return job.status() == "restarting"
+	}, 1*time.Second, 5*time.Millisecond)
+
+	//this message will be buffered and replayed into the new instance
+	feed("second")
+	assert.Eventually(t, func() bool {
+		content, _ := os.ReadFile(outputFile)
+		return string(content) == "first\nsecond\n"
+	}, 1*time.Second, 5*time.Millisecond)
+	assert.True(t, job.restartCount() >= 1)
+
+	job.kill()
+	waitForJob(t, job)
+
+	assert.Contains(t, output.String(), "job [1] a/topic died: sh: exit status 3 (restarting in 50ms)\n")
+}
+
+func TestGenSubHandler_longTermSub_restartOnFailure_success(t *testing.T) {
+	outputFile := path.Join(t.TempDir(), "jobs.txt")
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | head -n 1 > %s &`, commandSub, outputFile))
+	assert.NoError(t, err)
+
+	fn, err := genSubHandler(toTest, "a/topic", testChain, subOptions{restart: restartOnFailure})
+	assert.NoError(t, err)
+	job := toTest.longTermCommands["a/topic"]
+
+	testMessage := mock_io.NewMockMessage(gomock.NewController(t))
+	testMessage.EXPECT().Payload().Return([]byte("first"))
+	fn(nil, testMessage)
+
+	waitForJob(t, job)
+
+	assert.Equal(t, "job [1] a/topic exited unexpectedly\n", output.String())
+	assert.Equal(t, 0, job.restartCount())
+}
+
+func TestGenSubHandler_restartPolicyForShortTermChain(t *testing.T) {
+	toTest := NewProcessor(&bytes.Buffer{}, nil)
+
+	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | cat`, commandSub))
+	assert.NoError(t, err)
+
+	_, err = genSubHandler(toTest, "a/topic", testChain, subOptions{restart: restartAlways})
+	assert.EqualError(t, err, "a restart policy is only available for long term chains")
+}
+
+func TestJobInput_push_full(t *testing.T) {
+	toTest := newJobInput(make(chan interface{}))
+	for i := 0; i < jobBufferSize; i++ {
+		assert.NoError(t, toTest.push([]byte("msg")))
+	}
+
+	assert.Equal(t, errJobBufferFull, toTest.push([]byte("msg")), "a full buffer must not block")
+
+	toTest.Close()
+	assert.Equal(t, io.ErrClosedPipe, toTest.push([]byte("msg")))
+}
+
+func TestProcessList_finished(t *testing.T) {
+	toTest, err := interpretLine(`sub a/topic | sh -c "exit 3"`)
+	assert.NoError(t, err)
+
+	env := chainEnv{processes: &processList{}}
+	cmd, fn, err := toTest.ToCommand(env, bytes.NewBufferString("PAYLOAD"), &bytes.Buffer{})
+	defer fn()
+	assert.NoError(t, err)
+
+	assert.NoError(t, cmd.Run())
+	assert.Empty(t, env.processes.pids(), "the finished processes are not running anymore")
+	assert.EqualError(t, env.processes.exitError(), "sh: exit status 3")
+
+	//the process ids could be reused already: they must not be killed
+	env.processes.kill()
+	assert.True(t, env.processes.groups[0].finished)
+}
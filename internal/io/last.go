@@ -0,0 +1,181 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (p *processor) handleLast(chain Chain) error {
+	if len(chain.Commands[0].Arguments) != 1 {
+		return errors.New("invalid arguments\nUsage: " + commandLast + " <topic|filter>")
+	}
+	filter := chain.Commands[0].Arguments[0]
+
+	topics := p.topics.matching(filter)
+	if len(topics) == 0 {
+		p.out.Write([]byte(fmt.Sprintf("no message seen on %s\n", filter)))
+		return nil
+	}
+
+	sortedTopics := make([]string, 0, len(topics))
+	for topic := range topics {
+		sortedTopics = append(sortedTopics, topic)
+	}
+	sort.Strings(sortedTopics)
+
+	for _, topic := range sortedTopics {
+		stats := topics[topic]
+
+		header := fmt.Sprintf("%s (%d messages, %s ago)", topic, stats.count, timeNow().Sub(stats.lastSeen).Truncate(time.Millisecond))
+		if stats.retained {
+			header += " [retained]"
+		}
+		p.out.Write([]byte(header + "\n" + string(stats.last) + "\n"))
+	}
+
+	return nil
+}
+
+func (p *processor) handleDiff(chain Chain) error {
+	if len(chain.Commands[0].Arguments) != 1 {
+		return errors.New("invalid arguments\nUsage: " + commandDiff + " <topic>")
+	}
+	topic := chain.Commands[0].Arguments[0]
+
+	stats, ok := p.topics.matching(topic)[topic]
+	if !ok || stats.count < 2 {
+		p.out.Write([]byte(fmt.Sprintf("no previous message seen on %s\n", topic)))
+		return nil
+	}
+
+	var changes []string
+	previous, pErr := decodeJson(stats.previous)
+	current, cErr := decodeJson(stats.last)
+	if pErr == nil && cErr == nil {
+		changes = diffJson("", previous, current, changes)
+	} else {
+		changes = diffText(stats.previous, stats.last)
+	}
+
+	if len(changes) == 0 {
+		p.out.Write([]byte("no changes\n"))
+		return nil
+	}
+	p.out.Write([]byte(strings.Join(changes, "\n") + "\n"))
+
+	return nil
+}
+
+// decodeJson decodes the given json value. The numbers will be kept as they are (large integers would lose their
+// precision as float64).
+func decodeJson(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid json: unexpected data after the value")
+	}
+	return value, nil
+}
+
+// diffJson compares two json values recursively and reports each changed path (in the notation of the select command)
+func diffJson(path string, previous, current interface{}, changes []string) []string {
+	if reflect.DeepEqual(previous, current) {
+		return changes
+	}
+
+	pMap, pIsMap := previous.(map[string]interface{})
+	cMap, cIsMap := current.(map[string]interface{})
+	if pIsMap && cIsMap {
+		keys := make([]string, 0, len(pMap)+len(cMap))
+		for key := range pMap {
+			keys = append(keys, key)
+		}
+		for key := range cMap {
+			if _, ok := pMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			pValue, pOk := pMap[key]
+			cValue, cOk := cMap[key]
+			keyPath := path + "." + key
+
+			switch {
+			case !pOk:
+				changes = append(changes, "+ "+keyPath+": "+jsonString(cValue))
+			case !cOk:
+				changes = append(changes, "- "+keyPath+": "+jsonString(pValue))
+			default:
+				changes = diffJson(keyPath, pValue, cValue, changes)
+			}
+		}
+		return changes
+	}
+
+	pArray, pIsArray := previous.([]interface{})
+	cArray, cIsArray := current.([]interface{})
+	if pIsArray && cIsArray {
+		for i := 0; i < len(pArray) || i < len(cArray); i++ {
+			indexPath := path + "[" + strconv.Itoa(i) + "]"
+
+			switch {
+			case i >= len(pArray):
+				changes = append(changes, "+ "+indexPath+": "+jsonString(cArray[i]))
+			case i >= len(cArray):
+				changes = append(changes, "- "+indexPath+": "+jsonString(pArray[i]))
+			default:
+				changes = diffJson(indexPath, pArray[i], cArray[i], changes)
+			}
+		}
+		return changes
+	}
+
+	if path == "" {
+		path = "."
+	}
+	return append(changes, "~ "+path+": "+jsonString(previous)+" -> "+jsonString(current))
+}
+
+func jsonString(value interface{}) string {
+	//the value was unmarshalled from json: so it can be marshalled again
+	raw, _ := json.Marshal(value)
+	return string(raw)
+}
+
+// diffText compares two (non-json) payloads line by line
+func diffText(previous, current []byte) []string {
+	if bytes.Equal(previous, current) {
+		return nil
+	}
+
+	pLines := strings.Split(string(previous), "\n")
+	cLines := strings.Split(string(current), "\n")
+
+	var changes []string
+	for i := 0; i < len(pLines) || i < len(cLines); i++ {
+		switch {
+		case i >= len(pLines):
+			changes = append(changes, "+ "+cLines[i])
+		case i >= len(cLines):
+			changes = append(changes, "- "+pLines[i])
+		case pLines[i] != cLines[i]:
+			changes = append(changes, "- "+pLines[i], "+ "+cLines[i])
+		}
+	}
+	return changes
+}
@@ -0,0 +1,113 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestProcessor_Process_last(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	otn := timeNow
+	defer func() {
+		timeNow = otn
+	}()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	handler := toTest.observe("#", func(mqtt.Client, mqtt.Message) {})
+	handler(nil, testMessage(ctrl, "a/first", "1"))
+	handler(nil, testMessage(ctrl, "a/first", "2"))
+	now = now.Add(1500 * time.Millisecond)
+	handler(nil, testMessage(ctrl, "a/second", "3", retainedTestMessage))
+	handler(nil, testMessage(ctrl, "b/third", "4"))
+	now = now.Add(1 * time.Second)
+
+	toTest.Process(filledChan(commandLast+" a/#", commandLast+" c/#"))
+
+	assert.Equal(t, `a/first (2 messages, 2.5s ago)
+2
+a/second (1 messages, 1s ago) [retained]
+3
+no message seen on c/#
+`, output.String())
+}
+
+func TestProcessor_Process_diff(t *testing.T) {
+	tests := []struct {
+		payloads []string
+		expected string
+	}{
+		{[]string{`{"a": 1}`}, "no previous message seen on a/topic\n"},
+		{[]string{`{"a": 1}`, `{"a": 1}`}, "no changes\n"},
+		{[]string{`{"a": 1, "b": {"c": true}, "d": "x"}`, `{"a": 2, "b": {"c": false}, "e": [1]}`},
+			"~ .a: 1 -> 2\n~ .b.c: true -> false\n- .d: \"x\"\n+ .e: [1]\n"},
+		{[]string{`{"list": [1, 2]}`, `{"list": [1, 3, 4]}`}, "~ .list[1]: 2 -> 3\n+ .list[2]: 4\n"},
+		{[]string{`21.5`, `22`}, "~ .: 21.5 -> 22\n"},
+		{[]string{`{"a": 1}`, `[1]`}, "~ .: {\"a\":1} -> [1]\n"},
+		{[]string{`{"id": 9007199254740993}`, `{"id": 9007199254740992}`}, "~ .id: 9007199254740993 -> 9007199254740992\n"},
+		{[]string{`{"a": 1} x`, `{"a": 2} x`}, "- {\"a\": 1} x\n+ {\"a\": 2} x\n"},
+		{[]string{"line1\nline2", "line1\nline3\nline4"}, "- line2\n+ line3\n+ line4\n"},
+		{[]string{"on", "off"}, "- on\n+ off\n"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestProcessor_Process_diff_%d", i), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			output := &bytes.Buffer{}
+			toTest := NewProcessor(output, nil)
+
+			handler := toTest.observe("#", func(mqtt.Client, mqtt.Message) {})
+			for _, payload := range test.payloads {
+				handler(nil, testMessage(ctrl, "a/topic", payload))
+			}
+
+			toTest.Process(filledChan(commandDiff + " a/topic"))
+
+			assert.Equal(t, test.expected, output.String())
+		})
+	}
+}
+
+func TestProcessor_Process_last_overlappingSubscriptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	first := toTest.observe("a/#", func(mqtt.Client, mqtt.Message) {})
+	second := toTest.observe("a/topic", func(mqtt.Client, mqtt.Message) {})
+
+	//the same message will be passed to both subscriptions
+	message := testMessage(ctrl, "a/topic", "1")
+	first(nil, message)
+	second(nil, message)
+
+	toTest.Process(filledChan(commandDiff + " a/topic"))
+
+	assert.Equal(t, uint64(1), toTest.topics.matching("a/topic")["a/topic"].count)
+	assert.Equal(t, "no previous message seen on a/topic\n", output.String())
+}
+
+func TestProcessor_Process_lastAndDiff_invalidArguments(t *testing.T) {
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	toTest.Process(filledChan(commandLast, commandDiff+" a b"))
+
+	assert.Equal(t, "invalid arguments\nUsage: "+commandLast+" <topic|filter>\n"+
+		"invalid arguments\nUsage: "+commandDiff+" <topic>\n", output.String())
+}
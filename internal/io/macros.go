@@ -202,7 +202,7 @@ func (m *MacroManager) IsMacro(line string) bool {
 		fallthrough
 	case line == commandHelp:
 		fallthrough
-	case line == commandList:
+	case line == commandList || strings.HasPrefix(line, commandList+" "):
 		fallthrough
 	case line == commandListColors:
 		fallthrough
@@ -211,6 +211,30 @@ func (m *MacroManager) IsMacro(line string) bool {
 	case strings.HasPrefix(line, commandSub+" "):
 		fallthrough
 	case strings.HasPrefix(line, commandUnsub+" "):
+		fallthrough
+	case line == commandFwd || strings.HasPrefix(line, commandFwd+" "):
+		fallthrough
+	case line == commandJobs || strings.HasPrefix(line, commandJobs+" "):
+		fallthrough
+	case strings.HasPrefix(line, commandKill+" "):
+		fallthrough
+	case line == commandTree || strings.HasPrefix(line, commandTree+" "):
+		fallthrough
+	case strings.HasPrefix(line, commandRetained+" "):
+		fallthrough
+	case strings.HasPrefix(line, commandLast+" "):
+		fallthrough
+	case strings.HasPrefix(line, commandDiff+" "):
+		fallthrough
+	case line == commandStats || strings.HasPrefix(line, commandStats+" "):
+		fallthrough
+	case line == commandStatus:
+		fallthrough
+	case line == commandPing || strings.HasPrefix(line, commandPing+" "):
+		fallthrough
+	case line == commandRecord || strings.HasPrefix(line, commandRecord+" "):
+		fallthrough
+	case line == commandReplay || strings.HasPrefix(line, commandReplay+" "):
 		return false
 	default:
 		return true
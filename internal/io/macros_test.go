@@ -497,10 +497,29 @@ func TestMacroManager_IsMacro(t *testing.T) {
 		{commandExit, false},
 		{commandHelp, false},
 		{commandList, false},
+		{commandList + " -l", false},
 		{commandListColors, false},
 		{commandPub + " test/topic content", false},
 		{commandSub + " test/topic", false},
 		{commandUnsub + " test/topic", false},
+		{commandFwd, false},
+		{commandFwd + " src/# dst/", false},
+		{commandJobs, false},
+		{commandKill + " 1", false},
+		{commandTree, false},
+		{commandTree + " a/#", false},
+		{commandRetained + " a/#", false},
+		{commandLast + " a/#", false},
+		{commandDiff + " a/topic", false},
+		{commandStats, false},
+		{commandStats + " -r 1s", false},
+		{commandStatus, false},
+		{commandPing, false},
+		{commandPing + " -n 3", false},
+		{commandRecord, false},
+		{commandRecord + " stop", false},
+		{commandReplay, false},
+		{commandReplay + " -fast file.jsonl", false},
 		{"macro", true},
 	}
 	for i, test := range tests {
@@ -0,0 +1,476 @@
+package io
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+	pcapngMagic     = 0x0a0d0d0a
+
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+
+	mqttPort = 1883
+
+	mqttPacketConnect = 1
+	mqttPacketPublish = 3
+
+	//the maximum length of a captured frame (the snapshot length of the file can be higher: but such
+	//frames are not used by any capture tool)
+	pcapMaxSnapLen = 262144
+
+	//the maximum count of bytes which will be kept for segments which are received before their predecessors
+	tcpMaxPending = 16 * 1024 * 1024
+)
+
+func isPcapMagic(header []byte) bool {
+	if len(header) < 4 {
+		return false
+	}
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		switch order.Uint32(header) {
+		case pcapMagicMicros, pcapMagicNanos, pcapngMagic:
+			return true
+		}
+	}
+	return false
+}
+
+// pcapReader extracts the PUBLISH packets of all (unencrypted) MQTT connections of a pcap file
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+	snapLen  uint32
+
+	streams     map[string]*tcpStream
+	connections map[string]*mqttConnection
+	records     []captureRecord
+}
+
+// tcpStream is one direction of a tcp connection
+type tcpStream struct {
+	connection *mqttConnection
+	started    bool
+	next       uint32
+	buffer     []byte
+
+	//segments which are received before their predecessors
+	pending     map[uint32][]byte
+	pendingSize int
+
+	//mqtt v5 topic aliases
+	aliases map[uint16]string
+}
+
+type mqttConnection struct {
+	decided bool
+	isMqtt  bool
+	version byte
+}
+
+func decodePcapCapture(r *bufio.Reader) (captureReader, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.New("invalid pcap file")
+	}
+
+	reader := &pcapReader{
+		r:           r,
+		streams:     map[string]*tcpStream{},
+		connections: map[string]*mqttConnection{},
+	}
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		switch order.Uint32(header) {
+		case pcapMagicMicros:
+			reader.order = order
+		case pcapMagicNanos:
+			reader.order, reader.nanos = order, true
+		case pcapngMagic:
+			return nil, errors.New("pcapng files are not supported (convert it with: editcap -F pcap <in> <out>)")
+		}
+	}
+	if reader.order == nil {
+		return nil, errors.New("invalid pcap file")
+	}
+
+	reader.snapLen = reader.order.Uint32(header[16:])
+	if reader.snapLen == 0 || reader.snapLen > pcapMaxSnapLen {
+		reader.snapLen = pcapMaxSnapLen
+	}
+	reader.linkType = reader.order.Uint32(header[20:])
+	switch reader.linkType {
+	case linkTypeNull, linkTypeEthernet, linkTypeRaw, linkTypeLinuxSLL:
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", reader.linkType)
+	}
+
+	return reader.next, nil
+}
+
+func (p *pcapReader) next() (captureRecord, error) {
+	for len(p.records) == 0 {
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(p.r, header); err != nil {
+			return captureRecord{}, err
+		}
+
+		fraction := time.Duration(p.order.Uint32(header[4:]))
+		if !p.nanos {
+			fraction *= time.Microsecond
+		}
+		ts := time.Unix(int64(p.order.Uint32(header)), int64(fraction)).UTC()
+
+		length := p.order.Uint32(header[8:])
+		if length > p.snapLen {
+			return captureRecord{}, fmt.Errorf("invalid pcap file: the frame length %d exceeds the snapshot length %d", length, p.snapLen)
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(p.r, frame); err != nil {
+			return captureRecord{}, unexpectedEOF(err)
+		}
+
+		p.handleFrame(ts, frame)
+	}
+
+	record := p.records[0]
+	p.records = p.records[1:]
+	return record, nil
+}
+
+// handleFrame decodes the link layer. Frames which can not be decoded will be ignored.
+func (p *pcapReader) handleFrame(ts time.Time, frame []byte) {
+	switch p.linkType {
+	case linkTypeNull:
+		if len(frame) < 4 {
+			return
+		}
+		p.handleIp(ts, frame[4:])
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return
+		}
+		etherType, payload := binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		//skip vlan tags
+		for etherType == 0x8100 && len(payload) >= 4 {
+			etherType, payload = binary.BigEndian.Uint16(payload[2:]), payload[4:]
+		}
+		if etherType == 0x0800 || etherType == 0x86dd {
+			p.handleIp(ts, payload)
+		}
+	case linkTypeRaw:
+		p.handleIp(ts, frame)
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return
+		}
+		p.handleIp(ts, frame[16:])
+	}
+}
+
+func (p *pcapReader) handleIp(ts time.Time, packet []byte) {
+	if len(packet) < 1 {
+		return
+	}
+
+	var src, dst net.IP
+	var segment []byte
+
+	switch packet[0] >> 4 {
+	case 4:
+		headerLength := int(packet[0]&0x0f) * 4
+		if len(packet) < 20 || headerLength < 20 || packet[9] != 6 {
+			return
+		}
+		//cut the padding of the link layer
+		totalLength := int(binary.BigEndian.Uint16(packet[2:]))
+		if totalLength < headerLength || totalLength > len(packet) {
+			return
+		}
+		src, dst, segment = packet[12:16], packet[16:20], packet[headerLength:totalLength]
+	case 6:
+		//extension headers are not supported
+		if len(packet) < 40 || packet[6] != 6 {
+			return
+		}
+		payloadLength := int(binary.BigEndian.Uint16(packet[4:]))
+		if 40+payloadLength > len(packet) {
+			return
+		}
+		src, dst, segment = packet[8:24], packet[24:40], packet[40:40+payloadLength]
+	default:
+		return
+	}
+
+	p.handleTcp(ts, src, dst, segment)
+}
+
+func (p *pcapReader) handleTcp(ts time.Time, src, dst net.IP, segment []byte) {
+	if len(segment) < 20 {
+		return
+	}
+	srcPort, dstPort := binary.BigEndian.Uint16(segment), binary.BigEndian.Uint16(segment[2:])
+	seq := binary.BigEndian.Uint32(segment[4:])
+	dataOffset := int(segment[12]>>4) * 4
+	syn := segment[13]&0x02 != 0
+	if dataOffset < 20 || dataOffset > len(segment) {
+		return
+	}
+	data := segment[dataOffset:]
+
+	srcAddr := fmt.Sprintf("%s:%d", src, srcPort)
+	dstAddr := fmt.Sprintf("%s:%d", dst, dstPort)
+
+	stream, ok := p.streams[srcAddr+">"+dstAddr]
+	if !ok {
+		connectionKey := srcAddr + "|" + dstAddr
+		if srcAddr > dstAddr {
+			connectionKey = dstAddr + "|" + srcAddr
+		}
+		connection, ok := p.connections[connectionKey]
+		if !ok {
+			connection = &mqttConnection{}
+			p.connections[connectionKey] = connection
+		}
+
+		stream = &tcpStream{
+			connection: connection,
+			pending:    map[uint32][]byte{},
+			aliases:    map[uint16]string{},
+		}
+		p.streams[srcAddr+">"+dstAddr] = stream
+	}
+
+	if syn {
+		stream.started, stream.next = true, seq+1
+		stream.buffer, stream.pending, stream.pendingSize = nil, map[uint32][]byte{}, 0
+		return
+	}
+	if len(data) == 0 {
+		return
+	}
+	if !stream.started {
+		//the capture was started in the middle of the connection
+		stream.started, stream.next = true, seq
+	}
+
+	if !stream.connection.decided {
+		stream.connection.decided = true
+		stream.connection.isMqtt = srcPort == mqttPort || dstPort == mqttPort || isMqttConnect(data)
+	}
+	if !stream.connection.isMqtt {
+		return
+	}
+
+	stream.add(seq, data)
+	p.decodeMqtt(ts, stream)
+}
+
+// add appends the segment data in the order of their sequence numbers. If there are too many segments which are waiting
+// for their predecessors, the further ones will be dropped (the stream can not be decoded anymore then).
+func (s *tcpStream) add(seq uint32, data []byte) {
+	diff := int32(seq - s.next)
+	if diff > 0 {
+		if _, known := s.pending[seq]; !known && s.pendingSize+len(data) <= tcpMaxPending {
+			s.pending[seq] = data
+			s.pendingSize += len(data)
+		}
+		return
+	}
+	if int(-diff) >= len(data) {
+		//retransmission
+		return
+	}
+
+	s.buffer = append(s.buffer, data[-diff:]...)
+	s.next += uint32(len(data) + int(diff))
+
+	for {
+		data, ok := s.pending[s.next]
+		if !ok {
+			break
+		}
+		delete(s.pending, s.next)
+		s.pendingSize -= len(data)
+		s.buffer = append(s.buffer, data...)
+		s.next += uint32(len(data))
+	}
+}
+
+// isMqttConnect checks if the data starts with a mqtt CONNECT packet
+func isMqttConnect(data []byte) bool {
+	if len(data) < 2 || data[0] != mqttPacketConnect<<4 {
+		return false
+	}
+	_, n, ok := decodeVarInt(data[1:])
+	if !ok || len(data) < 1+n+2 {
+		return false
+	}
+	name, _, ok := decodeMqttString(data[1+n:])
+	return ok && (name == "MQTT" || name == "MQIsdp")
+}
+
+// decodeMqtt decodes all complete mqtt packets of the stream buffer
+func (p *pcapReader) decodeMqtt(ts time.Time, stream *tcpStream) {
+	for len(stream.buffer) >= 2 {
+		length, n, ok := decodeVarInt(stream.buffer[1:])
+		if !ok {
+			if n < 4 {
+				//the remaining length is incomplete
+				return
+			}
+			//the stream is corrupted (or no mqtt at all)
+			stream.connection.isMqtt = false
+			stream.buffer = nil
+			return
+		}
+		if len(stream.buffer) < 1+n+length {
+			return
+		}
+
+		fixedHeader, packet := stream.buffer[0], stream.buffer[1+n:1+n+length]
+		stream.buffer = stream.buffer[1+n+length:]
+
+		switch fixedHeader >> 4 {
+		case mqttPacketConnect:
+			if _, i, ok := decodeMqttString(packet); ok && i < len(packet) {
+				stream.connection.version = packet[i]
+			}
+		case mqttPacketPublish:
+			if record, ok := decodeMqttPublish(fixedHeader, packet, stream.connection.version, stream.aliases); ok {
+				record.Time = ts
+				p.records = append(p.records, record)
+			}
+		}
+	}
+	if len(stream.buffer) == 0 {
+		//release the memory of the consumed packets
+		stream.buffer = nil
+	}
+}
+
+func decodeMqttPublish(fixedHeader byte, packet []byte, version byte, aliases map[uint16]string) (captureRecord, bool) {
+	record := captureRecord{}
+	record.Qos = (fixedHeader >> 1) & 0x03
+	record.Retained = fixedHeader&0x01 != 0
+
+	topic, i, ok := decodeMqttString(packet)
+	if !ok {
+		return record, false
+	}
+	record.Topic = topic
+
+	if record.Qos > 0 {
+		//packet identifier
+		i += 2
+	}
+	if i > len(packet) {
+		return record, false
+	}
+	if version == 5 {
+		length, n, ok := decodeVarInt(packet[i:])
+		if !ok || i+n+length > len(packet) {
+			return record, false
+		}
+		alias, hasAlias := decodeTopicAlias(packet[i+n : i+n+length])
+		i += n + length
+
+		if hasAlias {
+			if record.Topic == "" {
+				record.Topic = aliases[alias]
+			} else {
+				aliases[alias] = record.Topic
+			}
+		}
+	}
+	if record.Topic == "" {
+		return record, false
+	}
+
+	record.PayloadBase64 = append([]byte{}, packet[i:]...)
+	return record, true
+}
+
+// decodeTopicAlias searches the topic alias inside the properties of a PUBLISH packet
+func decodeTopicAlias(properties []byte) (uint16, bool) {
+	for i := 0; i < len(properties); {
+		id := properties[i]
+		i++
+
+		size := 0
+		switch id {
+		case 0x01: //payload format indicator
+			size = 1
+		case 0x02: //message expiry interval
+			size = 4
+		case 0x23: //topic alias
+			if i+2 > len(properties) {
+				return 0, false
+			}
+			return binary.BigEndian.Uint16(properties[i:]), true
+		case 0x03, 0x08, 0x09: //content type, response topic, correlation data
+			if i+2 > len(properties) {
+				return 0, false
+			}
+			size = 2 + int(binary.BigEndian.Uint16(properties[i:]))
+		case 0x26: //user property (string pair)
+			if i+2 > len(properties) {
+				return 0, false
+			}
+			size = 2 + int(binary.BigEndian.Uint16(properties[i:]))
+			if i+size+2 > len(properties) {
+				return 0, false
+			}
+			size += 2 + int(binary.BigEndian.Uint16(properties[i+size:]))
+		case 0x0b: //subscription identifier
+			_, n, ok := decodeVarInt(properties[i:])
+			if !ok {
+				return 0, false
+			}
+			size = n
+		default:
+			return 0, false
+		}
+		i += size
+	}
+	return 0, false
+}
+
+// decodeVarInt decodes a mqtt variable byte integer. Returns the value and the count of read bytes.
+func decodeVarInt(data []byte) (int, int, bool) {
+	value, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		if i >= len(data) {
+			return 0, i, false
+		}
+		value += int(data[i]&0x7f) * multiplier
+		if data[i]&0x80 == 0 {
+			return value, i + 1, true
+		}
+		multiplier *= 128
+	}
+	return 0, 4, false
+}
+
+// decodeMqttString decodes a length prefixed string. Returns the string and the index behind it.
+func decodeMqttString(data []byte) (string, int, bool) {
+	if len(data) < 2 {
+		return "", 0, false
+	}
+	end := 2 + int(binary.BigEndian.Uint16(data))
+	if end > len(data) {
+		return "", 0, false
+	}
+	return string(data[2:end]), end, true
+}
@@ -0,0 +1,231 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type testPcapFrame struct {
+	time time.Time
+	data []byte
+}
+
+func testPcap(order binary.ByteOrder, nanos bool, linkType uint32, frames ...testPcapFrame) []byte {
+	buf := &bytes.Buffer{}
+
+	magic := uint32(pcapMagicMicros)
+	if nanos {
+		magic = pcapMagicNanos
+	}
+	binary.Write(buf, order, []uint32{magic, 0x00040002, 0, 0, 65535, linkType})
+
+	for _, frame := range frames {
+		fraction := frame.time.Nanosecond()
+		if !nanos {
+			fraction /= 1000
+		}
+		binary.Write(buf, order, []uint32{uint32(frame.time.Unix()), uint32(fraction), uint32(len(frame.data)), uint32(len(frame.data))})
+		buf.Write(frame.data)
+	}
+	return buf.Bytes()
+}
+
+func testEthernet(ipPacket []byte) []byte {
+	frame := make([]byte, 12, 14+len(ipPacket)+4)
+	frame = append(frame, 0x08, 0x00)
+	frame = append(frame, ipPacket...)
+
+	//ethernet padding
+	return append(frame, 0, 0, 0, 0)
+}
+
+func testIpv4Tcp(src, dst string, srcPort, dstPort uint16, seq uint32, syn bool, data []byte) []byte {
+	tcp := make([]byte, 20, 20+len(data))
+	binary.BigEndian.PutUint16(tcp, srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = 5 << 4
+	tcp[13] = 0x10 //ACK
+	if syn {
+		tcp[13] |= 0x02
+	}
+	tcp = append(tcp, data...)
+
+	ip := make([]byte, 20, 20+len(tcp))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+	ip[9] = 6
+	copy(ip[12:], net.ParseIP(src).To4())
+	copy(ip[16:], net.ParseIP(dst).To4())
+	return append(ip, tcp...)
+}
+
+func testMqttPacket(fixedHeader byte, content ...[]byte) []byte {
+	body := bytes.Join(content, nil)
+
+	packet := []byte{fixedHeader}
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+func testMqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+func readAllRecords(t *testing.T, content []byte) []captureRecord {
+	next, err := decodePcapCapture(bufio.NewReader(bytes.NewReader(content)))
+	assert.NoError(t, err)
+
+	var records []captureRecord
+	for {
+		record, err := next()
+		if err == io.EOF {
+			return records
+		}
+		assert.NoError(t, err)
+		records = append(records, record)
+	}
+}
+
+func TestDecodePcapCapture(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	connect := testMqttPacket(0x10, testMqttString("MQTT"), []byte{4, 0x02, 0, 60}, testMqttString("client"))
+	publish := testMqttPacket(0x33, testMqttString("a/topic"), []byte{0, 1}, []byte("PAYLOAD"))
+	delivery := testMqttPacket(0x30, testMqttString("b/topic"), []byte{0xff, 0x00})
+	second := testMqttPacket(0x30, testMqttString("c/topic"), []byte("2"))
+	third := testMqttPacket(0x30, testMqttString("d/topic"), []byte("3"))
+
+	client, broker := "10.0.0.2", "10.0.0.1"
+	seq := uint32(1000)
+	content := testPcap(binary.LittleEndian, false, linkTypeEthernet,
+		testPcapFrame{at(0), testEthernet(testIpv4Tcp(client, broker, 50000, 1883, seq, true, nil))},
+		testPcapFrame{at(1), testEthernet(testIpv4Tcp(client, broker, 50000, 1883, seq+1, false, connect))},
+		//the publish packet is split into two segments
+		testPcapFrame{at(2), testEthernet(testIpv4Tcp(client, broker, 50000, 1883, seq+1+uint32(len(connect)), false, publish[:5]))},
+		testPcapFrame{at(3), testEthernet(testIpv4Tcp(client, broker, 50000, 1883, seq+1+uint32(len(connect))+5, false, publish[5:]))},
+		//retransmission
+		testPcapFrame{at(4), testEthernet(testIpv4Tcp(client, broker, 50000, 1883, seq+1+uint32(len(connect))+5, false, publish[5:]))},
+		//the other direction (started in the middle of the stream)
+		testPcapFrame{at(5), testEthernet(testIpv4Tcp(broker, client, 1883, 50000, 7000, false, delivery))},
+		//out of order
+		testPcapFrame{at(6), testEthernet(testIpv4Tcp(broker, client, 1883, 50000, 7000+uint32(len(delivery)+len(second)), false, third))},
+		testPcapFrame{at(7), testEthernet(testIpv4Tcp(broker, client, 1883, 50000, 7000+uint32(len(delivery)), false, second))},
+		//no mqtt traffic
+		testPcapFrame{at(8), testEthernet(testIpv4Tcp(client, broker, 50001, 80, 1, false, delivery))},
+	)
+
+	records := readAllRecords(t, content)
+	assert.Len(t, records, 4)
+
+	assert.Equal(t, at(3), records[0].Time)
+	assert.Equal(t, "a/topic", records[0].Topic)
+	assert.Equal(t, byte(1), records[0].Qos)
+	assert.True(t, records[0].Retained)
+	assert.Equal(t, "PAYLOAD", string(records[0].payload()))
+
+	assert.Equal(t, at(5), records[1].Time)
+	assert.Equal(t, "b/topic", records[1].Topic)
+	assert.Equal(t, byte(0), records[1].Qos)
+	assert.False(t, records[1].Retained)
+	assert.Equal(t, []byte{0xff, 0x00}, records[1].payload())
+
+	assert.Equal(t, "c/topic", records[2].Topic)
+	assert.Equal(t, at(7), records[2].Time)
+	assert.Equal(t, "d/topic", records[3].Topic)
+	assert.Equal(t, at(7), records[3].Time)
+}
+
+func TestDecodePcapCapture_mqtt5(t *testing.T) {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.UTC)
+
+	connect := testMqttPacket(0x10, testMqttString("MQTT"), []byte{5, 0x02, 0, 60, 0}, testMqttString("client"))
+	properties := bytes.Join([][]byte{
+		{0x01, 1},
+		{0x26}, testMqttString("key"), testMqttString("value"),
+		{0x23, 0, 7},
+	}, nil)
+	withAlias := testMqttPacket(0x32, testMqttString("a/topic"), []byte{0, 1, byte(len(properties))}, properties, []byte("1"))
+	byAlias := testMqttPacket(0x30, testMqttString(""), []byte{3, 0x23, 0, 7}, []byte("2"))
+	unknownAlias := testMqttPacket(0x30, testMqttString(""), []byte{3, 0x23, 0, 8}, []byte("3"))
+
+	data := bytes.Join([][]byte{connect, withAlias, byAlias, unknownAlias}, nil)
+	content := testPcap(binary.BigEndian, true, linkTypeRaw,
+		//the mqtt connection is detected by the CONNECT packet (not by the port)
+		testPcapFrame{start, testIpv4Tcp("10.0.0.2", "10.0.0.1", 50000, 8080, 1, false, data)},
+	)
+
+	records := readAllRecords(t, content)
+	assert.Len(t, records, 2)
+
+	assert.Equal(t, start, records[0].Time)
+	assert.Equal(t, "a/topic", records[0].Topic)
+	assert.Equal(t, "1", string(records[0].payload()))
+	assert.Equal(t, "a/topic", records[1].Topic)
+	assert.Equal(t, "2", string(records[1].payload()))
+}
+
+func TestDecodePcapCapture_invalid(t *testing.T) {
+	_, err := decodePcapCapture(bufio.NewReader(bytes.NewReader([]byte{0x0a, 0x0d, 0x0d, 0x0a})))
+	assert.EqualError(t, err, "invalid pcap file")
+
+	pcapng := append([]byte{0x0a, 0x0d, 0x0d, 0x0a}, make([]byte, 20)...)
+	_, err = decodePcapCapture(bufio.NewReader(bytes.NewReader(pcapng)))
+	assert.EqualError(t, err, "pcapng files are not supported (convert it with: editcap -F pcap <in> <out>)")
+
+	_, err = decodePcapCapture(bufio.NewReader(bytes.NewReader(testPcap(binary.LittleEndian, false, 105))))
+	assert.EqualError(t, err, "unsupported pcap link type 105")
+
+	content := testPcap(binary.LittleEndian, false, linkTypeRaw, testPcapFrame{time.Now(), []byte{0x45, 0x00}})
+	next, err := decodePcapCapture(bufio.NewReader(bytes.NewReader(content[:len(content)-1])))
+	assert.NoError(t, err)
+	_, err = next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	//the frame length is greater than the snapshot length (65535)
+	content = testPcap(binary.LittleEndian, false, linkTypeRaw)
+	content = append(content, make([]byte, 8)...)
+	content = append(content, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	next, err = decodePcapCapture(bufio.NewReader(bytes.NewReader(content)))
+	assert.NoError(t, err)
+	_, err = next()
+	assert.EqualError(t, err, "invalid pcap file: the frame length 4294967295 exceeds the snapshot length 65535")
+}
+
+func TestTcpStream_add_pendingLimit(t *testing.T) {
+	toTest := &tcpStream{next: 100, pending: map[uint32][]byte{}}
+
+	segment := make([]byte, tcpMaxPending/2)
+	toTest.add(200, segment)
+	toTest.add(200, segment) //retransmission of a pending segment
+	toTest.add(200+tcpMaxPending/2, segment)
+	toTest.add(200+tcpMaxPending, segment)
+
+	assert.Len(t, toTest.pending, 2, "the further segments should be dropped")
+	assert.Equal(t, tcpMaxPending, toTest.pendingSize)
+
+	toTest.add(100, make([]byte, 100))
+	assert.Len(t, toTest.pending, 0)
+	assert.Equal(t, 0, toTest.pendingSize)
+	assert.Equal(t, uint32(200+tcpMaxPending), toTest.next)
+}
@@ -0,0 +1,216 @@
+package io
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPingCount    = 5
+	defaultPingInterval = 100 * time.Millisecond
+	defaultPingTimeout  = 1 * time.Second
+	pingTopicPrefix     = "mqtt-shell/ping/"
+)
+
+type pingOptions struct {
+	topic    string
+	count    int
+	qos      []byte
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func (p *processor) handlePing(chain Chain) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: "+commandPing+" [-n count] [-q 0|1|2] [-i interval] [-t timeout] [topic]", err.Error())
+		}
+	}()
+
+	opts := pingOptions{
+		count:    defaultPingCount,
+		qos:      []byte{0, 1, 2},
+		interval: defaultPingInterval,
+		timeout:  defaultPingTimeout,
+	}
+
+	for i := 0; i < len(chain.Commands[0].Arguments); i++ {
+		arg := chain.Commands[0].Arguments[i]
+
+		switch arg {
+		case "-n", "-q", "-i", "-t":
+			if i+1 >= len(chain.Commands[0].Arguments) {
+				return errors.New("invalid arguments")
+			}
+			value := chain.Commands[0].Arguments[i+1]
+			i++
+
+			switch arg {
+			case "-n":
+				opts.count, err = strconv.Atoi(value)
+				if err != nil || opts.count <= 0 {
+					return errors.New("invalid count")
+				}
+			case "-q":
+				qos, err := strconv.Atoi(value)
+				if err != nil || qos < 0 || qos > 2 {
+					return errors.New("invalid qos level")
+				}
+				opts.qos = []byte{byte(qos)}
+			case "-i":
+				opts.interval, err = time.ParseDuration(value)
+				if err != nil || opts.interval < 0 {
+					return errors.New("invalid interval")
+				}
+			case "-t":
+				opts.timeout, err = time.ParseDuration(value)
+				if err != nil || opts.timeout <= 0 {
+					return errors.New("invalid timeout")
+				}
+			}
+		default:
+			if opts.topic != "" {
+				return errors.New("invalid arguments")
+			}
+			opts.topic = arg
+		}
+	}
+
+	session, err := newPingSession()
+	if err != nil {
+		return err
+	}
+	if opts.topic == "" {
+		opts.topic = pingTopicPrefix + session
+	}
+	if strings.ContainsAny(opts.topic, "#+") {
+		return errors.New("the topic must not contain wildcards")
+	}
+
+	p.mutex.RLock()
+	_, subscribed := p.subscribedTopics[opts.topic]
+	p.mutex.RUnlock()
+	if subscribed {
+		//a subscription of the same topic would replace the existing one
+		return errors.New("the topic is already subscribed")
+	}
+	if !p.guard("send ping probes to "+opts.topic, false, opts.topic) {
+		return nil
+	}
+
+	for _, qos := range opts.qos {
+		if err := p.ping(session, qos, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newPingSession() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("unable to generate ping session: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// ping sends the probes with the given qos level and waits for receiving them
+func (p *processor) ping(session string, qos byte, opts pingOptions) error {
+	mutex := sync.Mutex{}
+	sent := map[int]time.Time{}
+	received := make(chan time.Duration, opts.count)
+	prefix := session + "/" + strconv.Itoa(int(qos)) + "/"
+
+	handler := func(_ mqtt.Client, message mqtt.Message) {
+		now := time.Now()
+
+		//ignore all messages which are not our probes (for example of other ping sessions)
+		payload := string(message.Payload())
+		if !strings.HasPrefix(payload, prefix) {
+			return
+		}
+		seq, err := strconv.Atoi(strings.TrimPrefix(payload, prefix))
+		if err != nil {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if start, ok := sent[seq]; ok {
+			//duplicates will be ignored
+			delete(sent, seq)
+			received <- now.Sub(start)
+		}
+	}
+
+	if token := p.client.Subscribe(opts.topic, qos, handler); !token.Wait() {
+		return token.Error()
+	}
+	defer func() {
+		p.client.Unsubscribe(opts.topic).Wait()
+	}()
+
+	var latencies []time.Duration
+	for seq := 1; seq <= opts.count; seq++ {
+		if seq > 1 {
+			time.Sleep(opts.interval)
+		}
+
+		mutex.Lock()
+		sent[seq] = time.Now()
+		mutex.Unlock()
+
+		if token := p.client.Publish(opts.topic, qos, false, prefix+strconv.Itoa(seq)); !token.Wait() {
+			return token.Error()
+		}
+
+		select {
+		case latency := <-received:
+			latencies = append(latencies, latency)
+			p.out.Write([]byte(fmt.Sprintf("probe %d (qos %d) from %s: time=%s\n", seq, qos, opts.topic, latency.Round(time.Microsecond))))
+		case <-time.After(opts.timeout):
+			mutex.Lock()
+			delete(sent, seq)
+			mutex.Unlock()
+
+			p.out.Write([]byte(fmt.Sprintf("probe %d (qos %d) from %s: timeout\n", seq, qos, opts.topic)))
+		}
+	}
+
+	p.out.Write([]byte(pingSummary(qos, opts.count, latencies) + "\n"))
+	return nil
+}
+
+func pingSummary(qos byte, count int, latencies []time.Duration) string {
+	loss := float64(count-len(latencies)) / float64(count) * 100
+	summary := fmt.Sprintf("--- qos %d: %d probes sent, %d received, %.1f%% loss", qos, count, len(latencies), loss)
+	if len(latencies) == 0 {
+		return summary
+	}
+
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	var sum time.Duration
+	for _, latency := range sorted {
+		sum += latency
+	}
+	avg := sum / time.Duration(len(sorted))
+	p99 := sorted[int(math.Ceil(0.99*float64(len(sorted))))-1]
+
+	return fmt.Sprintf("%s, min/avg/p99 = %s/%s/%s", summary,
+		sorted[0].Round(time.Microsecond), avg.Round(time.Microsecond), p99.Round(time.Microsecond))
+}
@@ -0,0 +1,127 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPingSummary(t *testing.T) {
+	assert.Equal(t, "--- qos 1: 2 probes sent, 0 received, 100.0% loss", pingSummary(1, 2, nil))
+	assert.Equal(t, "--- qos 0: 4 probes sent, 3 received, 25.0% loss, min/avg/p99 = 1ms/2ms/3ms",
+		pingSummary(0, 4, []time.Duration{3 * time.Millisecond, 1 * time.Millisecond, 2 * time.Millisecond}))
+}
+
+func TestProcessor_Process_ping(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).AnyTimes()
+	mockMqtt := mock_io.NewMockClient(ctrl)
+
+	var handler mqtt.MessageHandler
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("ping/topic"), gomock.Eq(byte(1)), gomock.Any()).DoAndReturn(
+		func(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+			handler = callback
+			return mockToken
+		})
+	mockMqtt.EXPECT().Unsubscribe(gomock.Eq("ping/topic")).Return(mockToken)
+
+	published := 0
+	mockMqtt.EXPECT().Publish(gomock.Eq("ping/topic"), gomock.Eq(byte(1)), gomock.Eq(false), gomock.Any()).DoAndReturn(
+		func(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+			published++
+			if published == 2 {
+				//the second probe gets lost
+				return mockToken
+			}
+
+			message := mock_io.NewMockMessage(ctrl)
+			message.EXPECT().Payload().Return([]byte(payload.(string))).AnyTimes()
+			go func() {
+				//foreign messages should be ignored
+				foreign := mock_io.NewMockMessage(ctrl)
+				foreign.EXPECT().Payload().Return([]byte("foreign")).AnyTimes()
+				handler(nil, foreign)
+
+				handler(nil, message)
+				handler(nil, message) //duplicates should be ignored
+			}()
+			return mockToken
+		}).Times(3)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	toTest.Process(filledChan(commandPing + " -n 3 -q 1 -i 0 -t 50ms ping/topic"))
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	assert.Len(t, lines, 4)
+	assert.Regexp(t, `^probe 1 \(qos 1\) from ping/topic: time=[0-9.]+[µm]?s$`, lines[0])
+	assert.Equal(t, `probe 2 (qos 1) from ping/topic: timeout`, lines[1])
+	assert.Regexp(t, `^probe 3 \(qos 1\) from ping/topic: time=[0-9.]+[µm]?s$`, lines[2])
+	assert.Regexp(t, `^--- qos 1: 3 probes sent, 2 received, 33.3% loss, min/avg/p99 = .+/.+/.+$`, lines[3])
+}
+
+func TestProcessor_Process_ping_allQosLevels(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).AnyTimes()
+	mockMqtt := mock_io.NewMockClient(ctrl)
+
+	var topics []string
+	for _, qos := range []byte{0, 1, 2} {
+		mockMqtt.EXPECT().Subscribe(gomock.Any(), gomock.Eq(qos), gomock.Any()).DoAndReturn(
+			func(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+				topics = append(topics, topic)
+				return mockToken
+			})
+		mockMqtt.EXPECT().Publish(gomock.Any(), gomock.Eq(qos), gomock.Eq(false), gomock.Any()).Return(mockToken)
+	}
+	mockMqtt.EXPECT().Unsubscribe(gomock.Any()).Return(mockToken).Times(3)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	toTest.Process(filledChan(commandPing + " -n 1 -t 10ms"))
+
+	assert.Len(t, topics, 3)
+	assert.True(t, strings.HasPrefix(topics[0], pingTopicPrefix), "a random topic should be used by default")
+	assert.Equal(t, 3, strings.Count(output.String(), "100.0% loss"))
+}
+
+func TestProcessor_Process_ping_invalidArguments(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{commandPing + " -n", "invalid arguments"},
+		{commandPing + " -n 0", "invalid count"},
+		{commandPing + " -q 3", "invalid qos level"},
+		{commandPing + " -i abc", "invalid interval"},
+		{commandPing + " -t 0s", "invalid timeout"},
+		{commandPing + " a b", "invalid arguments"},
+		{commandPing + " a/#", "the topic must not contain wildcards"},
+		{commandPing + " a/topic", "the topic is already subscribed"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestProcessor_Process_ping_invalidArguments_%d", i), func(t *testing.T) {
+			output := &bytes.Buffer{}
+			toTest := NewProcessor(output, nil)
+			toTest.subscribedTopics["a/topic"] = subscription{}
+
+			toTest.Process(filledChan(test.line))
+
+			assert.Equal(t, test.expected+"\nUsage: "+commandPing+" [-n count] [-q 0|1|2] [-i interval] [-t timeout] [topic]\n", output.String())
+		})
+	}
+}
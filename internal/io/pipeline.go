@@ -0,0 +1,80 @@
+package io
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+type runnable interface {
+	Run() error
+	String() string
+}
+
+// segment is one part of a pipeline. This can be a chain of external applications
+// or a builtin stage which will be executed in-process.
+type segment struct {
+	run         func() error
+	description string
+
+	//the pipe ends which must be closed after the segment is done
+	in  *io.PipeReader
+	out *io.PipeWriter
+}
+
+// pipeline connects multiple segments with each other
+type pipeline struct {
+	segments []segment
+}
+
+func (p *pipeline) Run() error {
+	errs := make([]error, len(p.segments))
+	wg := sync.WaitGroup{}
+
+	for i := range p.segments {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			seg := p.segments[i]
+			errs[i] = seg.run()
+
+			if seg.in != nil {
+				//if this segment ends earlier than the previous one, the previous segment
+				//would wait for eternity while writing into the pipe
+				seg.in.CloseWithError(io.ErrClosedPipe)
+			}
+			if seg.out != nil {
+				//signal the next segment that there is no more input
+				seg.out.CloseWithError(errs[i])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	//if a segment fails, the other segments will fail too (because of closed pipes)
+	//the last error is the most meaningful one: errors of previous segments will be
+	//passed through the pipes
+	for i := len(errs) - 1; i >= 0; i-- {
+		if errs[i] != nil && !closedByNext(errs[i], i, len(errs)) {
+			return errs[i]
+		}
+	}
+	return nil
+}
+
+// closedByNext checks if the error of the segment is caused by the next segment which has stopped reading earlier
+// (such like "head -1"). This is a normal end of the segment: like SIGPIPE for processes.
+func closedByNext(err error, i, count int) bool {
+	return i < count-1 && errors.Is(err, io.ErrClosedPipe)
+}
+
+func (p *pipeline) String() string {
+	descriptions := make([]string, len(p.segments))
+	for i, seg := range p.segments {
+		descriptions[i] = seg.description
+	}
+	return strings.Join(descriptions, "\n")
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package io
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// prepareProcessTree lets the process start in its own process group
+func prepareProcessTree(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcess kills the process and all of its children (the whole process group)
+func killProcess(cmd *exec.Cmd) {
+	//the process could be already finished: that error can be ignored
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package io
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+func prepareProcessTree(_ *exec.Cmd) {
+	//nothing to do: the process tree will be killed by taskkill
+}
+
+// killProcess kills the process and all of its children
+func killProcess(cmd *exec.Cmd) {
+	//the process could be already finished: that error can be ignored
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,142 @@
+package io
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// processList collects the processes of a running chain
+type processList struct {
+	mutex  sync.Mutex
+	groups []*processGroup
+	killed bool
+}
+
+// processGroup contains the processes of one segment of a chain. The processes are
+// started one after another by the underlying command chain.
+type processGroup struct {
+	list     *processList
+	commands []*exec.Cmd
+	started  bool
+
+	//true if all processes of the group are waited for: they must not be touched anymore
+	finished bool
+}
+
+func (p *processList) newGroup() *processGroup {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	group := &processGroup{list: p}
+	p.groups = append(p.groups, group)
+	return group
+}
+
+// pids returns the process ids of all started processes
+func (p *processList) pids() []int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pids := make([]int, 0, len(p.groups))
+	for _, group := range p.groups {
+		if !group.started || group.finished {
+			continue
+		}
+		for _, cmd := range group.commands {
+			pids = append(pids, cmd.Process.Pid)
+		}
+	}
+	return pids
+}
+
+// kill kills all running processes. Processes which are not started yet will be killed after their start. The
+// finished processes will not be killed: their process ids could be reused already.
+func (p *processList) kill() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.killed = true
+	for _, group := range p.groups {
+		if !group.started || group.finished {
+			continue
+		}
+		for _, cmd := range group.commands {
+			killProcess(cmd)
+		}
+	}
+}
+
+// exitError returns an error for the first process which has not exited successfully.
+// It must be called only after the chain is finished.
+func (p *processList) exitError() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, group := range p.groups {
+		if !group.finished {
+			continue
+		}
+		for _, cmd := range group.commands {
+			if cmd.ProcessState != nil && !cmd.ProcessState.Success() {
+				return fmt.Errorf("%s: %s", filepath.Base(cmd.Path), cmd.ProcessState.String())
+			}
+		}
+	}
+	return nil
+}
+
+func (g *processGroup) add(_ int, cmd *exec.Cmd) {
+	g.list.mutex.Lock()
+	defer g.list.mutex.Unlock()
+
+	//so that the whole process tree can be killed
+	prepareProcessTree(cmd)
+	g.commands = append(g.commands, cmd)
+}
+
+func (g *processGroup) markStarted() {
+	g.list.mutex.Lock()
+	defer g.list.mutex.Unlock()
+
+	g.started = true
+	if g.list.killed {
+		for _, cmd := range g.commands {
+			killProcess(cmd)
+		}
+	}
+}
+
+// markFinished marks all processes of the group as finished. It must be called after all processes are waited for.
+func (g *processGroup) markFinished() {
+	g.list.mutex.Lock()
+	defer g.list.mutex.Unlock()
+
+	g.finished = true
+}
+
+// wrap the output of the last command of the group. The copy routine of the last command
+// will be started after all processes are started. So the first use of the output means
+// that all processes of the group are running.
+func (g *processGroup) wrap(out io.Writer) io.Writer {
+	return &startSignalWriter{Writer: out, signal: g.markStarted}
+}
+
+type startSignalWriter struct {
+	io.Writer
+	once   sync.Once
+	signal func()
+}
+
+func (s *startSignalWriter) Write(b []byte) (int, error) {
+	s.once.Do(s.signal)
+	return s.Writer.Write(b)
+}
+
+// ReadFrom will be called directly after the copy routine is started
+func (s *startSignalWriter) ReadFrom(r io.Reader) (int64, error) {
+	s.once.Do(s.signal)
+	return io.Copy(struct{ io.Writer }{s.Writer}, r)
+}
@@ -9,6 +9,8 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type subscription struct {
@@ -16,25 +18,102 @@ type subscription struct {
 	callback mqtt.MessageHandler
 }
 
-type commandHandle struct {
-	w         io.Closer
-	closeChan chan interface{}
-}
-
 type processor struct {
 	client mqtt.Client
 	out    io.Writer
 
-	longTermCommands map[string]commandHandle
+	// ClientFactory creates (and connects) a new client for the given broker
+	ClientFactory func(broker string) (mqtt.Client, error)
+
+	// Execution controls the execution of short term chains. It must be set before processing.
+	Execution ExecutionOptions
+
+	// Status is the status of the mqtt connection (can be nil)
+	Status *ConnectionStatus
+
+	// OnChange will be called after each processed command and each finished job (can be nil)
+	OnChange func()
+
+	// Guard protects the topics against accidental publishes (can be nil)
+	Guard *PublishGuard
+
+	// ForwardQos is the qos level of forwardings without an explicit qos level
+	ForwardQos byte
+
+	// Ask asks the user the given question and returns the answer (can be nil if nobody can be asked)
+	Ask func(question string) (string, bool)
+
+	//the following state will be mutated only by the processing routine (while holding the write lock). So the
+	//processing routine itself can read it without any lock. All other routines (such like the mqtt reconnect handler)
+	//must hold the read lock. The message handlers must not use this lock at all: they would block the mqtt client
+	//while the processing routine is waiting for a subscription (use own locks instead).
+	mutex            sync.RWMutex
+	executor         *executor
+	longTermCommands map[string]*commandHandle
+	lastJobId        int
+	//the jobs which are not subscribed anymore (killed, unsubscribed or replaced): they are listed until they are cleared
+	retiredJobs      []*commandHandle
 	subscribedTopics map[string]subscription
+	forwards         map[string]*forwarding
+	executions       map[string]*execution
+
+	//the source filters of the forwardings (has its own lock: it will be read by the message handlers)
+	forwardSources *forwardSources
+
+	//the temporary handlers of the existing subscriptions
+	taps *subscriptionTaps
+
+	//all topics which were seen by any subscription
+	topics *topicRegistry
+
+	//the message statistics of all subscriptions (the refresh will be used only by the processing routine)
+	stats        *statistics
+	statsRefresh chan interface{}
+
+	//records the received messages into a capture file
+	recorder *recorder
+
+	//the last (or current) replay of a capture file (will be used only by the processing routine)
+	replay *replaying
+}
+
+// execution contains the execution state of a short term chain subscription
+type execution struct {
+	lane  *lane
+	stats *executionStats
+
+	//true if the lane is used only by this subscription
+	ownLane bool
+
+	//will be set if the subscription is gone: its queued tasks must not be executed anymore (important for the
+	//shared lane which will continue working for the other subscriptions)
+	stopped int32
+}
+
+func (e *execution) stop() {
+	atomic.StoreInt32(&e.stopped, 1)
+	if e.ownLane {
+		e.lane.stop()
+	}
+}
+
+func (e *execution) isStopped() bool {
+	return atomic.LoadInt32(&e.stopped) == 1
 }
 
 func NewProcessor(out io.Writer, client mqtt.Client) *processor {
 	return &processor{
 		client:           client,
-		out:              out,
-		longTermCommands: map[string]commandHandle{},
+		out:              &syncWriter{delegate: out},
+		longTermCommands: map[string]*commandHandle{},
 		subscribedTopics: map[string]subscription{},
+		forwards:         map[string]*forwarding{},
+		forwardSources:   newForwardSources(),
+		taps:             newSubscriptionTaps(),
+		executions:       map[string]*execution{},
+		topics:           newTopicRegistry(),
+		stats:            newStatistics(),
+		recorder:         &recorder{},
 	}
 }
 
@@ -49,15 +128,33 @@ func (p *processor) Process(input chan string) {
 		if err != nil {
 			p.out.Write([]byte(err.Error() + "\n"))
 		}
+		p.changed()
 	}
 
 	//close all long term chain inputs (will cause the normally exiting of underlying commands)
 	for _, input := range p.longTermCommands {
 		input.w.Close()
 	}
+	for _, fwd := range p.forwards {
+		fwd.close()
+	}
+	for _, exec := range p.executions {
+		exec.stop()
+	}
+	if p.executor != nil {
+		p.executor.close()
+	}
+	p.stopStatsRefresh()
+	p.recorder.stop()
+	if p.replay != nil {
+		p.replay.stop()
+	}
 }
 
 func (p *processor) GetSubscriptions() []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
 	topics := make([]string, 0, len(p.subscribedTopics))
 	for topic := range p.subscribedTopics {
 		topics = append(topics, topic)
@@ -67,16 +164,60 @@ func (p *processor) GetSubscriptions() []string {
 	return topics
 }
 
+// RunningJobs returns the count of the jobs which are running
+func (p *processor) RunningJobs() int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	count := 0
+	for _, job := range p.longTermCommands {
+		if job.isRunning() {
+			count++
+		}
+	}
+	for _, job := range p.retiredJobs {
+		if job.isRunning() {
+			count++
+		}
+	}
+	return count
+}
+
+func (p *processor) changed() {
+	if p.OnChange != nil {
+		p.OnChange()
+	}
+}
+
 func (p *processor) HasSubscriptions() bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
 	return len(p.subscribedTopics) > 0
 }
 
 func (p *processor) OnMqttReconnect() {
+	p.mutex.RLock()
+	subscriptions := make(map[string]subscription, len(p.subscribedTopics))
 	for topic, subscription := range p.subscribedTopics {
+		subscriptions[topic] = subscription
+	}
+	p.mutex.RUnlock()
+
+	//do not hold the lock while subscribing: the processing routine could wait for the client
+	for topic, subscription := range subscriptions {
 		p.client.Subscribe(topic, subscription.qos, subscription.callback)
 	}
 }
 
+// setSubscription registers the subscription so that it will be restored after a reconnect
+func (p *processor) setSubscription(topic string, sub subscription) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.subscribedTopics[topic] = sub
+}
+
 func (p *processor) handleCommand(chain Chain) error {
 	if len(chain.Commands) == 0 {
 		return nil
@@ -95,6 +236,30 @@ func (p *processor) handleCommand(chain Chain) error {
 		return p.handleUnsub(chain)
 	case commandList:
 		return p.handleList(chain)
+	case commandFwd:
+		return p.handleFwd(chain)
+	case commandJobs:
+		return p.handleJobs(chain)
+	case commandKill:
+		return p.handleKill(chain)
+	case commandTree:
+		return p.handleTree(chain)
+	case commandRetained:
+		return p.handleRetained(chain)
+	case commandLast:
+		return p.handleLast(chain)
+	case commandDiff:
+		return p.handleDiff(chain)
+	case commandStats:
+		return p.handleStats(chain)
+	case commandStatus:
+		return p.handleStatus(chain)
+	case commandPing:
+		return p.handlePing(chain)
+	case commandRecord:
+		return p.handleRecord(chain)
+	case commandReplay:
+		return p.handleReplay(chain)
 	default:
 		return errors.New("unknown command")
 	}
@@ -163,6 +328,14 @@ func (p *processor) handlePub(chain Chain) (err error) {
 		return errors.New("invalid arguments")
 	}
 
+	action := "publish to " + topic
+	if retained {
+		action = "publish a retained message to " + topic
+	}
+	if !p.guard(action, retained, topic) {
+		return nil
+	}
+
 	if token := p.client.Publish(topic, byte(qos), retained, payload); !token.Wait() {
 		return token.Error()
 	}
@@ -170,37 +343,84 @@ func (p *processor) handlePub(chain Chain) (err error) {
 }
 
 func (p *processor) handleList(chain Chain) error {
+	long := false
+	for _, arg := range chain.Commands[0].Arguments {
+		if arg != "-l" {
+			return errors.New("invalid arguments\nUsage: " + commandList + " [-l]")
+		}
+		long = true
+	}
+
 	for _, topic := range p.GetSubscriptions() {
-		p.out.Write([]byte(topic + "\n"))
+		if exec, ok := p.executions[topic]; ok && long {
+			p.out.Write([]byte(topic + " (" + exec.stats.String() + ")\n"))
+		} else {
+			p.out.Write([]byte(topic + "\n"))
+		}
 	}
 	return nil
 }
 
 func (p *processor) handleUnsub(chain Chain) error {
 	for _, topic := range chain.Commands[0].Arguments {
-		if ltWriter, ok := p.longTermCommands[topic]; ok {
-			//close the command-input-stream (will end the underlying cmdchain)
-			ltWriter.w.Close()
+		if err := p.unsubscribe(topic); err != nil {
+			return err
 		}
+	}
 
-		if token := p.client.Unsubscribe(topic); !token.Wait() {
-			return token.Error()
-		}
-		delete(p.subscribedTopics, topic)
+	return nil
+}
+
+func (p *processor) unsubscribe(topic string) error {
+	if ltWriter, ok := p.longTermCommands[topic]; ok {
+		//close the command-input-stream (will end the underlying cmdchain)
+		ltWriter.w.Close()
+
+		p.mutex.Lock()
+		delete(p.longTermCommands, topic)
+		p.retiredJobs = append(p.retiredJobs, ltWriter)
+		p.mutex.Unlock()
+	}
+
+	if token := p.client.Unsubscribe(topic); !token.Wait() {
+		return token.Error()
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.subscribedTopics, topic)
+	p.recorder.release(topic)
+	p.stats.remove(topic)
+	if fwd, ok := p.forwards[topic]; ok {
+		fwd.close()
+		delete(p.forwards, topic)
+		p.forwardSources.remove(topic)
+	}
+	if exec, ok := p.executions[topic]; ok {
+		exec.stop()
+		delete(p.executions, topic)
 	}
 
 	return nil
 }
 
+// subOptions are the options of a subscription
+type subOptions struct {
+	qos     byte
+	restart restartPolicy
+	framing framing
+}
+
 func (p *processor) handleSub(chain Chain) (err error) {
 	defer func() {
 		if err != nil {
-			err = fmt.Errorf("%s\nUsage: "+commandSub+" [-q 0|1|2] <topic> [...topicN]", err.Error())
+			err = fmt.Errorf("%s\nUsage: "+commandSub+" [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]", err.Error())
 		}
 	}()
 
 	topics := make([]string, 0, 1)
-	qos := 0
+	opts := subOptions{}
 
 	for i := 0; i < len(chain.Commands[0].Arguments); i++ {
 		arg := chain.Commands[0].Arguments[i]
@@ -208,14 +428,36 @@ func (p *processor) handleSub(chain Chain) (err error) {
 		switch arg {
 		case "-q":
 			if i+1 < len(chain.Commands[0].Arguments) {
-				var err error
-				qos, err = strconv.Atoi(chain.Commands[0].Arguments[i+1])
+				qos, err := strconv.Atoi(chain.Commands[0].Arguments[i+1])
 				if err != nil {
 					return fmt.Errorf("invalid qos level: %w", err)
 				}
 				if qos < 0 || qos > 3 {
 					return errors.New("invalid qos level")
 				}
+				opts.qos = byte(qos)
+				i++
+			} else {
+				return errors.New("invalid arguments")
+			}
+		case "-restart":
+			if i+1 < len(chain.Commands[0].Arguments) {
+				policy, ok := restartPolicies[chain.Commands[0].Arguments[i+1]]
+				if !ok {
+					return errors.New("invalid restart policy")
+				}
+				opts.restart = policy
+				i++
+			} else {
+				return errors.New("invalid arguments")
+			}
+		case "-f":
+			if i+1 < len(chain.Commands[0].Arguments) {
+				f, ok := framings[chain.Commands[0].Arguments[i+1]]
+				if !ok {
+					return errors.New("invalid framing")
+				}
+				opts.framing = f
 				i++
 			} else {
 				return errors.New("invalid arguments")
@@ -230,21 +472,49 @@ func (p *processor) handleSub(chain Chain) (err error) {
 	}
 
 	for _, topic := range topics {
-		clb, err := genSubHandler(p, topic, chain)
+		if _, ok := p.forwards[topic]; ok {
+			return fmt.Errorf("%s is already forwarded", topic)
+		}
+	}
+
+	for _, topic := range topics {
+		clb, err := genSubHandler(p, topic, chain, opts)
 		if err != nil {
 			return err
 		}
+		clb = p.observe(topic, clb)
 
-		if token := p.client.Subscribe(topic, byte(qos), clb); !token.Wait() {
+		if token := p.client.Subscribe(topic, opts.qos, clb); !token.Wait() {
 			return token.Error()
 		}
-		p.subscribedTopics[topic] = subscription{qos: byte(qos), callback: clb}
+		p.setSubscription(topic, subscription{qos: opts.qos, callback: clb})
+		//the subscription of the recorder (if any) is replaced now
+		p.recorder.release(topic)
 	}
 
 	return nil
 }
 
-var genSubHandler = func(p *processor, topic string, chain Chain) (func(mqtt.Client, mqtt.Message), error) {
+// observe wraps the handler of the subscription so that all received messages will be observed by the processor
+func (p *processor) observe(subscription string, handler mqtt.MessageHandler) mqtt.MessageHandler {
+	return func(client mqtt.Client, message mqtt.Message) {
+		p.topics.add(message)
+		p.stats.add(subscription, message)
+		p.recorder.record(message)
+		p.taps.dispatch(subscription, client, message)
+		handler(client, message)
+	}
+}
+
+var genSubHandler = func(p *processor, topic string, chain Chain, opts subOptions) (func(mqtt.Client, mqtt.Message), error) {
+	isBackground := len(chain.Commands) > 1 && chain.IsLongTerm() && !chain.HasShellOutput()
+	if opts.restart != restartNever && !isBackground {
+		return nil, errors.New("a restart policy is only available for long term chains")
+	}
+	if opts.framing != nil && !isBackground {
+		return nil, errors.New("a framing is only available for long term chains")
+	}
+
 	if len(chain.Commands) == 1 {
 		//the decorator will be saved because of inline func
 		//so each message for the current sub have the same decorator
@@ -256,57 +526,86 @@ var genSubHandler = func(p *processor, topic string, chain Chain) (func(mqtt.Cli
 	}
 
 	//long term chains with shell output work not very well together - so ignore this combination
-	if chain.IsLongTerm() && chain.IsAppending() {
-		return p.longTermSub(topic, chain)
+	if isBackground {
+		return p.longTermSub(topic, chain, opts)
 	}
 
 	//each new input will cause executing a new chain (short term)
-	return p.shortTermSub(chain), nil
+	return p.shortTermSub(topic, chain), nil
 }
 
-func (p *processor) longTermSub(topic string, chain Chain) (func(mqtt.Client, mqtt.Message), error) {
+func (p *processor) longTermSub(topic string, chain Chain, opts subOptions) (func(mqtt.Client, mqtt.Message), error) {
 	//long term commands are commands which are running permanently in background
 	//each new message will be written in ONE input pipe to that command
-	r, w := io.Pipe()
-
 	if prevWriter, ok := p.longTermCommands[topic]; ok {
 		//close the previous command-input-stream
 		prevWriter.w.Close()
 	}
-	p.longTermCommands[topic] = commandHandle{
-		w:         w,
+
+	job := &commandHandle{
+		id:        p.lastJobId + 1,
+		topic:     topic,
+		line:      chain.String(),
+		started:   timeNow(),
+		restart:   opts.restart,
 		closeChan: make(chan interface{}),
 	}
-	cmd, clb, err := chain.ToCommand(r)
+	input := newJobInput(job.closeChan)
+	job.w = input
+
+	env := chainEnv{client: p.client, guard: p.Guard}
+	inst, err := job.newInstance(env, chain)
 	if err != nil {
 		return nil, err
 	}
+	job.setProcesses(inst.processes)
+
+	p.mutex.Lock()
+	p.lastJobId++
+	if prevWriter, ok := p.longTermCommands[topic]; ok {
+		p.retiredJobs = append(p.retiredJobs, prevWriter)
+	}
+	p.longTermCommands[topic] = job
+	p.mutex.Unlock()
 
 	//start the chain in background
-	go func() {
-		defer r.Close()
-		defer clb()
-		defer close(p.longTermCommands[topic].closeChan)
+	go p.runJob(job, input, env, chain, inst)
 
-		//the command chain will be finished if the underlying pipe is closed
-		if err := cmd.Run(); err != nil {
-			p.out.Write([]byte(err.Error() + "\n"))
-		}
-	}()
+	frame := opts.framing
+	if frame == nil {
+		frame = framings[framingNewline]
+	}
 
 	return func(client mqtt.Client, message mqtt.Message) {
 		//every time a new message will come, push them to the pipe of that chain
-		w.Write(message.Payload())
-		w.Write([]byte("\n"))
+		switch err := input.push(frame(message)); err {
+		case nil:
+			atomic.AddUint64(&job.fed, 1)
+		case errJobBufferFull:
+			atomic.AddUint64(&job.dropped, 1)
+		}
 	}, nil
 }
 
-func (p *processor) shortTermSub(chain Chain) func(mqtt.Client, mqtt.Message) {
+func (p *processor) shortTermSub(topic string, chain Chain) func(mqtt.Client, mqtt.Message) {
 	//the decorator will be saved because of inline func
 	//so each message for the current sub have the same decorator
 	decorators := getNextDecorator()
 
-	return func(client mqtt.Client, message mqtt.Message) {
+	p.mutex.Lock()
+	if p.executor == nil {
+		p.executor = newExecutor(p.Execution)
+	}
+	if prev, ok := p.executions[topic]; ok {
+		prev.stop()
+	}
+	exec := &execution{lane: p.executor.newLane(), stats: &executionStats{}}
+	exec.ownLane = exec.lane != nil && exec.lane != p.executor.shared
+	p.executions[topic] = exec
+	p.mutex.Unlock()
+
+	timeout := p.Execution.Timeout
+	run := func(message mqtt.Message) {
 		wg := sync.WaitGroup{}
 		wg.Add(1)
 
@@ -318,14 +617,21 @@ func (p *processor) shortTermSub(chain Chain) func(mqtt.Client, mqtt.Message) {
 			defer wg.Done()
 
 			writer := make([]io.Writer, 0, 1)
-			if !chain.IsAppending() {
-				writer = append(writer, &prefixWriter{
+			if chain.HasShellOutput() {
+				pw := &prefixWriter{
 					Prefix:   decorate(message.Topic()+" |", decorators...) + " ",
 					Delegate: p.out,
-				})
+				}
+				defer pw.Flush()
+				writer = append(writer, pw)
+			}
+
+			env := chainEnv{client: p.client, guard: p.Guard, message: message, synchronous: exec.lane == nil}
+			if timeout > 0 {
+				env.processes = &processList{}
 			}
 
-			cmd, clb, err := chain.ToCommand(bytes.NewReader(message.Payload()), writer...)
+			cmd, clb, err := chain.ToCommand(env, bytes.NewReader(message.Payload()), writer...)
 			defer clb()
 
 			if err != nil {
@@ -333,11 +639,42 @@ func (p *processor) shortTermSub(chain Chain) func(mqtt.Client, mqtt.Message) {
 				return
 			}
 
-			if err = cmd.Run(); err != nil {
+			timedOut := int32(0)
+			if timeout > 0 {
+				timer := time.AfterFunc(timeout, func() {
+					atomic.StoreInt32(&timedOut, 1)
+					env.processes.kill()
+				})
+				defer timer.Stop()
+			}
+
+			err = cmd.Run()
+			atomic.AddUint64(&exec.stats.executed, 1)
+
+			if atomic.LoadInt32(&timedOut) == 1 {
+				atomic.AddUint64(&exec.stats.timedOut, 1)
+				writeError(fmt.Errorf("execution timed out after %s", timeout))
+			} else if err != nil {
 				writeError(err)
 			}
 		}()
 
 		wg.Wait()
 	}
+
+	return func(client mqtt.Client, message mqtt.Message) {
+		if exec.lane == nil {
+			run(message)
+			return
+		}
+
+		task := func() {
+			if !exec.isStopped() {
+				run(message)
+			}
+		}
+		if !exec.lane.submit(task, p.Execution.Drop) {
+			atomic.AddUint64(&exec.stats.dropped, 1)
+		}
+	}
 }
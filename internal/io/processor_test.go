@@ -334,11 +334,15 @@ func TestProcessor_Process_subCommand_invalidArguments(t *testing.T) {
 		args     []string
 		expected string
 	}{
-		{[]string{}, "invalid arguments\nUsage: sub [-q 0|1|2] <topic> [...topicN]\n"},
-		{[]string{"-q"}, "invalid arguments\nUsage: sub [-q 0|1|2] <topic> [...topicN]\n"},
-		{[]string{"-q", "NAN"}, "invalid qos level: strconv.Atoi: parsing \"NAN\": invalid syntax\nUsage: sub [-q 0|1|2] <topic> [...topicN]\n"},
-		{[]string{"-q", "-1"}, "invalid qos level\nUsage: sub [-q 0|1|2] <topic> [...topicN]\n"},
-		{[]string{"-q", "4"}, "invalid qos level\nUsage: sub [-q 0|1|2] <topic> [...topicN]\n"},
+		{[]string{}, "invalid arguments\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n"},
+		{[]string{"-q"}, "invalid arguments\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n"},
+		{[]string{"-q", "NAN"}, "invalid qos level: strconv.Atoi: parsing \"NAN\": invalid syntax\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n"},
+		{[]string{"-q", "-1"}, "invalid qos level\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n"},
+		{[]string{"-q", "4"}, "invalid qos level\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n"},
+		{[]string{"-restart"}, "invalid arguments\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n"},
+		{[]string{"-restart", "sometimes", "test/topic"}, "invalid restart policy\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n"},
+		{[]string{"-f"}, "invalid arguments\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n"},
+		{[]string{"-f", "xml", "test/topic"}, "invalid framing\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n"},
 	}
 	for i, test := range tests {
 		t.Run(fmt.Sprintf("TestProcessor_Process_subCommand_invalidArguments_%d", i), func(t *testing.T) {
@@ -365,7 +369,7 @@ func TestProcessor_Process_subCommand_invalidArguments(t *testing.T) {
 			output := &bytes.Buffer{}
 			toTest := NewProcessor(output, mockMqtt)
 
-			genSubHandler = func(p *processor, topic string, chain Chain) (func(mqtt.Client, mqtt.Message), error) {
+			genSubHandler = func(p *processor, topic string, chain Chain, opts subOptions) (func(mqtt.Client, mqtt.Message), error) {
 				assert.Same(t, toTest, p)
 				assert.Equal(t, "test/topic", topic)
 
@@ -409,7 +413,7 @@ func TestProcessor_Process_subCommand_errorOnSubscribe(t *testing.T) {
 	output := &bytes.Buffer{}
 	toTest := NewProcessor(output, mockMqtt)
 
-	genSubHandler = func(p *processor, topic string, chain Chain) (func(mqtt.Client, mqtt.Message), error) {
+	genSubHandler = func(p *processor, topic string, chain Chain, opts subOptions) (func(mqtt.Client, mqtt.Message), error) {
 		assert.Same(t, toTest, p)
 		assert.Equal(t, "test/topic", topic)
 
@@ -421,7 +425,7 @@ func TestProcessor_Process_subCommand_errorOnSubscribe(t *testing.T) {
 
 	toTest.Process(filledChan("<inputLine>"))
 
-	assert.Equal(t, "someError\nUsage: sub [-q 0|1|2] <topic> [...topicN]\n", output.String())
+	assert.Equal(t, "someError\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n", output.String())
 }
 
 func TestProcessor_Process_subCommand_genSub(t *testing.T) {
@@ -446,7 +450,7 @@ func TestProcessor_Process_subCommand_genSub(t *testing.T) {
 	output := &bytes.Buffer{}
 	toTest := NewProcessor(output, mockMqtt)
 
-	genSubHandler = func(p *processor, topic string, chain Chain) (func(mqtt.Client, mqtt.Message), error) {
+	genSubHandler = func(p *processor, topic string, chain Chain, opts subOptions) (func(mqtt.Client, mqtt.Message), error) {
 		assert.Same(t, toTest, p)
 		assert.Equal(t, "test/topic", topic)
 
@@ -458,7 +462,7 @@ func TestProcessor_Process_subCommand_genSub(t *testing.T) {
 
 	toTest.Process(filledChan("<inputLine>"))
 
-	assert.Equal(t, "someError\nUsage: sub [-q 0|1|2] <topic> [...topicN]\n", output.String())
+	assert.Equal(t, "someError\nUsage: sub [-q 0|1|2] [-restart never|on-failure|always] [-f newline|nul|length|json] <topic> [...topicN]\n", output.String())
 }
 
 func TestProcessor_Process_subCommand_success(t *testing.T) {
@@ -487,7 +491,7 @@ func TestProcessor_Process_subCommand_success(t *testing.T) {
 	output := &bytes.Buffer{}
 	toTest := NewProcessor(output, mockMqtt)
 
-	genSubHandler = func(p *processor, topic string, chain Chain) (func(mqtt.Client, mqtt.Message), error) {
+	genSubHandler = func(p *processor, topic string, chain Chain, opts subOptions) (func(mqtt.Client, mqtt.Message), error) {
 		assert.Same(t, toTest, p)
 		assert.Equal(t, "test/topic", topic)
 
@@ -519,7 +523,7 @@ func TestGenSubHandler_simple(t *testing.T) {
 	output := &bytes.Buffer{}
 	toTest := NewProcessor(output, nil)
 
-	fn, err := genSubHandler(toTest, "a/topic", Chain{Commands: []Command{{Name: "sub"}}})
+	fn, err := genSubHandler(toTest, "a/topic", Chain{Commands: []Command{{Name: "sub"}}}, subOptions{})
 	assert.NoError(t, err)
 
 	//call the generated handler and see what he does
@@ -546,9 +550,9 @@ func TestGenSubHandler_longTermSub(t *testing.T) {
 
 	mockCloser := mock_io.NewMockCloser(ctrl)
 	mockCloser.EXPECT().Close()
-	toTest.longTermCommands["a/topic"] = commandHandle{w: mockCloser}
+	toTest.longTermCommands["a/topic"] = &commandHandle{w: mockCloser}
 
-	fn, err := genSubHandler(toTest, "a/topic", testChain)
+	fn, err := genSubHandler(toTest, "a/topic", testChain, subOptions{})
 	assert.NoError(t, err)
 
 	//call the generated handler and see what he does
@@ -589,7 +593,7 @@ func TestGenSubHandler_longTermSub_invalidCommand(t *testing.T) {
 	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | iNvAlIdC0mManD "a" > %s &`, commandSub, outputFile))
 	assert.NoError(t, err)
 
-	fn, err := genSubHandler(toTest, "a/topic", testChain)
+	fn, err := genSubHandler(toTest, "a/topic", testChain, subOptions{})
 	assert.NoError(t, err)
 
 	//call the generated handler and see what he does
@@ -601,20 +605,20 @@ func TestGenSubHandler_longTermSub_invalidCommand(t *testing.T) {
 
 	cmdHandle, exists := toTest.longTermCommands["a/topic"]
 	assert.True(t, exists)
-	assert.NoError(t, cmdHandle.w.Close())
 
-	// wait until command is finished
+	// wait until command is finished (by itself)
 	select {
 	case <-time.After(1 * time.Second):
 		assert.Fail(t, "timout reached while waiting for command to end")
 	case <-cmdHandle.closeChan:
 	}
+	assert.NoError(t, cmdHandle.w.Close())
 
 	fileContent, err := os.ReadFile(outputFile)
 	assert.NoError(t, err)
 
 	assert.Equal(t, "", string(fileContent))
-	assert.Equal(t, "failed to start command: exec: \"iNvAlIdC0mManD\": executable file not found in $PATH\n", output.String())
+	assert.Equal(t, "job [1] a/topic died: failed to start command: exec: \"iNvAlIdC0mManD\": executable file not found in $PATH\n", output.String())
 }
 
 func TestGenSubHandler_shortTermSub(t *testing.T) {
@@ -635,12 +639,15 @@ func TestGenSubHandler_shortTermSub(t *testing.T) {
 	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | grep "a"`, commandSub))
 	assert.NoError(t, err)
 
-	fn, err := genSubHandler(toTest, "a/topic", testChain)
+	fn, err := genSubHandler(toTest, "a/topic", testChain, subOptions{})
 	assert.NoError(t, err)
 
 	//call the generated handler and see what he does
 	testMessage := mock_io.NewMockMessage(ctrl)
 	testMessage.EXPECT().Topic().Return("a/topic").AnyTimes()
+	testMessage.EXPECT().Qos().Return(byte(1)).AnyTimes()
+	testMessage.EXPECT().Retained().Return(false).AnyTimes()
+	testMessage.EXPECT().MessageID().Return(uint16(1)).AnyTimes()
 	firstCall := testMessage.EXPECT().Payload().Return([]byte("PAYLOAD"))
 	testMessage.EXPECT().Payload().After(firstCall).Return([]byte("payload"))
 	fn(nil, testMessage)
@@ -649,6 +656,39 @@ func TestGenSubHandler_shortTermSub(t *testing.T) {
 	assert.Equal(t, "\x1b[1ma/topic |\x1b[0m payload\n", output.String(), `only the "little" payload should be matched (grep)`)
 }
 
+func TestGenSubHandler_shortTermSub_environment(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ognd := getNextDecorator
+	defer func() {
+		getNextDecorator = ognd
+	}()
+	getNextDecorator = func() decorator {
+		return []string{"1"}
+	}
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	testChain, err := interpretLine(fmt.Sprintf(`%s a/# | sh -c 'echo "$MQTT_TOPIC $MQTT_QOS $MQTT_RETAINED $MQTT_TOPIC_2 $0"' {1}`, commandSub))
+	assert.NoError(t, err)
+
+	fn, err := genSubHandler(toTest, "a/#", testChain, subOptions{})
+	assert.NoError(t, err)
+
+	//call the generated handler and see what he does
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("a/topic").AnyTimes()
+	testMessage.EXPECT().Qos().Return(byte(1)).AnyTimes()
+	testMessage.EXPECT().Retained().Return(true).AnyTimes()
+	testMessage.EXPECT().MessageID().Return(uint16(1)).AnyTimes()
+	testMessage.EXPECT().Payload().Return([]byte("PAYLOAD"))
+	fn(nil, testMessage)
+
+	assert.Equal(t, "\x1b[1ma/topic |\x1b[0m a/topic 1 true topic a\n", output.String())
+}
+
 func TestGenSubHandler_shortTermSub_invalidCommand(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -667,18 +707,100 @@ func TestGenSubHandler_shortTermSub_invalidCommand(t *testing.T) {
 	testChain, err := interpretLine(fmt.Sprintf(`%s a/topic | iNvAlIdC0mManD "a"`, commandSub))
 	assert.NoError(t, err)
 
-	fn, err := genSubHandler(toTest, "a/topic", testChain)
+	fn, err := genSubHandler(toTest, "a/topic", testChain, subOptions{})
 	assert.NoError(t, err)
 
 	//call the generated handler and see what he does
 	testMessage := mock_io.NewMockMessage(ctrl)
 	testMessage.EXPECT().Topic().Return("a/topic").AnyTimes()
+	testMessage.EXPECT().Qos().Return(byte(1)).AnyTimes()
+	testMessage.EXPECT().Retained().Return(false).AnyTimes()
+	testMessage.EXPECT().MessageID().Return(uint16(1)).AnyTimes()
 	testMessage.EXPECT().Payload().Return([]byte("PAYLOAD"))
 	fn(nil, testMessage)
 
 	assert.Equal(t, "\x1b[1ma/topic |\x1b[0m failed to start command: exec: \"iNvAlIdC0mManD\": executable file not found in $PATH\n", output.String())
 }
 
+func TestProcessor_OnMqttReconnect_concurrent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).AnyTimes()
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Subscribe(gomock.Any(), gomock.Any(), gomock.Any()).Return(mockToken).AnyTimes()
+	mockMqtt.EXPECT().Unsubscribe(gomock.Any()).Return(mockToken).AnyTimes()
+
+	toTest := NewProcessor(&bytes.Buffer{}, mockMqtt)
+
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines,
+			fmt.Sprintf("%s topic/%d", commandSub, i),
+			fmt.Sprintf("%s topic/%d", commandUnsub, i-1),
+		)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		toTest.Process(filledChan(lines...))
+	}()
+
+	//reconnects can occur at any time while the processor is running
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			toTest.OnMqttReconnect()
+			toTest.HasSubscriptions()
+			toTest.GetSubscriptions()
+		}
+	}
+
+	assert.Equal(t, []string{"topic/99"}, toTest.GetSubscriptions())
+}
+
+// testMessageProperties are the properties of a mocked message which are not given explicitly
+type testMessageProperties struct {
+	qos       byte
+	retained  bool
+	duplicate bool
+}
+
+func qosTestMessage(qos byte) func(*testMessageProperties) {
+	return func(p *testMessageProperties) {
+		p.qos = qos
+	}
+}
+
+func retainedTestMessage(p *testMessageProperties) {
+	p.retained = true
+}
+
+func duplicateTestMessage(p *testMessageProperties) {
+	p.duplicate = true
+}
+
+// testMessage returns a mocked message with the given topic and payload. By default, it has the qos 0 and is neither
+// retained nor a duplicate.
+func testMessage(ctrl *gomock.Controller, topic, payload string, modify ...func(*testMessageProperties)) mqtt.Message {
+	properties := testMessageProperties{}
+	for _, m := range modify {
+		m(&properties)
+	}
+
+	message := mock_io.NewMockMessage(ctrl)
+	message.EXPECT().Topic().Return(topic).AnyTimes()
+	message.EXPECT().Payload().Return([]byte(payload)).AnyTimes()
+	message.EXPECT().Qos().Return(properties.qos).AnyTimes()
+	message.EXPECT().Retained().Return(properties.retained).AnyTimes()
+	message.EXPECT().Duplicate().Return(properties.duplicate).AnyTimes()
+	return message
+}
+
 func filledChan(content ...string) chan string {
 	result := make(chan string, len(content))
 	for _, c := range content {
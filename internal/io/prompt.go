@@ -0,0 +1,57 @@
+package io
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// PromptData contains the variables which can be used in the prompt template
+type PromptData struct {
+	// Env is the name of the environment
+	Env string
+	// Protected is true if the environment is protected
+	Protected bool
+	// Broker is the host of the connected broker
+	Broker string
+	// ClientId is the client id of the connection
+	ClientId string
+	// State is the state of the connection: connecting, connected, reconnecting or disconnected
+	State string
+	// Subscriptions is the count of the subscriptions
+	Subscriptions int
+	// Jobs is the count of the running jobs
+	Jobs int
+}
+
+// SetPromptTemplate sets the template of the prompt. The prompt will be rendered immediately and each time the
+// prompt is refreshed with the data of the given function.
+func (s *shell) SetPromptTemplate(prompt string, data func() PromptData) error {
+	tmpl, err := template.New("prompt").Parse(prompt)
+	if err != nil {
+		return err
+	}
+
+	//the data will never change its structure: so an invalid template can be recognised right now
+	if err := tmpl.Execute(&bytes.Buffer{}, data()); err != nil {
+		return err
+	}
+
+	s.promptTemplate = tmpl
+	s.promptData = data
+	s.RefreshPrompt()
+
+	return nil
+}
+
+// RefreshPrompt renders the prompt template again. It should be called on each change of the prompt data.
+func (s *shell) RefreshPrompt() {
+	if s.promptTemplate == nil {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.promptTemplate.Execute(buf, s.promptData()); err != nil {
+		return
+	}
+	s.SetPrompt(buf.String())
+}
@@ -0,0 +1,56 @@
+package io
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testPromptShell(t *testing.T) *shell {
+	toTest, err := NewShell("", "", &MacroManager{}, nil)
+	assert.NoError(t, err)
+	toTest.targetOut = &bytes.Buffer{}
+
+	return toTest
+}
+
+func TestShell_SetPromptTemplate(t *testing.T) {
+	toTest := testPromptShell(t)
+	defer toTest.Close()
+
+	data := PromptData{
+		Env:      "prod",
+		Broker:   "127.0.0.1:1883",
+		ClientId: "mqtt-shell",
+		State:    connectionStateConnecting,
+	}
+	err := toTest.SetPromptTemplate(`{{if eq .Env "prod"}}!{{end}}{{.Env}}@{{.Broker}} [{{.State}}] {{.Subscriptions}}/{{.Jobs}}> `, func() PromptData {
+		return data
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "!prod@127.0.0.1:1883 [connecting] 0/0> ", toTest.prompt)
+
+	data.State = connectionStateConnected
+	data.Subscriptions = 2
+	data.Jobs = 1
+	toTest.RefreshPrompt()
+	assert.Equal(t, "!prod@127.0.0.1:1883 [connected] 2/1> ", toTest.prompt)
+}
+
+func TestShell_SetPromptTemplate_invalid(t *testing.T) {
+	toTest := testPromptShell(t)
+	defer toTest.Close()
+
+	data := func() PromptData {
+		return PromptData{}
+	}
+
+	assert.Error(t, toTest.SetPromptTemplate("{{.Env", data))
+	assert.Error(t, toTest.SetPromptTemplate("{{.Unknown}}", data))
+	assert.Equal(t, "", toTest.prompt)
+
+	//without template the prompt should not be touched
+	toTest.RefreshPrompt()
+	assert.Equal(t, "", toTest.prompt)
+}
@@ -0,0 +1,291 @@
+package io
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// captureRecord is one recorded message
+type captureRecord struct {
+	Time time.Time `json:"time"`
+	messageEnvelope
+}
+
+func newCaptureRecord(message mqtt.Message, now time.Time) captureRecord {
+	return captureRecord{Time: now, messageEnvelope: newMessageEnvelope(message)}
+}
+
+// captureEncoder writes a record into the capture file
+type captureEncoder func(w io.Writer, record captureRecord) error
+
+// encodeJsonRecord writes the record as json (one record per line)
+func encodeJsonRecord(w io.Writer, record captureRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// encodeBinaryRecord writes the record in the compact binary format:
+// time (int64 unix nanoseconds) | flags (uint8: qos + retained<<2) | topic length (uint16) | topic | payload length (uint32) | payload
+func encodeBinaryRecord(w io.Writer, record captureRecord) error {
+	payload := record.payload()
+
+	buf := make([]byte, 0, 8+1+2+len(record.Topic)+4+len(payload))
+	buf = appendUint64(buf, uint64(record.Time.UnixNano()))
+
+	flags := record.Qos & 0x03
+	if record.Retained {
+		flags |= 0x04
+	}
+	buf = append(buf, flags)
+	buf = appendUint16(buf, uint16(len(record.Topic)))
+	buf = append(buf, record.Topic...)
+	buf = appendUint32(buf, uint32(len(payload)))
+	buf = append(buf, payload...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return append(buf, b...)
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return append(buf, b...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return append(buf, b...)
+}
+
+// recorder writes all received messages into a capture file
+type recorder struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encode  captureEncoder
+	filter  string
+	count   uint64
+	lastErr error
+
+	//the topic which is subscribed by the recorder itself (if any). It will be released as soon as the user takes over
+	//that subscription (sub or unsub): the recorder must not unsubscribe it then.
+	subscription string
+
+	//a message will be passed to each handler of all matching subscriptions: but it should be recorded only once
+	last mqtt.Message
+}
+
+func (r *recorder) isRecording() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.file != nil
+}
+
+func (r *recorder) start(file *os.File, encode captureEncoder, filter string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.file = file
+	r.encode = encode
+	r.filter = filter
+	r.count = 0
+	r.lastErr = nil
+	r.last = nil
+}
+
+func (r *recorder) record(message mqtt.Message) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil || r.last == message || !topicMatches(r.filter, message.Topic()) {
+		return
+	}
+	r.last = message
+
+	if err := r.encode(r.file, newCaptureRecord(message, timeNow())); err != nil {
+		r.lastErr = err
+		return
+	}
+	r.count++
+}
+
+// release gives up the ownership of the given subscription
+func (r *recorder) release(subscription string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.subscription == subscription {
+		r.subscription = ""
+	}
+}
+
+// stop stops the recording and returns the recorded file, the count of recorded messages and the subscription of the recorder
+func (r *recorder) stop() (string, uint64, string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return "", 0, "", nil
+	}
+
+	name, count, subscription, err := r.file.Name(), r.count, r.subscription, r.lastErr
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.file = nil
+	r.subscription = ""
+	r.last = nil
+
+	return name, count, subscription, err
+}
+
+func (r *recorder) String() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.file == nil {
+		return "not recording"
+	}
+	status := fmt.Sprintf("recording %s to %s (%d messages)", r.filter, r.file.Name(), r.count)
+	if r.lastErr != nil {
+		status += ": " + r.lastErr.Error()
+	}
+	return status
+}
+
+func (p *processor) handleRecord(chain Chain) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: "+commandRecord+" start [-f jsonl|binary|mosquitto|csv] <file> [filter]\n       "+commandRecord+" stop", err.Error())
+		}
+	}()
+
+	args := chain.Commands[0].Arguments
+	if len(args) == 0 {
+		p.out.Write([]byte(p.recorder.String() + "\n"))
+		return nil
+	}
+
+	switch args[0] {
+	case "start":
+		return p.startRecording(args[1:])
+	case "stop":
+		if len(args) > 1 {
+			return errors.New("invalid arguments")
+		}
+		return p.stopRecording()
+	default:
+		return errors.New("invalid arguments")
+	}
+}
+
+func (p *processor) startRecording(args []string) error {
+	format := captureFormatJson
+	file, filter := "", ""
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-f":
+			if i+1 >= len(args) {
+				return errors.New("invalid arguments")
+			}
+			format = args[i+1]
+			i++
+		case file == "":
+			file = args[i]
+		case filter == "":
+			filter = args[i]
+		default:
+			return errors.New("invalid arguments")
+		}
+	}
+
+	captureFormat, ok := captureFormats[format]
+	if !ok {
+		return errors.New("invalid capture format")
+	}
+	if captureFormat.encode == nil {
+		return fmt.Errorf("the capture format %s can only be replayed", format)
+	}
+	if file == "" {
+		return errors.New("invalid arguments")
+	}
+	if p.recorder.isRecording() {
+		return errors.New("a recording is already running")
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("unable to create capture file: %w", err)
+	}
+	if captureFormat.header != nil {
+		if _, err := f.Write(captureFormat.header); err != nil {
+			f.Close()
+			return fmt.Errorf("unable to write capture file: %w", err)
+		}
+	}
+
+	subscribe := filter != ""
+	if filter == "" {
+		filter = "#"
+	}
+	p.recorder.start(f, captureFormat.encode, filter)
+
+	p.mutex.RLock()
+	_, subscribed := p.subscribedTopics[filter]
+	p.mutex.RUnlock()
+
+	//without a filter only the messages of the existing subscriptions will be recorded
+	if subscribe && !subscribed {
+		clb := p.observe(filter, func(mqtt.Client, mqtt.Message) {})
+		if token := p.client.Subscribe(filter, 2, clb); !token.Wait() {
+			p.recorder.stop()
+			return token.Error()
+		}
+		p.setSubscription(filter, subscription{qos: 2, callback: clb})
+
+		p.recorder.mutex.Lock()
+		p.recorder.subscription = filter
+		p.recorder.mutex.Unlock()
+	}
+
+	p.out.Write([]byte(p.recorder.String() + "\n"))
+	return nil
+}
+
+func (p *processor) stopRecording() error {
+	name, count, subscription, err := p.recorder.stop()
+	if name == "" {
+		return errors.New("no recording is running")
+	}
+
+	if subscription != "" {
+		if err := p.unsubscribe(subscription); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("recording of %s failed: %w", name, err)
+	}
+
+	p.out.Write([]byte(fmt.Sprintf("recorded %d messages to %s\n", count, name)))
+	return nil
+}
@@ -0,0 +1,169 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// processInBackground processes the lines of the returned channel until it will be closed
+func processInBackground(toTest *processor) (chan string, chan struct{}) {
+	input := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		toTest.Process(input)
+	}()
+
+	return input, done
+}
+
+func TestProcessor_Process_record(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	otn := timeNow
+	defer func() {
+		timeNow = otn
+	}()
+	timeNow = func() time.Time {
+		return time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	}
+
+	captureFile := path.Join(t.TempDir(), "capture.jsonl")
+
+	output := &lockedBuffer{}
+	toTest := NewProcessor(output, nil)
+	handler := toTest.observe("#", func(mqtt.Client, mqtt.Message) {})
+
+	handler(nil, testMessage(ctrl, "a/before", "0"))
+
+	input, done := processInBackground(toTest)
+	input <- fmt.Sprintf("%s start %s", commandRecord, captureFile)
+	assert.Eventually(t, func() bool {
+		return output.String() != ""
+	}, 1*time.Second, 1*time.Millisecond)
+
+	message := testMessage(ctrl, "a/topic", "1", qosTestMessage(1), retainedTestMessage)
+	handler(nil, message)
+	handler(nil, message) //the same message of an overlapping subscription
+	handler(nil, testMessage(ctrl, "b/topic", "\xff", qosTestMessage(2)))
+
+	input <- commandRecord
+	input <- commandRecord + " stop"
+	close(input)
+	<-done
+	handler(nil, testMessage(ctrl, "a/after", "3"))
+
+	assert.Equal(t, "recording # to "+captureFile+" (0 messages)\n"+
+		"recording # to "+captureFile+" (2 messages)\n"+
+		"recorded 2 messages to "+captureFile+"\n", output.String())
+
+	content, err := os.ReadFile(captureFile)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"time":"2021-06-01T12:00:00Z","topic":"a/topic","qos":1,"retained":true,"payload":"1"}
+{"time":"2021-06-01T12:00:00Z","topic":"b/topic","qos":2,"retained":false,"payloadBase64":"/w=="}
+`, string(content))
+}
+
+func TestProcessor_Process_record_filter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	captureFile := path.Join(t.TempDir(), "capture.bin")
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).Times(2)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+
+	var handler mqtt.MessageHandler
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("a/#"), gomock.Eq(byte(2)), gomock.Any()).DoAndReturn(
+		func(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+			handler = callback
+			return mockToken
+		})
+	mockMqtt.EXPECT().Unsubscribe(gomock.Eq("a/#")).Return(mockToken)
+
+	toTest := NewProcessor(&lockedBuffer{}, mockMqtt)
+
+	input, done := processInBackground(toTest)
+	input <- fmt.Sprintf("%s start -f binary %s a/#", commandRecord, captureFile)
+	assert.Eventually(t, toTest.HasSubscriptions, 1*time.Second, 1*time.Millisecond, "the filter should be subscribed by the recorder")
+
+	handler(nil, testMessage(ctrl, "a/topic", "PAY", qosTestMessage(1), retainedTestMessage))
+
+	input <- commandRecord + " stop"
+	close(input)
+	<-done
+	assert.Empty(t, toTest.GetSubscriptions())
+
+	content, err := os.ReadFile(captureFile)
+	assert.NoError(t, err)
+	assert.Equal(t, captureMagic, content[:len(captureMagic)])
+
+	record := content[len(captureMagic)+8:] //skip the time
+	assert.Equal(t, []byte{0x05, 0x00, 0x07, 'a', '/', 't', 'o', 'p', 'i', 'c', 0x00, 0x00, 0x00, 0x03, 'P', 'A', 'Y'}, record)
+}
+
+func TestProcessor_Process_record_replacedSubscription(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	captureFile := path.Join(t.TempDir(), "capture.jsonl")
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).Times(2)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("a/#"), gomock.Eq(byte(2)), gomock.Any()).Return(mockToken)
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("a/#"), gomock.Eq(byte(0)), gomock.Any()).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	//the subscription of the user must not be unsubscribed by the recorder
+	toTest.Process(filledChan(
+		fmt.Sprintf("%s start %s a/#", commandRecord, captureFile),
+		commandSub+" a/#",
+		commandRecord+" stop",
+	))
+
+	assert.Equal(t, "recording a/# to "+captureFile+" (0 messages)\n"+
+		"recorded 0 messages to "+captureFile+"\n", output.String())
+	assert.Equal(t, []string{"a/#"}, toTest.GetSubscriptions())
+}
+
+func TestProcessor_Process_record_errors(t *testing.T) {
+	captureFile := path.Join(t.TempDir(), "capture.jsonl")
+
+	usage := "\nUsage: " + commandRecord + " start [-f jsonl|binary|mosquitto|csv] <file> [filter]\n       " + commandRecord + " stop\n"
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	toTest.Process(filledChan(
+		commandRecord+" stop",
+		commandRecord+" start",
+		commandRecord+" start -f xml "+captureFile,
+		commandRecord+" start -f pcap "+captureFile,
+		commandRecord+" unknown",
+		commandRecord+" start "+captureFile,
+		commandRecord+" start "+captureFile,
+		commandRecord+" stop",
+	))
+
+	assert.Equal(t, "no recording is running"+usage+
+		"invalid arguments"+usage+
+		"invalid capture format"+usage+
+		"the capture format pcap can only be replayed"+usage+
+		"invalid arguments"+usage+
+		"recording # to "+captureFile+" (0 messages)\n"+
+		"a recording is already running"+usage+
+		"recorded 0 messages to "+captureFile+"\n", output.String())
+}
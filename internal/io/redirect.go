@@ -0,0 +1,278 @@
+package io
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const rotationTimeFormat = "20060102-150405"
+
+// redirectFile is the target of a file redirection. The path can contain placeholders
+// and the file can be rotated by size and/or time.
+type redirectFile struct {
+	env          chainEnv
+	pathTemplate string
+	flags        int
+
+	maxSize  int64
+	interval time.Duration
+	compress bool
+
+	file    *os.File
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func newRedirectFile(env chainEnv, target Command, appending bool) (rf *redirectFile, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: > <file> [-s <max-size>] [-i <interval>] [-z]", err.Error())
+		}
+	}()
+
+	rf = &redirectFile{
+		env:          env,
+		pathTemplate: target.Name,
+		flags:        os.O_WRONLY | os.O_CREATE,
+	}
+	if appending {
+		rf.flags = rf.flags | os.O_APPEND
+	} else {
+		rf.flags = rf.flags | os.O_TRUNC
+	}
+
+	args := target.Arguments
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-s":
+			if i+1 >= len(args) {
+				return nil, errors.New("invalid arguments")
+			}
+			rf.maxSize, err = parseSize(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid max size: %w", err)
+			}
+			i++
+		case "-i":
+			if i+1 >= len(args) {
+				return nil, errors.New("invalid arguments")
+			}
+			rf.interval, err = time.ParseDuration(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid interval: %w", err)
+			}
+			i++
+		case "-z":
+			rf.compress = true
+		default:
+			return nil, errors.New("invalid arguments")
+		}
+	}
+
+	//open the file directly so that errors will be recognised as early as possible
+	path, err := rf.currentPath()
+	if err != nil {
+		return nil, err
+	}
+	return rf, rf.open(path)
+}
+
+// currentPath expands the placeholders of the path template. The topic of the message must not lead out of the
+// directory of the template: topic levels which are empty or relative (. and ..) can not be used inside a path.
+func (r *redirectFile) currentPath() (string, error) {
+	base := ""
+	for _, match := range placeholderRegex.FindAllStringSubmatchIndex(r.pathTemplate, -1) {
+		placeholder := r.pathTemplate[match[0]:match[1]]
+		name := r.pathTemplate[match[2]:match[3]]
+		if strings.HasPrefix(placeholder, "\\") || name == "date" || r.env.message == nil {
+			continue
+		}
+
+		if base == "" {
+			//the directory in front of the first topic placeholder
+			base = filepath.Dir(r.pathTemplate[:match[0]] + "_")
+		}
+		levels := strings.Split(r.env.message.Topic(), "/")
+		if name != "topic" {
+			level, _ := strconv.Atoi(name)
+			if level < 1 || level > len(levels) {
+				continue
+			}
+			levels = levels[level-1 : level]
+		}
+		for _, level := range levels {
+			if level == "" || level == "." || level == ".." || strings.ContainsRune(level, os.PathSeparator) {
+				return "", fmt.Errorf("the topic %s can not be used as file path", r.env.message.Topic())
+			}
+		}
+	}
+
+	path := filepath.Clean(r.env.expand([]string{r.pathTemplate})[0])
+	if base != "" {
+		rel, err := filepath.Rel(base, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return "", fmt.Errorf("the file path %s is outside of %s", path, base)
+		}
+	}
+	return path, nil
+}
+
+func (r *redirectFile) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, r.flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.path = path
+	r.size = stat.Size()
+	r.modTime = stat.ModTime()
+	if r.size == 0 {
+		r.modTime = timeNow()
+	}
+
+	return nil
+}
+
+func (r *redirectFile) needRotation(now time.Time, toWrite int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.maxSize > 0 && r.size+int64(toWrite) > r.maxSize {
+		return true
+	}
+	if r.interval > 0 && !r.modTime.Truncate(r.interval).Equal(now.Truncate(r.interval)) {
+		return true
+	}
+	return false
+}
+
+func (r *redirectFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	rotatedPath := r.path + "." + r.modTime.Format(rotationTimeFormat)
+	for i := 1; fileExists(rotatedPath) || fileExists(rotatedPath+".gz"); i++ {
+		rotatedPath = fmt.Sprintf("%s.%s.%d", r.path, r.modTime.Format(rotationTimeFormat), i)
+	}
+
+	if err := os.Rename(r.path, rotatedPath); err != nil {
+		return err
+	}
+	if r.compress {
+		if err := compressFile(rotatedPath); err != nil {
+			return err
+		}
+	}
+
+	return r.open(r.path)
+}
+
+func (r *redirectFile) Write(b []byte) (n int, err error) {
+	now := timeNow()
+
+	path, err := r.currentPath()
+	if err != nil {
+		return 0, err
+	}
+	if path != r.path {
+		//the path is changed (for example the date)
+		r.file.Close()
+		if err := r.open(path); err != nil {
+			return 0, err
+		}
+	}
+	if r.needRotation(now, len(b)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err = r.file.Write(b)
+	r.size += int64(n)
+	r.modTime = now
+
+	return
+}
+
+func (r *redirectFile) Close() error {
+	return r.file.Close()
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// parseSize parses sizes such like 512, 10K, 10M or 1G
+func parseSize(raw string) (int64, error) {
+	if raw == "" {
+		return 0, errors.New("empty size")
+	}
+	factor := int64(1)
+
+	switch strings.ToUpper(raw[len(raw)-1:]) {
+	case "K":
+		factor = 1024
+	case "M":
+		factor = 1024 * 1024
+	case "G":
+		factor = 1024 * 1024 * 1024
+	}
+	if factor > 1 {
+		raw = raw[:len(raw)-1]
+	}
+
+	size, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size <= 0 {
+		return 0, errors.New("size must be positive")
+	}
+
+	return size * factor, nil
+}
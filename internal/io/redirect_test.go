@@ -0,0 +1,198 @@
+package io
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected int64
+		err      string
+	}{
+		{"512", 512, ""},
+		{"10k", 10 * 1024, ""},
+		{"10M", 10 * 1024 * 1024, ""},
+		{"1G", 1024 * 1024 * 1024, ""},
+		{"", 0, "empty size"},
+		{"0", 0, "size must be positive"},
+		{"M", 0, `strconv.ParseInt: parsing "": invalid syntax`},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestParseSize_%d", i), func(t *testing.T) {
+			result, err := parseSize(test.raw)
+
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestNewRedirectFile_invalidArguments(t *testing.T) {
+	usage := "\nUsage: > <file> [-s <max-size>] [-i <interval>] [-z]"
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-s"}, "invalid arguments" + usage},
+		{[]string{"-s", "NAN"}, `invalid max size: strconv.ParseInt: parsing "NAN": invalid syntax` + usage},
+		{[]string{"-i"}, "invalid arguments" + usage},
+		{[]string{"-i", "NAN"}, `invalid interval: time: invalid duration "NAN"` + usage},
+		{[]string{"-x"}, "invalid arguments" + usage},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestNewRedirectFile_invalidArguments_%d", i), func(t *testing.T) {
+			_, err := newRedirectFile(chainEnv{}, Command{Name: path.Join(t.TempDir(), "out"), Arguments: test.args}, true)
+			assert.EqualError(t, err, test.expected)
+		})
+	}
+}
+
+func TestRedirectFile_placeholders(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	otn := timeNow
+	defer func() {
+		timeNow = otn
+	}()
+	timeNow = func() time.Time {
+		return time.Date(2021, 8, 15, 16, 15, 0, 0, time.UTC)
+	}
+
+	testMessage := mock_io.NewMockMessage(ctrl)
+	testMessage.EXPECT().Topic().Return("device/1").AnyTimes()
+
+	dir := t.TempDir()
+	toTest, err := newRedirectFile(chainEnv{message: testMessage}, Command{Name: path.Join(dir, "{topic}", "{date}.log")}, true)
+	assert.NoError(t, err)
+
+	_, err = toTest.Write([]byte("payload\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, toTest.Close())
+
+	content, err := os.ReadFile(path.Join(dir, "device", "1", "2021-08-15.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "payload\n", string(content))
+}
+
+func TestRedirectFile_pathTraversal(t *testing.T) {
+	tests := []struct {
+		topic    string
+		template string
+		err      bool
+	}{
+		{"../../etc/passwd", "{topic}", true},
+		{"a/../../../b", "{topic}.log", true},
+		{"/etc/passwd", "{topic}", true},
+		{"a//b", "{topic}", true},
+		{"a/./b", "{topic}", true},
+		{"..", "{1}/out.log", true},
+		{"a/..", "{1}/{2}/out.log", true},
+		{"a/..", "{1}/out.log", false},
+		{"a/b", "{topic}/../out.log", false},
+		{"a/b", "\\{topic}", false},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestRedirectFile_pathTraversal_%d", i), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			testMessage := mock_io.NewMockMessage(ctrl)
+			testMessage.EXPECT().Topic().Return(test.topic).AnyTimes()
+
+			dir := path.Join(t.TempDir(), "out")
+			toTest, err := newRedirectFile(chainEnv{message: testMessage}, Command{Name: path.Join(dir, "x") + "/" + test.template}, true)
+			if test.err {
+				assert.Error(t, err)
+				_, err = os.Stat(dir)
+				assert.True(t, os.IsNotExist(err), "no file should be created")
+			} else {
+				assert.NoError(t, err)
+				assert.NoError(t, toTest.Close())
+			}
+		})
+	}
+}
+
+func TestRedirectFile_rotateBySize(t *testing.T) {
+	otn := timeNow
+	defer func() {
+		timeNow = otn
+	}()
+	timeNow = func() time.Time {
+		return time.Date(2021, 8, 15, 16, 15, 0, 0, time.UTC)
+	}
+
+	filePath := path.Join(t.TempDir(), "out.log")
+	toTest, err := newRedirectFile(chainEnv{}, Command{Name: filePath, Arguments: []string{"-s", "10", "-z"}}, true)
+	assert.NoError(t, err)
+
+	_, err = toTest.Write([]byte("message1\n"))
+	assert.NoError(t, err)
+	_, err = toTest.Write([]byte("message2\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, toTest.Close())
+
+	content, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "message2\n", string(content))
+
+	gzFile, err := os.Open(filePath + ".20210815-161500.gz")
+	assert.NoError(t, err)
+	defer gzFile.Close()
+
+	gz, err := gzip.NewReader(gzFile)
+	assert.NoError(t, err)
+	content, err = ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, "message1\n", string(content))
+}
+
+func TestRedirectFile_rotateByInterval(t *testing.T) {
+	otn := timeNow
+	defer func() {
+		timeNow = otn
+	}()
+	now := time.Date(2021, 8, 15, 16, 15, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+
+	filePath := path.Join(t.TempDir(), "out.log")
+	toTest, err := newRedirectFile(chainEnv{}, Command{Name: filePath, Arguments: []string{"-i", "1h"}}, true)
+	assert.NoError(t, err)
+
+	_, err = toTest.Write([]byte("message1\n"))
+	assert.NoError(t, err)
+
+	now = now.Add(30 * time.Minute)
+	_, err = toTest.Write([]byte("message2\n"))
+	assert.NoError(t, err)
+
+	now = now.Add(30 * time.Minute)
+	_, err = toTest.Write([]byte("message3\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, toTest.Close())
+
+	content, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "message3\n", string(content))
+
+	content, err = os.ReadFile(filePath + ".20210815-164500")
+	assert.NoError(t, err)
+	assert.Equal(t, "message1\nmessage2\n", string(content))
+}
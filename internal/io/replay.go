@@ -0,0 +1,242 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// replaying is a running replay of a capture file
+type replaying struct {
+	//must be the first fields (64bit alignment for atomic operations)
+	published uint64
+	failed    uint64
+	blocked   uint64
+
+	file     string
+	format   string
+	speed    float64
+	filter   string
+	rewrites []rewriteRule
+
+	stopChan chan interface{}
+	done     chan interface{}
+}
+
+func (r *replaying) isRunning() bool {
+	select {
+	case <-r.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (r *replaying) stop() {
+	if r.isRunning() {
+		select {
+		case <-r.stopChan:
+		default:
+			close(r.stopChan)
+		}
+	}
+	<-r.done
+}
+
+func (r *replaying) topic(topic string) string {
+	for _, rule := range r.rewrites {
+		topic = rule.pattern.ReplaceAllString(topic, rule.replacement)
+	}
+	return topic
+}
+
+func (r *replaying) String() string {
+	if r.isRunning() {
+		return r.summary("running")
+	}
+	return r.summary("finished")
+}
+
+func (r *replaying) summary(state string) string {
+	summary := fmt.Sprintf("replay of %s %s (published: %d, failed: %d", r.file, state,
+		atomic.LoadUint64(&r.published), atomic.LoadUint64(&r.failed))
+	if blocked := atomic.LoadUint64(&r.blocked); blocked > 0 {
+		summary += fmt.Sprintf(", blocked: %d", blocked)
+	}
+	return summary + ")"
+}
+
+// wait waits until the given time is reached. Returns false if the replay was stopped in the meantime.
+func (r *replaying) wait(until time.Time) bool {
+	delay := until.Sub(timeNow())
+	if delay <= 0 {
+		select {
+		case <-r.stopChan:
+			return false
+		default:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-r.stopChan:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (p *processor) handleReplay(chain Chain) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: "+commandReplay+" [-i <format>] [-s <factor>|-fast] [-f <filter>] [-t <regex> <replacement>]... <file>\n       "+commandReplay+" stop", err.Error())
+		}
+	}()
+
+	args := chain.Commands[0].Arguments
+	if len(args) == 0 {
+		if p.replay == nil {
+			p.out.Write([]byte("no replay\n"))
+		} else {
+			p.out.Write([]byte(p.replay.String() + "\n"))
+		}
+		return nil
+	}
+	if len(args) == 1 && args[0] == "stop" {
+		if p.replay == nil || !p.replay.isRunning() {
+			return errors.New("no replay is running")
+		}
+		p.replay.stop()
+		return nil
+	}
+
+	replay := &replaying{
+		speed:    1,
+		filter:   "#",
+		stopChan: make(chan interface{}),
+		done:     make(chan interface{}),
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-s":
+			if i+1 >= len(args) {
+				return errors.New("invalid arguments")
+			}
+			replay.speed, err = strconv.ParseFloat(strings.TrimSuffix(args[i+1], "x"), 64)
+			if err != nil || replay.speed <= 0 {
+				return errors.New("invalid speed factor")
+			}
+			i++
+		case "-i":
+			if i+1 >= len(args) {
+				return errors.New("invalid arguments")
+			}
+			if _, ok := captureFormats[args[i+1]]; !ok {
+				return errors.New("invalid capture format")
+			}
+			replay.format = args[i+1]
+			i++
+		case "-fast":
+			//as fast as possible
+			replay.speed = 0
+		case "-f":
+			if i+1 >= len(args) {
+				return errors.New("invalid arguments")
+			}
+			replay.filter = args[i+1]
+			i++
+		case "-t":
+			if i+2 >= len(args) {
+				return errors.New("invalid arguments")
+			}
+			pattern, err := regexp.Compile(args[i+1])
+			if err != nil {
+				return fmt.Errorf("invalid rewrite rule: %w", err)
+			}
+			replay.rewrites = append(replay.rewrites, rewriteRule{pattern: pattern, replacement: args[i+2]})
+			i += 2
+		default:
+			if replay.file != "" {
+				return errors.New("invalid arguments")
+			}
+			replay.file = args[i]
+		}
+	}
+
+	if replay.file == "" {
+		return errors.New("invalid arguments")
+	}
+	if p.replay != nil && p.replay.isRunning() {
+		return errors.New("a replay is already running")
+	}
+
+	next, closer, err := openCapture(replay.file, replay.format)
+	if err != nil {
+		return fmt.Errorf("unable to open capture file: %w", err)
+	}
+
+	if p.Guard.needsReplayConfirmation() && !p.confirm("replay "+replay.file) {
+		closer.Close()
+		return nil
+	}
+
+	p.replay = replay
+	go p.runReplay(replay, next, closer)
+
+	return nil
+}
+
+func (p *processor) runReplay(replay *replaying, next captureReader, closer io.Closer) {
+	defer close(replay.done)
+	defer closer.Close()
+
+	var first time.Time
+	start := timeNow()
+
+	for {
+		record, err := next()
+		if err == io.EOF {
+			p.out.Write([]byte(replay.summary("finished") + "\n"))
+			return
+		}
+		if err != nil {
+			p.out.Write([]byte(fmt.Sprintf("replay of %s failed: %s\n", replay.file, err)))
+			return
+		}
+		if !topicMatches(replay.filter, record.Topic) {
+			continue
+		}
+
+		if first.IsZero() {
+			first = record.Time
+		}
+		until := start
+		if replay.speed > 0 {
+			until = start.Add(time.Duration(float64(record.Time.Sub(first)) / replay.speed))
+		}
+		if !replay.wait(until) {
+			p.out.Write([]byte(replay.summary("stopped") + "\n"))
+			return
+		}
+
+		topic := replay.topic(record.Topic)
+		if _, blocked := p.Guard.blocked(topic); blocked {
+			atomic.AddUint64(&replay.blocked, 1)
+			continue
+		}
+		if token := p.client.Publish(topic, record.Qos, record.Retained, record.payload()); !token.Wait() {
+			atomic.AddUint64(&replay.failed, 1)
+			continue
+		}
+		atomic.AddUint64(&replay.published, 1)
+	}
+}
@@ -0,0 +1,185 @@
+package io
+
+import (
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestCapture(t *testing.T, file string, format string, records ...captureRecord) {
+	f, err := os.Create(file)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	f.Write(captureFormats[format].header)
+	for _, record := range records {
+		assert.NoError(t, captureFormats[format].encode(f, record))
+	}
+}
+
+func testCaptureRecord(offset time.Duration, topic string, payload []byte, qos byte, retained bool) captureRecord {
+	record := captureRecord{
+		Time: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC).Add(offset),
+		messageEnvelope: messageEnvelope{
+			Topic:    topic,
+			Qos:      qos,
+			Retained: retained,
+		},
+	}
+	record.PayloadBase64 = payload
+	return record
+}
+
+func TestProcessor_Process_replay(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	captureFile := path.Join(os.TempDir(), "replay.jsonl")
+	defer os.Remove(captureFile)
+	writeTestCapture(t, captureFile, captureFormatJson,
+		testCaptureRecord(0, "prod/a", []byte("1"), 1, true),
+		testCaptureRecord(time.Second, "other/b", []byte("2"), 0, false),
+		testCaptureRecord(2*time.Second, "prod/c", []byte("3"), 2, false),
+	)
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).Times(2)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	gomock.InOrder(
+		mockMqtt.EXPECT().Publish(gomock.Eq("staging/a"), gomock.Eq(byte(1)), gomock.Eq(true), gomock.Eq([]byte("1"))).Return(mockToken),
+		mockMqtt.EXPECT().Publish(gomock.Eq("staging/c"), gomock.Eq(byte(2)), gomock.Eq(false), gomock.Eq([]byte("3"))).Return(mockToken),
+	)
+
+	output := &lockedBuffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	start := time.Now()
+	input, done := processInBackground(toTest)
+	input <- fmt.Sprintf(`%s -s 100x -f prod/# -t ^prod/ staging/ %s`, commandReplay, captureFile)
+	assert.Eventually(t, func() bool {
+		return output.String() != ""
+	}, 1*time.Second, 1*time.Millisecond)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond, "the timing should be respected")
+
+	close(input)
+	<-done
+
+	assert.Equal(t, "replay of "+captureFile+" finished (published: 2, failed: 0)\n", output.String())
+}
+
+func TestProcessor_Process_replay_stop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	captureFile := path.Join(os.TempDir(), "replay.bin")
+	defer os.Remove(captureFile)
+	writeTestCapture(t, captureFile, captureFormatBinary,
+		testCaptureRecord(0, "a", []byte("1"), 0, false),
+		testCaptureRecord(time.Hour, "b", []byte("2"), 0, false),
+	)
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	published := make(chan struct{})
+	mockMqtt.EXPECT().Publish(gomock.Eq("a"), gomock.Eq(byte(0)), gomock.Eq(false), gomock.Eq([]byte("1"))).DoAndReturn(
+		func(string, byte, bool, interface{}) mqtt.Token {
+			close(published)
+			return mockToken
+		})
+
+	output := &lockedBuffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	input, done := processInBackground(toTest)
+	input <- commandReplay + " " + captureFile
+	<-published
+
+	input <- commandReplay + " " + captureFile
+	input <- commandReplay + " stop"
+	input <- commandReplay + " stop"
+	close(input)
+	<-done
+
+	usage := "\nUsage: " + commandReplay + " [-i <format>] [-s <factor>|-fast] [-f <filter>] [-t <regex> <replacement>]... <file>\n       " + commandReplay + " stop\n"
+	assert.Equal(t, "a replay is already running"+usage+
+		"replay of "+captureFile+" stopped (published: 1, failed: 0)\n"+
+		"no replay is running"+usage, output.String())
+}
+
+func TestProcessor_Process_replay_guard(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	captureFile := path.Join(os.TempDir(), "replay-guard.jsonl")
+	defer os.Remove(captureFile)
+	writeTestCapture(t, captureFile, captureFormatJson,
+		testCaptureRecord(0, "config/a", []byte("1"), 1, true),
+		testCaptureRecord(time.Second, "system/b", []byte("2"), 0, false),
+	)
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Publish(gomock.Eq("config/a"), gomock.Eq(byte(1)), gomock.Eq(true), gomock.Eq([]byte("1"))).Return(mockToken)
+
+	output := &lockedBuffer{}
+	toTest := NewProcessor(output, mockMqtt)
+	toTest.Guard = &PublishGuard{Protected: true, BlockTopics: []string{"system/#"}}
+	toTest.Ask = answers(output, "no", "yes")
+
+	input, done := processInBackground(toTest)
+	input <- commandReplay + " -fast " + captureFile
+	input <- commandReplay + " -fast " + captureFile
+	assert.Eventually(t, func() bool {
+		return strings.HasSuffix(output.String(), ")\n")
+	}, 1*time.Second, 1*time.Millisecond)
+
+	close(input)
+	<-done
+
+	question := "Do you really want to replay " + captureFile + "? Type \"yes\" to confirm.\n"
+	assert.Equal(t, question+"aborted\n"+question+
+		"replay of "+captureFile+" finished (published: 1, failed: 0, blocked: 1)\n", output.String())
+}
+
+func TestProcessor_Process_replay_invalidArguments(t *testing.T) {
+	usage := "\nUsage: " + commandReplay + " [-i <format>] [-s <factor>|-fast] [-f <filter>] [-t <regex> <replacement>]... <file>\n       " + commandReplay + " stop\n"
+
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{commandReplay + " stop", "no replay is running"},
+		{commandReplay + " -s", "invalid arguments"},
+		{commandReplay + " -s 0 file", "invalid speed factor"},
+		{commandReplay + " -s abc file", "invalid speed factor"},
+		{commandReplay + " -t ( x file", "invalid rewrite rule: error parsing regexp: missing closing ): `(`"},
+		{commandReplay + " -fast", "invalid arguments"},
+		{commandReplay + " -i", "invalid arguments"},
+		{commandReplay + " -i xml file", "invalid capture format"},
+		{commandReplay + " a b", "invalid arguments"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestProcessor_Process_replay_invalidArguments_%d", i), func(t *testing.T) {
+			output := &lockedBuffer{}
+			toTest := NewProcessor(output, nil)
+
+			toTest.Process(filledChan(test.line))
+
+			assert.Equal(t, test.expected+usage, output.String())
+		})
+	}
+
+	output := &lockedBuffer{}
+	toTest := NewProcessor(output, nil)
+	toTest.Process(filledChan(commandReplay))
+	assert.Equal(t, "no replay\n", output.String())
+}
@@ -0,0 +1,270 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultRetainedWindow is the duration of waiting for retained messages
+const defaultRetainedWindow = 1 * time.Second
+
+type retainedCodec struct {
+	marshal   func(interface{}) ([]byte, error)
+	unmarshal func([]byte, interface{}) error
+}
+
+var retainedCodecs = map[string]retainedCodec{
+	".json": {
+		marshal: func(v interface{}) ([]byte, error) {
+			return json.MarshalIndent(v, "", "  ")
+		},
+		unmarshal: json.Unmarshal,
+	},
+	".yml":  {marshal: yaml.Marshal, unmarshal: yaml.Unmarshal},
+	".yaml": {marshal: yaml.Marshal, unmarshal: yaml.Unmarshal},
+}
+
+func getRetainedCodec(file string) (retainedCodec, error) {
+	codec, ok := retainedCodecs[strings.ToLower(filepath.Ext(file))]
+	if !ok {
+		return retainedCodec{}, errors.New("unsupported file format: json, yml or yaml expected")
+	}
+	return codec, nil
+}
+
+func (p *processor) handleRetained(chain Chain) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: "+commandRetained+" [-w <duration>] [-e <file>|-c] <filter>\n       "+commandRetained+" -i <file> [filter]", err.Error())
+		}
+	}()
+
+	filter, exportFile, importFile := "", "", ""
+	window := defaultRetainedWindow
+	clear := false
+
+	for i := 0; i < len(chain.Commands[0].Arguments); i++ {
+		arg := chain.Commands[0].Arguments[i]
+
+		switch arg {
+		case "-c":
+			clear = true
+		case "-w", "-e", "-i":
+			if i+1 >= len(chain.Commands[0].Arguments) {
+				return errors.New("invalid arguments")
+			}
+			value := chain.Commands[0].Arguments[i+1]
+			i++
+
+			switch arg {
+			case "-w":
+				window, err = time.ParseDuration(value)
+				if err != nil || window <= 0 {
+					return errors.New("invalid window duration")
+				}
+			case "-e":
+				exportFile = value
+			case "-i":
+				importFile = value
+			}
+		default:
+			if filter != "" {
+				return errors.New("invalid arguments")
+			}
+			filter = arg
+		}
+	}
+
+	if importFile != "" {
+		if exportFile != "" {
+			return errors.New("import and export can not be combined")
+		}
+		if clear {
+			return errors.New("import and clear can not be combined")
+		}
+		if filter == "" {
+			filter = "#"
+		}
+		return p.importRetained(importFile, filter)
+	}
+
+	if filter == "" {
+		return errors.New("invalid arguments")
+	}
+	if exportFile != "" && clear {
+		return errors.New("export and clear can not be combined")
+	}
+
+	var codec retainedCodec
+	if exportFile != "" {
+		if codec, err = getRetainedCodec(exportFile); err != nil {
+			return err
+		}
+	}
+
+	messages, err := p.collectRetained(filter, window)
+	if err != nil {
+		return err
+	}
+
+	//the table will be written at once: the tabwriter writes each cell separately
+	buf := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TOPIC\tSIZE\tPAYLOAD")
+	for _, message := range messages {
+		payload := message.payload()
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", message.Topic, len(payload), payloadPreview(payload))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if _, err := p.out.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if clear {
+		return p.clearRetained(filter, messages)
+	}
+	if exportFile == "" {
+		return nil
+	}
+
+	content, err := codec.marshal(messages)
+	if err != nil {
+		return fmt.Errorf("unable to encode retained messages: %w", err)
+	}
+	if err := os.WriteFile(exportFile, content, 0644); err != nil {
+		return fmt.Errorf("unable to write export file: %w", err)
+	}
+	p.out.Write([]byte(fmt.Sprintf("exported %d retained messages to %s\n", len(messages), exportFile)))
+
+	return nil
+}
+
+// collectRetained collects all retained messages which are matching the given filter (sorted by topic)
+func (p *processor) collectRetained(filter string, window time.Duration) ([]messageEnvelope, error) {
+	p.mutex.RLock()
+	_, subscribed := p.subscribedTopics[filter]
+	p.mutex.RUnlock()
+
+	if subscribed {
+		//the broker sends the retained messages only for a new subscription (which would replace the existing one)
+		return nil, fmt.Errorf("%s is already subscribed: its retained messages will not be sent again", filter)
+	}
+
+	mutex := sync.Mutex{}
+	collected := map[string]messageEnvelope{}
+
+	//the broker downgrades the messages to the qos of the subscription: the highest one keeps their original qos
+	err := p.subscribeTemporary(filter, 2, window, func(_ mqtt.Client, message mqtt.Message) {
+		//the broker sends the retained messages right after subscribing, all other messages are "live" ones
+		if !message.Retained() {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		collected[message.Topic()] = newMessageEnvelope(message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	messages := make([]messageEnvelope, 0, len(collected))
+	for _, message := range collected {
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Topic < messages[j].Topic
+	})
+	return messages, nil
+}
+
+// importRetained re-publishes all retained messages (which are matching the filter) of the given file
+func (p *processor) importRetained(file, filter string) error {
+	codec, err := getRetainedCodec(file)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("unable to read import file: %w", err)
+	}
+
+	var messages []messageEnvelope
+	if err := codec.unmarshal(content, &messages); err != nil {
+		return fmt.Errorf("unable to decode import file: %w", err)
+	}
+
+	var topics []string
+	var matching []messageEnvelope
+	for _, message := range messages {
+		if !topicMatches(filter, message.Topic) {
+			continue
+		}
+
+		if message.Qos > 2 {
+			return fmt.Errorf("invalid qos level of %s", message.Topic)
+		}
+		topics = append(topics, message.Topic)
+		matching = append(matching, message)
+	}
+
+	action := fmt.Sprintf("import %d retained messages from %s", len(matching), file)
+	if !p.guard(action, true, topics...) {
+		return nil
+	}
+
+	published := 0
+	for _, message := range matching {
+		if token := p.client.Publish(message.Topic, message.Qos, true, message.payload()); !token.Wait() {
+			return token.Error()
+		}
+		published++
+	}
+	p.out.Write([]byte(fmt.Sprintf("published %d retained messages from %s\n", published, file)))
+
+	return nil
+}
+
+// clearRetained clears the given retained messages by publishing an empty retained message to each of their topics
+func (p *processor) clearRetained(filter string, messages []messageEnvelope) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	topics := make([]string, 0, len(messages))
+	for _, message := range messages {
+		topics = append(topics, message.Topic)
+	}
+
+	//clearing is a retained publish too
+	action := fmt.Sprintf("clear %d retained messages of %s", len(messages), filter)
+	if !p.guard(action, true, topics...) {
+		return nil
+	}
+
+	for _, message := range messages {
+		if token := p.client.Publish(message.Topic, message.Qos, true, []byte{}); !token.Wait() {
+			return token.Error()
+		}
+	}
+	p.out.Write([]byte(fmt.Sprintf("cleared %d retained messages of %s\n", len(messages), filter)))
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestProcessor_Process_retained_exportAndImport(t *testing.T) {
+	for _, ext := range []string{".json", ".yml"} {
+		t.Run("TestProcessor_Process_retained_exportAndImport"+ext, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			exportFile := path.Join(os.TempDir(), "retained"+ext)
+			defer os.Remove(exportFile)
+
+			mockToken := mock_io.NewMockToken(ctrl)
+			mockToken.EXPECT().Wait().Return(true).AnyTimes()
+			mockMqtt := mock_io.NewMockClient(ctrl)
+			mockMqtt.EXPECT().Subscribe(gomock.Eq("config/#"), gomock.Eq(byte(2)), gomock.Any()).DoAndReturn(
+				func(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+					callback(nil, testMessage(ctrl, "config/b", "second", qosTestMessage(2), retainedTestMessage))
+					callback(nil, testMessage(ctrl, "config/a", "\xff\xfe", qosTestMessage(1), retainedTestMessage))
+					callback(nil, testMessage(ctrl, "config/live", "live", qosTestMessage(1)))
+					return mockToken
+				})
+			mockMqtt.EXPECT().Unsubscribe(gomock.Eq("config/#")).Return(mockToken)
+
+			output := &bytes.Buffer{}
+			toTest := NewProcessor(output, mockMqtt)
+
+			toTest.Process(filledChan(fmt.Sprintf("%s -w 10ms -e %s config/#", commandRetained, exportFile)))
+
+			assert.Equal(t, `TOPIC     SIZE  PAYLOAD
+config/a  2     <2 bytes>
+config/b  6     "second"
+exported 2 retained messages to `+exportFile+"\n", output.String())
+
+			//re-import the exported file
+			output.Reset()
+			mockMqtt.EXPECT().Publish(gomock.Eq("config/a"), gomock.Eq(byte(1)), gomock.Eq(true), gomock.Eq([]byte{0xff, 0xfe})).Return(mockToken)
+			mockMqtt.EXPECT().Publish(gomock.Eq("config/b"), gomock.Eq(byte(2)), gomock.Eq(true), gomock.Eq([]byte("second"))).Return(mockToken)
+
+			toTest.Process(filledChan(fmt.Sprintf("%s -i %s", commandRetained, exportFile)))
+
+			assert.Equal(t, "published 2 retained messages from "+exportFile+"\n", output.String())
+		})
+	}
+}
+
+func TestProcessor_Process_retained_importFilter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	importFile := path.Join(os.TempDir(), "retained.yaml")
+	defer os.Remove(importFile)
+	assert.NoError(t, os.WriteFile(importFile, []byte(`
+- topic: config/a
+  qos: 0
+  payload: first
+- topic: other/b
+  qos: 0
+  payload: second
+`), 0644))
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Publish(gomock.Eq("config/a"), gomock.Eq(byte(0)), gomock.Eq(true), gomock.Eq([]byte("first"))).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	toTest.Process(filledChan(fmt.Sprintf("%s -i %s config/#", commandRetained, importFile)))
+
+	assert.Equal(t, "published 1 retained messages from "+importFile+"\n", output.String())
+}
+
+func TestProcessor_Process_retained_clear(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).AnyTimes()
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("config/#"), gomock.Eq(byte(2)), gomock.Any()).DoAndReturn(
+		func(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+			callback(nil, testMessage(ctrl, "config/a", "first", qosTestMessage(1), retainedTestMessage))
+			callback(nil, testMessage(ctrl, "config/b", "second", qosTestMessage(1), retainedTestMessage))
+			return mockToken
+		})
+	mockMqtt.EXPECT().Unsubscribe(gomock.Eq("config/#")).Return(mockToken)
+	mockMqtt.EXPECT().Publish(gomock.Eq("config/a"), gomock.Eq(byte(1)), gomock.Eq(true), gomock.Eq([]byte{})).Return(mockToken)
+	mockMqtt.EXPECT().Publish(gomock.Eq("config/b"), gomock.Eq(byte(1)), gomock.Eq(true), gomock.Eq([]byte{})).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+	toTest.Guard = &PublishGuard{Protected: true}
+	toTest.Ask = answers(output, "yes")
+
+	toTest.Process(filledChan(commandRetained + " -w 10ms -c config/#"))
+
+	assert.Equal(t, `TOPIC     SIZE  PAYLOAD
+config/a  5     "first"
+config/b  6     "second"
+Do you really want to clear 2 retained messages of config/#? Type "yes" to confirm.
+cleared 2 retained messages of config/#
+`, output.String())
+}
+
+func TestProcessor_Process_retained_guardedImport(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	importFile := path.Join(os.TempDir(), "retained-guarded.yaml")
+	defer os.Remove(importFile)
+	assert.NoError(t, os.WriteFile(importFile, []byte(`
+- topic: config/a
+  qos: 0
+  payload: first
+- topic: system/b
+  qos: 0
+  payload: second
+`), 0644))
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mock_io.NewMockClient(ctrl))
+	toTest.Guard = &PublishGuard{BlockTopics: []string{"system/#"}}
+
+	//nothing will be published if any topic is blocked
+	toTest.Process(filledChan(fmt.Sprintf("%s -i %s", commandRetained, importFile)))
+
+	assert.Equal(t, "publishing to system/b is blocked\n", output.String())
+}
+
+func TestProcessor_Process_retained_alreadySubscribed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	//the existing subscription must not be replaced
+	mockMqtt := mock_io.NewMockClient(ctrl)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+	toTest.subscribedTopics["config/#"] = subscription{qos: 2, callback: func(mqtt.Client, mqtt.Message) {}}
+
+	toTest.Process(filledChan(commandRetained + " -w 10ms config/#"))
+
+	assert.True(t, strings.HasPrefix(output.String(), "config/# is already subscribed: its retained messages will not be sent again\nUsage: "), output.String())
+}
+
+func TestProcessor_Process_retained_invalidArguments(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{commandRetained, "invalid arguments"},
+		{commandRetained + " -w", "invalid arguments"},
+		{commandRetained + " -w abc a/#", "invalid window duration"},
+		{commandRetained + " a/# b/#", "invalid arguments"},
+		{commandRetained + " -e file.txt a/#", "unsupported file format: json, yml or yaml expected"},
+		{commandRetained + " -e file.json -i file.json", "import and export can not be combined"},
+		{commandRetained + " -c -i file.json", "import and clear can not be combined"},
+		{commandRetained + " -c -e file.json a/#", "export and clear can not be combined"},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestProcessor_Process_retained_invalidArguments_%d", i), func(t *testing.T) {
+			output := &bytes.Buffer{}
+			toTest := NewProcessor(output, nil)
+
+			toTest.Process(filledChan(test.line))
+
+			assert.Equal(t, test.expected+"\nUsage: "+commandRetained+" [-w <duration>] [-e <file>|-c] <filter>\n       "+commandRetained+" -i <file> [filter]\n", output.String())
+		})
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"text/template"
 	"unicode"
 )
 
@@ -18,6 +20,19 @@ type shell struct {
 	macroManager *MacroManager
 
 	targetOut io.Writer
+	//the shell will be written by multiple routines
+	writeMutex sync.Mutex
+	prompt     string
+
+	promptTemplate *template.Template
+	promptData     func() PromptData
+
+	//the pending question (see Ask)
+	answerMutex sync.Mutex
+	answer      chan string
+	stopped     bool
+	//signals a new question: the answer must be read while the lines of a macro are sent
+	asked chan bool
 
 	// wrap function for monkey patching purposes (unit tests)
 	readline func() (string, error)
@@ -30,6 +45,8 @@ func NewShell(prompt, historyFile string,
 	instance = &shell{
 		macroManager: macroManager,
 		targetOut:    os.Stdout,
+		prompt:       prompt,
+		asked:        make(chan bool, 1),
 	}
 
 	qosItem := readline.PcItem("-q",
@@ -43,7 +60,7 @@ func NewShell(prompt, historyFile string,
 		readline.PcItem(commandMacro),
 		readline.PcItem(commandExit),
 		readline.PcItem(commandHelp),
-		readline.PcItem(commandList),
+		readline.PcItem(commandList, readline.PcItem("-l")),
 		readline.PcItem(commandPub,
 			readline.PcItem("-r",
 				qosItem,
@@ -54,8 +71,72 @@ func NewShell(prompt, historyFile string,
 				readline.PcItem("2", readline.PcItem("-r")),
 			),
 		),
-		readline.PcItem(commandSub, qosItem),
+		readline.PcItem(commandSub,
+			qosItem,
+			readline.PcItem("-restart",
+				readline.PcItem("never"),
+				readline.PcItem("on-failure"),
+				readline.PcItem("always"),
+			),
+			readline.PcItem("-f",
+				readline.PcItem("newline"),
+				readline.PcItem("nul"),
+				readline.PcItem("length"),
+				readline.PcItem("json"),
+			),
+		),
 		readline.PcItem(commandUnsub, readline.PcItemDynamic(unsubCompletionClb)),
+		readline.PcItem(commandFwd,
+			qosItem,
+			readline.PcItem("-r"),
+			readline.PcItem("-nr"),
+			readline.PcItem("-t"),
+			readline.PcItem("-b"),
+		),
+		readline.PcItem(commandJobs, readline.PcItem("-c")),
+		readline.PcItem(commandKill),
+		readline.PcItem(commandTree, readline.PcItem("-w")),
+		readline.PcItem(commandRetained,
+			readline.PcItem("-w"),
+			readline.PcItem("-e"),
+			readline.PcItem("-i"),
+			readline.PcItem("-c"),
+		),
+		readline.PcItem(commandLast),
+		readline.PcItem(commandDiff),
+		readline.PcItem(commandStats, readline.PcItem("-r")),
+		readline.PcItem(commandStatus),
+		readline.PcItem(commandPing,
+			readline.PcItem("-n"),
+			qosItem,
+			readline.PcItem("-i"),
+			readline.PcItem("-t"),
+		),
+		readline.PcItem(commandRecord,
+			readline.PcItem("start",
+				readline.PcItem("-f",
+					readline.PcItem(captureFormatJson),
+					readline.PcItem(captureFormatBinary),
+					readline.PcItem(captureFormatMosquitto),
+					readline.PcItem(captureFormatCsv),
+				),
+			),
+			readline.PcItem("stop"),
+		),
+		readline.PcItem(commandReplay,
+			readline.PcItem("-i",
+				readline.PcItem(captureFormatJson),
+				readline.PcItem(captureFormatBinary),
+				readline.PcItem(captureFormatMosquitto),
+				readline.PcItem(captureFormatCsv),
+				readline.PcItem(captureFormatPcap),
+			),
+			readline.PcItem("-s"),
+			readline.PcItem("-fast"),
+			readline.PcItem("-f"),
+			readline.PcItem("-t"),
+			readline.PcItem("stop"),
+		),
 	)
 
 	instance.rlInstance, err = readline.NewEx(&readline.Config{
@@ -98,25 +179,24 @@ func (s *shell) Start() chan string {
 	go func() {
 		defer close(lineChannel)
 		defer s.Close()
+		defer s.stopAnswering()
 
 		for {
 			line, err := s.readline()
 			if err != nil {
 				if err == readline.ErrInterrupt {
+					//an interrupt cancels the pending question
+					s.giveAnswer("")
 					continue
 				}
 				break
 			}
 
-			//remove non-printable characters to prevent possible strange bugs ;)
-			line = strings.Map(func(r rune) rune {
-				if unicode.IsGraphic(r) {
-					return r
-				}
-				return -1
-			}, line)
-			line = strings.TrimSpace(line)
+			line = purgeLine(line)
 
+			if s.giveAnswer(line) {
+				continue
+			}
 			if line == commandExit {
 				break
 			}
@@ -141,7 +221,7 @@ func (s *shell) Start() chan string {
 			}
 
 			for _, line := range lines {
-				lineChannel <- line
+				s.send(lineChannel, line)
 			}
 		}
 	}()
@@ -149,6 +229,40 @@ func (s *shell) Start() chan string {
 	return lineChannel
 }
 
+// purgeLine removes non-printable characters to prevent possible strange bugs ;)
+func purgeLine(line string) string {
+	line = strings.Map(func(r rune) rune {
+		if unicode.IsGraphic(r) {
+			return r
+		}
+		return -1
+	}, line)
+	return strings.TrimSpace(line)
+}
+
+// send passes the line to the channel. If a line before asks a question (for example the first line of a macro) the
+// answer will be read meanwhile: otherwise the question could never be answered.
+func (s *shell) send(lineChannel chan string, line string) {
+	for {
+		select {
+		case lineChannel <- line:
+			return
+		case <-s.asked:
+			if !s.isAsking() {
+				//the question was already answered by the regular input
+				continue
+			}
+
+			answer, err := s.readline()
+			if err != nil {
+				//an interrupt (or the end of input) cancels the pending question
+				answer = ""
+			}
+			s.giveAnswer(purgeLine(answer))
+		}
+	}
+}
+
 func (s *shell) readMultilines(line string) string {
 	sb := strings.Builder{}
 	sb.WriteString(line)
@@ -176,11 +290,86 @@ func (s *shell) readMultilines(line string) string {
 	return sb.String()
 }
 
+// Ask writes the question and waits for the next input line of the user. The answer will be returned without any
+// interpretation (such like the macro resolution). So the user can be asked while a command is processed. It returns
+// false if the shell is closed.
+func (s *shell) Ask(question string) (string, bool) {
+	answer := make(chan string, 1)
+
+	s.answerMutex.Lock()
+	if s.stopped {
+		s.answerMutex.Unlock()
+		return "", false
+	}
+	s.answer = answer
+	s.answerMutex.Unlock()
+
+	select {
+	case s.asked <- true:
+	default:
+		//there is already a signal
+	}
+
+	//the question must be written after the answer is expected: otherwise a fast answer could be interpreted as command
+	s.Write([]byte(question + "\n"))
+
+	line, ok := <-answer
+	return line, ok
+}
+
+// giveAnswer passes the line to the pending question. It returns false if there is no pending question.
+func (s *shell) giveAnswer(line string) bool {
+	s.answerMutex.Lock()
+	defer s.answerMutex.Unlock()
+
+	if s.answer == nil {
+		return false
+	}
+	s.answer <- line
+	s.answer = nil
+	return true
+}
+
+func (s *shell) isAsking() bool {
+	s.answerMutex.Lock()
+	defer s.answerMutex.Unlock()
+
+	return s.answer != nil
+}
+
+func (s *shell) stopAnswering() {
+	s.answerMutex.Lock()
+	defer s.answerMutex.Unlock()
+
+	s.stopped = true
+	if s.answer != nil {
+		close(s.answer)
+		s.answer = nil
+	}
+}
+
+// SetPrompt replaces the prompt of the shell
+func (s *shell) SetPrompt(prompt string) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if s.prompt == prompt {
+		return
+	}
+	s.prompt = prompt
+	s.rlInstance.SetPrompt(prompt)
+	fmt.Fprint(s.targetOut, "\r\033[2K")
+	s.rlInstance.Refresh()
+}
+
 func (s *shell) Close() error {
 	return s.rlInstance.Close()
 }
 
 func (s *shell) Write(b []byte) (n int, err error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
 	defer func() {
 		if s.rlInstance != nil {
 			s.rlInstance.Refresh()
@@ -10,6 +10,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestShell_Write(t *testing.T) {
@@ -63,6 +64,18 @@ func TestNewShell(t *testing.T) {
 		commandPub + " ",
 		commandSub + " ",
 		commandUnsub + " ",
+		commandFwd + " ",
+		commandJobs + " ",
+		commandKill + " ",
+		commandTree + " ",
+		commandRetained + " ",
+		commandLast + " ",
+		commandDiff + " ",
+		commandStats + " ",
+		commandStatus + " ",
+		commandPing + " ",
+		commandRecord + " ",
+		commandReplay + " ",
 	}, rc(suggestions), "the default commands and macros should be suggested")
 
 	suggestions, _ = toTest.rlInstance.Config.AutoComplete.Do([]rune("test "), 5)
@@ -84,7 +97,10 @@ func TestNewShell(t *testing.T) {
 	assert.Equal(t, []string{"-q "}, rc(suggestions), "the qos flag should be suggested")
 
 	suggestions, _ = toTest.rlInstance.Config.AutoComplete.Do([]rune(commandSub+" "), len(commandSub)+1)
-	assert.Equal(t, []string{"-q "}, rc(suggestions), "the qos flag should be suggested")
+	assert.Equal(t, []string{"-q ", "-restart ", "-f "}, rc(suggestions), "the sub flags should be suggested")
+
+	suggestions, _ = toTest.rlInstance.Config.AutoComplete.Do([]rune(commandSub+" -restart "), len(commandSub)+10)
+	assert.Equal(t, []string{"never ", "on-failure ", "always "}, rc(suggestions), "the restart policies should be suggested")
 
 	suggestions, _ = toTest.rlInstance.Config.AutoComplete.Do([]rune(commandSub+" -q "), len(commandSub)+4)
 	assert.Equal(t, []string{"0 ", "1 ", "2 "}, rc(suggestions), "the qos levels should be suggested")
@@ -266,3 +282,96 @@ func TestShell_Start_execMacro(t *testing.T) {
 	_, ok := <-lines
 	assert.True(t, ok)
 }
+
+func TestShell_Ask(t *testing.T) {
+	output := &bytes.Buffer{}
+	toTest, _ := NewShell("PROMPT>", "/tmp/history", &MacroManager{Output: output}, nil)
+	shellOutput := &bytes.Buffer{}
+	toTest.targetOut = shellOutput
+
+	input := make(chan string)
+	toTest.readline = func() (string, error) {
+		line, ok := <-input
+		if !ok {
+			return "", errors.New("EOF")
+		}
+		return line, nil
+	}
+
+	lines := toTest.Start()
+
+	answer := make(chan string)
+	go func() {
+		line, _ := toTest.Ask("Really?")
+		answer <- line
+	}()
+	assert.Eventually(t, func() bool {
+		toTest.answerMutex.Lock()
+		defer toTest.answerMutex.Unlock()
+
+		return toTest.answer != nil
+	}, time.Second, time.Millisecond)
+
+	input <- "yes"
+	assert.Equal(t, "yes", <-answer, "the answer should not be resolved as macro")
+	assert.Equal(t, "", output.String())
+	assert.Contains(t, shellOutput.String(), "Really?\n")
+
+	input <- commandList
+	assert.Equal(t, commandList, <-lines)
+
+	close(input)
+	_, ok := <-lines
+	assert.False(t, ok)
+
+	_, ok = toTest.Ask("Really?")
+	assert.False(t, ok, "a closed shell can not be asked")
+}
+
+func TestShell_Ask_multilineMacro(t *testing.T) {
+	macroManager := &MacroManager{
+		MacroSpecs: map[string]config.Macro{
+			"Test": {
+				Commands: []string{"pub -r a/b c", "second line"},
+			},
+		},
+	}
+	toTest, _ := NewShell("PROMPT>", "/tmp/history", macroManager, nil)
+	toTest.targetOut = &bytes.Buffer{}
+
+	input := make(chan string, 2)
+	toTest.readline = func() (string, error) {
+		line, ok := <-input
+		if !ok {
+			return "", errors.New("EOF")
+		}
+		return line, nil
+	}
+
+	lines := toTest.Start()
+	input <- "Test"
+	assert.Equal(t, "pub -r a/b c", <-lines)
+
+	//the first line asks while the second line of the macro is waiting
+	answer := make(chan string)
+	go func() {
+		line, _ := toTest.Ask("Really?")
+		answer <- line
+	}()
+	input <- "yes"
+
+	select {
+	case line := <-answer:
+		assert.Equal(t, "yes", line)
+	case <-time.After(time.Second):
+		t.Fatal("the question should be answered")
+	}
+	assert.Equal(t, "second line", <-lines)
+
+	input <- commandList
+	assert.Equal(t, commandList, <-lines)
+
+	close(input)
+	_, ok := <-lines
+	assert.False(t, ok)
+}
@@ -0,0 +1,259 @@
+package io
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// rateWindow is the count of seconds which will be used for the calculation of the long term rate
+const rateWindow = 60
+
+// messageStats contains the statistics of the messages of one subscription (or one topic)
+type messageStats struct {
+	count      uint64
+	bytes      uint64
+	min        int
+	max        int
+	duplicates uint64
+	lastSeen   time.Time
+
+	//ring buffer which contains the count of messages per second
+	buckets     [rateWindow]uint64
+	bucketTimes [rateWindow]int64
+}
+
+func (s *messageStats) add(size int, duplicate bool, now time.Time) {
+	if s.count == 0 || size < s.min {
+		s.min = size
+	}
+	if size > s.max {
+		s.max = size
+	}
+	s.count++
+	s.bytes += uint64(size)
+	s.lastSeen = now
+	if duplicate {
+		s.duplicates++
+	}
+
+	second := now.Unix()
+	i := second % rateWindow
+	if s.bucketTimes[i] != second {
+		s.bucketTimes[i] = second
+		s.buckets[i] = 0
+	}
+	s.buckets[i]++
+}
+
+// rates returns the count of messages per second of the last (complete) second and the average of the last minute
+func (s *messageStats) rates(now time.Time) (float64, float64) {
+	second := now.Unix()
+
+	var lastSecond, lastMinute uint64
+	for i := 0; i < rateWindow; i++ {
+		age := second - s.bucketTimes[i]
+		if age < 1 || age > rateWindow {
+			//the current second is not complete yet
+			continue
+		}
+		if age == 1 {
+			lastSecond = s.buckets[i]
+		}
+		lastMinute += s.buckets[i]
+	}
+
+	return float64(lastSecond), float64(lastMinute) / rateWindow
+}
+
+// statistics collects the message statistics per subscription and per topic. If there are too many topics, the
+// statistics of the least recently seen topics will be removed (like in the topic registry).
+type statistics struct {
+	mutex         sync.Mutex
+	subscriptions map[string]*messageStats
+	topics        map[string]*messageStats
+	limit         int
+
+	//the topics ordered by their last message (the least recently seen topic first)
+	order    *list.List
+	elements map[string]*list.Element
+
+	//a message will be passed to each handler of all matching subscriptions: but it should be counted only once per topic
+	last mqtt.Message
+}
+
+func newStatistics() *statistics {
+	return &statistics{
+		subscriptions: map[string]*messageStats{},
+		topics:        map[string]*messageStats{},
+		limit:         maxRegistryTopics,
+		order:         list.New(),
+		elements:      map[string]*list.Element{},
+	}
+}
+
+func (s *statistics) add(subscription string, message mqtt.Message) {
+	now := timeNow()
+	size := len(message.Payload())
+	duplicate := message.Duplicate()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, ok := s.subscriptions[subscription]
+	if !ok {
+		entry = &messageStats{}
+		s.subscriptions[subscription] = entry
+	}
+	entry.add(size, duplicate, now)
+
+	if s.last == message {
+		return
+	}
+	s.last = message
+
+	topic := message.Topic()
+	entry, ok = s.topics[topic]
+	if ok {
+		s.order.MoveToBack(s.elements[topic])
+	} else {
+		if len(s.topics) >= s.limit {
+			oldest := s.order.Remove(s.order.Front()).(string)
+			delete(s.topics, oldest)
+			delete(s.elements, oldest)
+		}
+
+		entry = &messageStats{}
+		s.topics[topic] = entry
+		s.elements[topic] = s.order.PushBack(topic)
+	}
+	entry.add(size, duplicate, now)
+}
+
+func (s *statistics) remove(subscription string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.subscriptions, subscription)
+}
+
+// write writes the statistics of all subscriptions and all topics which are matching the given filter
+func (s *statistics) write(out io.Writer, filter string) error {
+	now := timeNow()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	//the table will be written at once so that it will not be interleaved with other output
+	buf := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	for i, entry := range []struct {
+		name     string
+		stats    map[string]*messageStats
+		filtered bool
+	}{{"SUBSCRIPTION", s.subscriptions, false}, {"TOPIC", s.topics, true}} {
+		names := make([]string, 0, len(entry.stats))
+		for name := range entry.stats {
+			if !entry.filtered || topicMatches(filter, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		if i > 0 {
+			fmt.Fprintln(tw, "")
+		}
+		fmt.Fprintln(tw, entry.name+"\tCOUNT\tRATE(1s)\tRATE(1m)\tBYTES\tMIN\tMAX\tAVG\tLAST SEEN\tDUPLICATES")
+		for _, name := range names {
+			stats := entry.stats[name]
+			rate1s, rate1m := stats.rates(now)
+
+			fmt.Fprintf(tw, "%s\t%d\t%.1f/s\t%.1f/s\t%d\t%d\t%d\t%d\t%s ago\t%d\n",
+				name, stats.count, rate1s, rate1m, stats.bytes, stats.min, stats.max, stats.bytes/stats.count,
+				now.Sub(stats.lastSeen).Truncate(time.Millisecond), stats.duplicates)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+func (p *processor) handleStats(chain Chain) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: "+commandStats+" [-r <interval>] [filter]", err.Error())
+		}
+	}()
+
+	filter := ""
+	refresh := time.Duration(-1)
+
+	for i := 0; i < len(chain.Commands[0].Arguments); i++ {
+		arg := chain.Commands[0].Arguments[i]
+
+		switch {
+		case arg == "-r":
+			if i+1 >= len(chain.Commands[0].Arguments) {
+				return errors.New("invalid arguments")
+			}
+			refresh, err = time.ParseDuration(chain.Commands[0].Arguments[i+1])
+			if err != nil || refresh < 0 {
+				return errors.New("invalid refresh interval")
+			}
+			i++
+		case filter == "":
+			filter = arg
+		default:
+			return errors.New("invalid arguments")
+		}
+	}
+	if filter == "" {
+		filter = "#"
+	}
+
+	if refresh < 0 {
+		return p.stats.write(p.out, filter)
+	}
+
+	//the live refresh will be done in background (until it will be stopped by an interval of zero)
+	p.stopStatsRefresh()
+	if refresh == 0 {
+		return nil
+	}
+
+	done := make(chan interface{})
+	p.statsRefresh = done
+	go func() {
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+
+		for {
+			p.stats.write(p.out, filter)
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (p *processor) stopStatsRefresh() {
+	if p.statsRefresh != nil {
+		close(p.statsRefresh)
+		p.statsRefresh = nil
+	}
+}
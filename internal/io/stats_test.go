@@ -0,0 +1,176 @@
+package io
+
+import (
+	"bytes"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMessageStats_rates(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	toTest := &messageStats{}
+
+	//30 messages per second for 10 seconds
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 30; j++ {
+			toTest.add(1, false, now.Add(time.Duration(i)*time.Second))
+		}
+	}
+
+	rate1s, rate1m := toTest.rates(now.Add(10 * time.Second))
+	assert.Equal(t, 30.0, rate1s)
+	assert.Equal(t, 5.0, rate1m)
+
+	rate1s, rate1m = toTest.rates(now.Add(20 * time.Second))
+	assert.Equal(t, 0.0, rate1s)
+	assert.Equal(t, 5.0, rate1m)
+
+	rate1s, rate1m = toTest.rates(now.Add(2 * time.Minute))
+	assert.Equal(t, 0.0, rate1s)
+	assert.Equal(t, 0.0, rate1m)
+}
+
+func TestProcessor_Process_stats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	otn := timeNow
+	defer func() {
+		timeNow = otn
+	}()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Unsubscribe(gomock.Eq("b/#")).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	handlerA := toTest.observe("a/#", func(mqtt.Client, mqtt.Message) {})
+	handlerB := toTest.observe("b/#", func(mqtt.Client, mqtt.Message) {})
+	handlerA(nil, testMessage(ctrl, "a/first", "1"))
+	handlerA(nil, testMessage(ctrl, "a/first", "123", duplicateTestMessage))
+	handlerA(nil, testMessage(ctrl, "a/second", "12345"))
+	handlerB(nil, testMessage(ctrl, "b/first", "12"))
+	now = now.Add(1500 * time.Millisecond)
+
+	toTest.Process(filledChan(commandStats+" a/first", "unsub b/#", commandStats))
+
+	assert.Equal(t, `SUBSCRIPTION  COUNT  RATE(1s)  RATE(1m)  BYTES  MIN  MAX  AVG  LAST SEEN  DUPLICATES
+a/#           3      3.0/s     0.1/s     9      1    5    3    1.5s ago   1
+b/#           1      1.0/s     0.0/s     2      2    2    2    1.5s ago   0
+
+TOPIC    COUNT  RATE(1s)  RATE(1m)  BYTES  MIN  MAX  AVG  LAST SEEN  DUPLICATES
+a/first  2      2.0/s     0.0/s     4      1    3    2    1.5s ago   1
+SUBSCRIPTION  COUNT  RATE(1s)  RATE(1m)  BYTES  MIN  MAX  AVG  LAST SEEN  DUPLICATES
+a/#           3      3.0/s     0.1/s     9      1    5    3    1.5s ago   1
+
+TOPIC     COUNT  RATE(1s)  RATE(1m)  BYTES  MIN  MAX  AVG  LAST SEEN  DUPLICATES
+a/first   2      2.0/s     0.0/s     4      1    3    2    1.5s ago   1
+a/second  1      1.0/s     0.0/s     5      5    5    5    1.5s ago   0
+b/first   1      1.0/s     0.0/s     2      2    2    2    1.5s ago   0
+`, output.String())
+}
+
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (l *lockedBuffer) Write(b []byte) (int, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buf.Write(b)
+}
+
+func (l *lockedBuffer) String() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.buf.String()
+}
+
+func TestStatistics_add_overlappingSubscriptions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	toTest := newStatistics()
+
+	//the same message will be passed to both subscriptions
+	message := testMessage(ctrl, "a/topic", "1")
+	toTest.add("a/#", message)
+	toTest.add("a/topic", message)
+	toTest.add("a/#", testMessage(ctrl, "a/topic", "2"))
+
+	assert.Equal(t, uint64(2), toTest.subscriptions["a/#"].count)
+	assert.Equal(t, uint64(1), toTest.subscriptions["a/topic"].count)
+	assert.Equal(t, uint64(2), toTest.topics["a/topic"].count, "each message should be counted only once per topic")
+}
+
+func TestStatistics_topicLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	toTest := newStatistics()
+	toTest.limit = 2
+
+	toTest.add("#", testMessage(ctrl, "a", "1"))
+	toTest.add("#", testMessage(ctrl, "b", "1"))
+	toTest.add("#", testMessage(ctrl, "a", "2"))
+	toTest.add("#", testMessage(ctrl, "c", "1"))
+
+	assert.Len(t, toTest.topics, 2)
+	assert.Contains(t, toTest.topics, "a")
+	assert.Contains(t, toTest.topics, "c")
+	assert.NotContains(t, toTest.topics, "b", "the least recently seen topic should be removed")
+	assert.Equal(t, uint64(2), toTest.topics["a"].count)
+	assert.Equal(t, uint64(4), toTest.subscriptions["#"].count)
+}
+
+func TestProcessor_Process_stats_refresh(t *testing.T) {
+	output := &lockedBuffer{}
+	toTest := NewProcessor(output, nil)
+
+	input := make(chan string)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		toTest.Process(input)
+	}()
+
+	input <- commandStats + " -r 10ms"
+	assert.Eventually(t, func() bool {
+		return strings.Count(output.String(), "SUBSCRIPTION") >= 3
+	}, 1*time.Second, 5*time.Millisecond)
+
+	input <- commandStats + " -r 0"
+	close(input)
+	<-done
+
+	//a running refresh could be still in progress
+	time.Sleep(20 * time.Millisecond)
+	count := strings.Count(output.String(), "SUBSCRIPTION")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, count, strings.Count(output.String(), "SUBSCRIPTION"), "the refresh should be stopped")
+}
+
+func TestProcessor_Process_stats_invalidArguments(t *testing.T) {
+	for _, line := range []string{commandStats + " -r", commandStats + " -r abc", commandStats + " a/# b/#"} {
+		output := &bytes.Buffer{}
+		toTest := NewProcessor(output, nil)
+
+		toTest.Process(filledChan(line))
+
+		assert.Contains(t, output.String(), "\nUsage: "+commandStats+" [-r <interval>] [filter]\n", line)
+	}
+}
@@ -0,0 +1,233 @@
+package io
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"io"
+	"net/url"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	connectionStateConnecting   = "connecting"
+	connectionStateConnected    = "connected"
+	connectionStateReconnecting = "reconnecting"
+	connectionStateDisconnected = "disconnected"
+)
+
+// ConnectionStatus tracks the state of the mqtt connection and reports each change to the output. It will be fed by
+// the handlers of the mqtt client (see Observe).
+type ConnectionStatus struct {
+	out io.Writer
+
+	mutex          sync.Mutex
+	listener       interface{ OnMqttReconnect() }
+	onChange       func()
+	state          string
+	broker         string
+	connectedSince time.Time
+	connects       int
+	attempts       int
+	lastError      error
+	lastErrorTime  time.Time
+}
+
+func NewConnectionStatus(out io.Writer) *ConnectionStatus {
+	return &ConnectionStatus{
+		out:   out,
+		state: connectionStateConnecting,
+	}
+}
+
+// Observe registers the handlers of the connection status. Existing handlers of the options will be replaced.
+func (c *ConnectionStatus) Observe(opts *mqtt.ClientOptions) {
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		c.onConnectionAttempt(broker.String())
+		return tlsCfg
+	})
+	opts.SetOnConnectHandler(func(mqtt.Client) {
+		c.onConnect()
+	})
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		reader := client.OptionsReader()
+		c.onConnectionLost(err, reader.AutoReconnect())
+	})
+	opts.SetReconnectingHandler(func(mqtt.Client, *mqtt.ClientOptions) {
+		c.onReconnecting()
+	})
+}
+
+// SetReconnectListener sets the listener which will be called after each (re-)connect
+func (c *ConnectionStatus) SetReconnectListener(listener interface{ OnMqttReconnect() }) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.listener = listener
+}
+
+// OnChange sets the function which will be called after each (re-)connect and connection loss
+func (c *ConnectionStatus) OnChange(onChange func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.onChange = onChange
+}
+
+// Broker returns the broker which is connected (or which was tried at last)
+func (c *ConnectionStatus) Broker() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.broker
+}
+
+// State returns the current state of the connection
+func (c *ConnectionStatus) State() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.state
+}
+
+// Connected marks the connection as established. It should be called after the connect token is completed: the
+// connect handler of the client will be called asynchronously, so the state could be outdated until then.
+func (c *ConnectionStatus) Connected() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.state == connectionStateConnecting {
+		c.state = connectionStateConnected
+		c.connectedSince = timeNow()
+	}
+}
+
+func (c *ConnectionStatus) onConnectionAttempt(broker string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.broker = broker
+}
+
+func (c *ConnectionStatus) onConnect() {
+	c.mutex.Lock()
+	if c.state != connectionStateConnected {
+		c.state = connectionStateConnected
+		c.connectedSince = timeNow()
+	}
+	c.connects++
+	c.attempts = 0
+	reconnected := c.connects > 1
+	listener := c.listener
+	onChange := c.onChange
+	c.mutex.Unlock()
+
+	if reconnected {
+		c.out.Write([]byte("Successfully re-connected to mqtt broker.\n"))
+	} else {
+		c.out.Write([]byte("Successfully connected to mqtt broker.\n"))
+	}
+
+	//do not hold the lock while calling the listener: it will use the client
+	if listener != nil {
+		listener.OnMqttReconnect()
+	}
+	if onChange != nil {
+		onChange()
+	}
+}
+
+func (c *ConnectionStatus) onConnectionLost(err error, reconnect bool) {
+	c.mutex.Lock()
+	c.lastError = err
+	c.lastErrorTime = timeNow()
+	if reconnect {
+		c.state = connectionStateReconnecting
+	} else {
+		c.state = connectionStateDisconnected
+	}
+	onChange := c.onChange
+	c.mutex.Unlock()
+
+	if reconnect {
+		c.out.Write([]byte(fmt.Sprintf("Connection to broker lost: %s. Reconnecting...\n", err)))
+	} else {
+		c.out.Write([]byte(fmt.Sprintf("Connection to broker lost: %s.\n", err)))
+	}
+	if onChange != nil {
+		onChange()
+	}
+}
+
+func (c *ConnectionStatus) onReconnecting() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.state = connectionStateReconnecting
+	c.attempts++
+}
+
+// write writes the current connection status as table
+func (c *ConnectionStatus) write(out io.Writer) error {
+	now := timeNow()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state := c.state
+	if c.state == connectionStateReconnecting && c.attempts > 0 {
+		state = fmt.Sprintf("%s (attempt %d)", c.state, c.attempts)
+	}
+	uptime := "-"
+	if c.state == connectionStateConnected {
+		uptime = now.Sub(c.connectedSince).Truncate(time.Second).String()
+	}
+	reconnects := 0
+	if c.connects > 1 {
+		reconnects = c.connects - 1
+	}
+	broker := "-"
+	if c.broker != "" {
+		broker = c.broker
+	}
+	lastError := "-"
+	if c.lastError != nil {
+		lastError = fmt.Sprintf("%s (%s ago)", c.lastError, now.Sub(c.lastErrorTime).Truncate(time.Second))
+	}
+
+	//the table will be written at once so that it will not be interleaved with other output
+	buf := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "STATE\t%s\n", state)
+	fmt.Fprintf(tw, "BROKER\t%s\n", broker)
+	fmt.Fprintf(tw, "UPTIME\t%s\n", uptime)
+	fmt.Fprintf(tw, "RECONNECTS\t%d\n", reconnects)
+	fmt.Fprintf(tw, "LAST ERROR\t%s\n", lastError)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+func (p *processor) handleStatus(chain Chain) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: "+commandStatus, err.Error())
+		}
+	}()
+
+	if len(chain.Commands[0].Arguments) > 0 {
+		return errors.New("invalid arguments")
+	}
+	if p.Status == nil {
+		return errors.New("the connection status is not available")
+	}
+
+	return p.Status.write(p.out)
+}
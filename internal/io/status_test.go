@@ -0,0 +1,116 @@
+package io
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type testReconnectListener struct {
+	calls int
+}
+
+func (t *testReconnectListener) OnMqttReconnect() {
+	t.calls++
+}
+
+func TestConnectionStatus(t *testing.T) {
+	otn := timeNow
+	defer func() {
+		timeNow = otn
+	}()
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+
+	output := &bytes.Buffer{}
+	listener := &testReconnectListener{}
+	toTest := NewConnectionStatus(output)
+	toTest.SetReconnectListener(listener)
+	changes := 0
+	toTest.OnChange(func() {
+		changes++
+	})
+
+	status := &bytes.Buffer{}
+	assert.NoError(t, toTest.write(status))
+	assert.Equal(t, `STATE       connecting
+BROKER      -
+UPTIME      -
+RECONNECTS  0
+LAST ERROR  -
+`, status.String())
+
+	toTest.onConnectionAttempt("tcp://127.0.0.1:1883")
+	toTest.Connected()
+	now = now.Add(90 * time.Second)
+	toTest.onConnect() //the handler is called asynchronously
+
+	status.Reset()
+	assert.NoError(t, toTest.write(status))
+	assert.Equal(t, `STATE       connected
+BROKER      tcp://127.0.0.1:1883
+UPTIME      1m30s
+RECONNECTS  0
+LAST ERROR  -
+`, status.String())
+
+	toTest.onConnectionLost(errors.New("EOF"), true)
+	toTest.onReconnecting()
+	toTest.onReconnecting()
+	now = now.Add(3 * time.Second)
+
+	status.Reset()
+	assert.NoError(t, toTest.write(status))
+	assert.Equal(t, `STATE       reconnecting (attempt 2)
+BROKER      tcp://127.0.0.1:1883
+UPTIME      -
+RECONNECTS  0
+LAST ERROR  EOF (3s ago)
+`, status.String())
+
+	toTest.onConnect()
+	toTest.onConnectionLost(errors.New("connection reset"), false)
+
+	status.Reset()
+	assert.NoError(t, toTest.write(status))
+	assert.Equal(t, `STATE       disconnected
+BROKER      tcp://127.0.0.1:1883
+UPTIME      -
+RECONNECTS  1
+LAST ERROR  connection reset (0s ago)
+`, status.String())
+
+	assert.Equal(t, 2, listener.calls, "the listener should be called after each connect")
+	assert.Equal(t, 4, changes, "each connect and connection loss should be reported")
+	assert.Equal(t, connectionStateDisconnected, toTest.State())
+	assert.Equal(t, "tcp://127.0.0.1:1883", toTest.Broker())
+	assert.Equal(t, `Successfully connected to mqtt broker.
+Connection to broker lost: EOF. Reconnecting...
+Successfully re-connected to mqtt broker.
+Connection to broker lost: connection reset.
+`, output.String())
+}
+
+func TestProcessor_Process_status(t *testing.T) {
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	toTest.Process(filledChan(commandStatus))
+	assert.Equal(t, "the connection status is not available\nUsage: .status\n", output.String())
+
+	output.Reset()
+	toTest.Status = NewConnectionStatus(&bytes.Buffer{})
+	toTest.Process(filledChan(commandStatus+" -l", commandStatus))
+	assert.Equal(t, `invalid arguments
+Usage: .status
+STATE       connecting
+BROKER      -
+UPTIME      -
+RECONNECTS  0
+LAST ERROR  -
+`, output.String())
+}
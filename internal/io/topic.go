@@ -0,0 +1,102 @@
+package io
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rainu/mqtt-shell/internal/topic"
+	"sync"
+	"time"
+)
+
+// topicMatches checks if the given topic matches the given subscription filter (incl. wildcards)
+var topicMatches = topic.Matches
+
+// publishNoWait publishes the given message without waiting for its acknowledgement.
+// Only errors which are known immediately will be returned.
+func publishNoWait(client mqtt.Client, topic string, qos byte, retained bool, payload interface{}) error {
+	token := client.Publish(topic, qos, retained, payload)
+
+	//waiting inside a message handler would block the message processing of the mqtt client
+	if tokenDone(token) {
+		return token.Error()
+	}
+	return nil
+}
+
+// tokenDone checks if the token is completed (without waiting for it)
+func tokenDone(token mqtt.Token) bool {
+	select {
+	case <-token.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// subscriptionTaps contains the additional handlers of the existing subscriptions. They will be called by the
+// observer of the subscription (see processor.observe).
+type subscriptionTaps struct {
+	mutex    sync.RWMutex
+	handlers map[string]map[*mqtt.MessageHandler]bool
+}
+
+func newSubscriptionTaps() *subscriptionTaps {
+	return &subscriptionTaps{handlers: map[string]map[*mqtt.MessageHandler]bool{}}
+}
+
+// add adds the handler to the given subscription. The returned function removes it again.
+func (t *subscriptionTaps) add(subscription string, handler mqtt.MessageHandler) func() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := &handler
+	if t.handlers[subscription] == nil {
+		t.handlers[subscription] = map[*mqtt.MessageHandler]bool{}
+	}
+	t.handlers[subscription][key] = true
+
+	return func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+
+		delete(t.handlers[subscription], key)
+		if len(t.handlers[subscription]) == 0 {
+			delete(t.handlers, subscription)
+		}
+	}
+}
+
+func (t *subscriptionTaps) dispatch(subscription string, client mqtt.Client, message mqtt.Message) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	for handler := range t.handlers[subscription] {
+		(*handler)(client, message)
+	}
+}
+
+// subscribeTemporary subscribes the filter with the given qos for the given duration. If the filter is already
+// subscribed, the existing subscription will be tapped instead: a new subscription would replace it. In that case the
+// broker will not send the retained messages again.
+func (p *processor) subscribeTemporary(filter string, qos byte, window time.Duration, handler mqtt.MessageHandler) error {
+	p.mutex.RLock()
+	_, subscribed := p.subscribedTopics[filter]
+	p.mutex.RUnlock()
+
+	if subscribed {
+		remove := p.taps.add(filter, handler)
+		time.Sleep(window)
+		remove()
+		return nil
+	}
+
+	if token := p.client.Subscribe(filter, qos, handler); !token.Wait() {
+		return token.Error()
+	}
+
+	time.Sleep(window)
+
+	if token := p.client.Unsubscribe(filter); !token.Wait() {
+		return token.Error()
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package io
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// topicPreviewLength is the maximum count of characters of the payload preview in the topic tree
+const topicPreviewLength = 40
+
+// maxRegistryTopics is the maximum count of topics which will be kept in the registry
+const maxRegistryTopics = 10000
+
+// topicStats contains the information about all messages which were seen on one topic
+type topicStats struct {
+	count    uint64
+	last     []byte
+	lastSeen time.Time
+	retained bool
+
+	//the payload of the message before the last one (only valid if count > 1)
+	previous []byte
+}
+
+// topicRegistry collects the information about all seen topics (including the last value of each topic). If there are
+// too many topics, the least recently seen topics will be removed.
+type topicRegistry struct {
+	mutex  sync.RWMutex
+	topics map[string]*topicStats
+	limit  int
+
+	//the topics ordered by their last message (the least recently seen topic first)
+	order    *list.List
+	elements map[string]*list.Element
+
+	//a message will be passed to each handler of all matching subscriptions: but it should be counted only once
+	last mqtt.Message
+}
+
+func newTopicRegistry() *topicRegistry {
+	return &topicRegistry{
+		topics:   map[string]*topicStats{},
+		limit:    maxRegistryTopics,
+		order:    list.New(),
+		elements: map[string]*list.Element{},
+	}
+}
+
+func (r *topicRegistry) add(message mqtt.Message) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.last == message {
+		return
+	}
+	r.last = message
+
+	topic := message.Topic()
+	stats, ok := r.topics[topic]
+	if ok {
+		r.order.MoveToBack(r.elements[topic])
+	} else {
+		if len(r.topics) >= r.limit {
+			oldest := r.order.Remove(r.order.Front()).(string)
+			delete(r.topics, oldest)
+			delete(r.elements, oldest)
+		}
+
+		stats = &topicStats{}
+		r.topics[topic] = stats
+		r.elements[topic] = r.order.PushBack(topic)
+	}
+	stats.count++
+	stats.previous = stats.last
+	stats.last = message.Payload()
+	stats.lastSeen = timeNow()
+	stats.retained = message.Retained()
+}
+
+// matching returns a copy of the stats of all topics which are matching the given filter
+func (r *topicRegistry) matching(filter string) map[string]topicStats {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	result := map[string]topicStats{}
+	for topic, stats := range r.topics {
+		if topicMatches(filter, topic) {
+			result[topic] = *stats
+		}
+	}
+	return result
+}
+
+type topicNode struct {
+	name     string
+	total    uint64
+	stats    *topicStats
+	children map[string]*topicNode
+}
+
+func buildTopicTree(topics map[string]topicStats) *topicNode {
+	root := &topicNode{children: map[string]*topicNode{}}
+
+	for topic, stats := range topics {
+		stats := stats
+		node := root
+		node.total += stats.count
+
+		for _, level := range strings.Split(topic, "/") {
+			child, ok := node.children[level]
+			if !ok {
+				child = &topicNode{name: level, children: map[string]*topicNode{}}
+				node.children[level] = child
+			}
+			node = child
+			node.total += stats.count
+		}
+		node.stats = &stats
+	}
+
+	return root
+}
+
+// writeTopicTree writes the hierarchical topic tree with message counts, retained markers and payload previews
+func writeTopicTree(out io.Writer, topics map[string]topicStats) {
+	root := buildTopicTree(topics)
+
+	sb := strings.Builder{}
+	for i, child := range root.sortedChildren() {
+		child.write(&sb, "", "", i == len(root.children)-1)
+	}
+	out.Write([]byte(sb.String()))
+}
+
+func (n *topicNode) sortedChildren() []*topicNode {
+	children := make([]*topicNode, 0, len(n.children))
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].name < children[j].name
+	})
+	return children
+}
+
+func (n *topicNode) write(sb *strings.Builder, indent, branch string, last bool) {
+	sb.WriteString(indent + branch)
+
+	name := n.name
+	if name == "" {
+		name = `""`
+	}
+	sb.WriteString(fmt.Sprintf("%s (%d)", name, n.total))
+
+	if n.stats != nil {
+		if n.stats.retained {
+			sb.WriteString(" [retained]")
+		}
+		sb.WriteString(" " + payloadPreview(n.stats.last))
+	}
+	sb.WriteString("\n")
+
+	children := n.sortedChildren()
+	for i, child := range children {
+		childLast := i == len(children)-1
+
+		childBranch := "├── "
+		if childLast {
+			childBranch = "└── "
+		}
+		childIndent := indent
+		if branch != "" {
+			if last {
+				childIndent += "    "
+			} else {
+				childIndent += "│   "
+			}
+		}
+		child.write(sb, childIndent, childBranch, childLast)
+	}
+}
+
+func payloadPreview(payload []byte) string {
+	if !utf8.Valid(payload) {
+		return fmt.Sprintf("<%d bytes>", len(payload))
+	}
+
+	preview := []rune(string(payload))
+	if len(preview) > topicPreviewLength {
+		return strconv.Quote(string(preview[:topicPreviewLength])) + "…"
+	}
+	return strconv.Quote(string(preview))
+}
+
+// defaultTreeWindow is the duration of the subscription for the topic tree if there are no messages seen before
+const defaultTreeWindow = 2 * time.Second
+
+func (p *processor) handleTree(chain Chain) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%s\nUsage: "+commandTree+" [-w <duration>] [filter]", err.Error())
+		}
+	}()
+
+	filter := ""
+	var window time.Duration
+
+	for i := 0; i < len(chain.Commands[0].Arguments); i++ {
+		arg := chain.Commands[0].Arguments[i]
+
+		switch {
+		case arg == "-w":
+			if i+1 >= len(chain.Commands[0].Arguments) {
+				return errors.New("invalid arguments")
+			}
+			window, err = time.ParseDuration(chain.Commands[0].Arguments[i+1])
+			if err != nil || window <= 0 {
+				return errors.New("invalid window duration")
+			}
+			i++
+		case filter == "":
+			filter = arg
+		default:
+			return errors.New("invalid arguments")
+		}
+	}
+	if filter == "" {
+		filter = "#"
+	}
+
+	//prefer the messages which were already seen
+	if window == 0 {
+		if topics := p.topics.matching(filter); len(topics) > 0 {
+			writeTopicTree(p.out, topics)
+			return nil
+		}
+		window = defaultTreeWindow
+	}
+
+	topics, err := p.collectTopics(filter, window)
+	if err != nil {
+		return err
+	}
+	if len(topics) == 0 {
+		p.out.Write([]byte(fmt.Sprintf("no messages received on %s within %s\n", filter, window)))
+		return nil
+	}
+
+	writeTopicTree(p.out, topics)
+	return nil
+}
+
+// collectTopics subscribes the filter for the given duration and collects all received messages. The processing will
+// be blocked for that duration (like any other command which waits for its result).
+func (p *processor) collectTopics(filter string, window time.Duration) (map[string]topicStats, error) {
+	registry := newTopicRegistry()
+	err := p.subscribeTemporary(filter, 0, window, func(_ mqtt.Client, message mqtt.Message) {
+		registry.add(message)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return registry.matching(filter), nil
+}
@@ -0,0 +1,179 @@
+package io
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/mock/gomock"
+	mock_io "github.com/rainu/mqtt-shell/internal/io/mocks"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteTopicTree(t *testing.T) {
+	output := &bytes.Buffer{}
+
+	writeTopicTree(output, map[string]topicStats{
+		"home/kitchen/temp":  {count: 3, last: []byte("21.5"), retained: true},
+		"home/kitchen/light": {count: 1, last: []byte("on")},
+		"home/bath":          {count: 2, last: []byte(strings.Repeat("x", 50))},
+		"office/door":        {count: 1, last: []byte{0xff, 0xfe}},
+		"/root":              {count: 1, last: []byte("a\nb")},
+	})
+
+	assert.Equal(t, `"" (1)
+└── root (1) "a\nb"
+home (6)
+├── bath (2) "`+strings.Repeat("x", topicPreviewLength)+`"…
+└── kitchen (4)
+    ├── light (1) "on"
+    └── temp (3) [retained] "21.5"
+office (1)
+└── door (1) <2 bytes>
+`, output.String())
+}
+
+func TestProcessor_Process_tree_seen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, nil)
+
+	handler := toTest.observe("#", func(mqtt.Client, mqtt.Message) {})
+	handler(nil, testMessage(ctrl, "a/first", "1"))
+	handler(nil, testMessage(ctrl, "a/first", "2"))
+	handler(nil, testMessage(ctrl, "b/second", "3"))
+
+	toTest.Process(filledChan(commandTree + " a/#"))
+
+	assert.Equal(t, "a (2)\n└── first (2) \"2\"\n", output.String())
+}
+
+func TestProcessor_Process_tree_window(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).Times(2)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("a/#"), gomock.Eq(byte(0)), gomock.Any()).DoAndReturn(
+		func(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+			callback(nil, testMessage(ctrl, "a/first", "1", retainedTestMessage))
+			return mockToken
+		})
+	mockMqtt.EXPECT().Unsubscribe(gomock.Eq("a/#")).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	toTest.Process(filledChan(commandTree + " -w 10ms a/#"))
+
+	assert.Equal(t, "a (1)\n└── first (1) [retained] \"1\"\n", output.String())
+	assert.Empty(t, toTest.GetSubscriptions(), "the temporary subscription should not be registered")
+}
+
+func TestProcessor_Process_tree_tapExistingSubscription(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	//the existing subscription must not be replaced
+	mockMqtt := mock_io.NewMockClient(ctrl)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+	handler := toTest.observe("a/#", func(mqtt.Client, mqtt.Message) {})
+	toTest.subscribedTopics["a/#"] = subscription{callback: handler}
+	handler(nil, testMessage(ctrl, "a/before", "0"))
+
+	go func() {
+		assert.Eventually(t, func() bool {
+			toTest.taps.mutex.RLock()
+			defer toTest.taps.mutex.RUnlock()
+			return len(toTest.taps.handlers["a/#"]) == 1
+		}, 1*time.Second, 1*time.Millisecond)
+		handler(nil, testMessage(ctrl, "a/within", "1"))
+	}()
+	toTest.Process(filledChan(commandTree + " -w 100ms a/#"))
+
+	assert.Equal(t, "a (1)\n└── within (1) \"1\"\n", output.String())
+	assert.Empty(t, toTest.taps.handlers, "the tap should be removed afterwards")
+}
+
+func TestProcessor_Process_tree_nothingReceived(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(true).Times(2)
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("#"), gomock.Eq(byte(0)), gomock.Any()).Return(mockToken)
+	mockMqtt.EXPECT().Unsubscribe(gomock.Eq("#")).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	toTest.Process(filledChan(commandTree + " -w 10ms"))
+
+	assert.Equal(t, "no messages received on # within 10ms\n", output.String())
+}
+
+func TestProcessor_Process_tree_subscribeError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockToken := mock_io.NewMockToken(ctrl)
+	mockToken.EXPECT().Wait().Return(false)
+	mockToken.EXPECT().Error().Return(errors.New("someError"))
+	mockMqtt := mock_io.NewMockClient(ctrl)
+	mockMqtt.EXPECT().Subscribe(gomock.Eq("#"), gomock.Eq(byte(0)), gomock.Any()).Return(mockToken)
+
+	output := &bytes.Buffer{}
+	toTest := NewProcessor(output, mockMqtt)
+
+	toTest.Process(filledChan(commandTree + " -w 10ms"))
+
+	assert.Equal(t, "someError\nUsage: "+commandTree+" [-w <duration>] [filter]\n", output.String())
+}
+
+func TestProcessor_Process_tree_invalidArguments(t *testing.T) {
+	tests := []string{
+		commandTree + " -w",
+		commandTree + " -w abc",
+		commandTree + " -w -1s",
+		commandTree + " a/# b/#",
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestProcessor_Process_tree_invalidArguments_%d", i), func(t *testing.T) {
+			output := &bytes.Buffer{}
+			toTest := NewProcessor(output, nil)
+
+			toTest.Process(filledChan(test))
+
+			assert.Contains(t, output.String(), "\nUsage: "+commandTree+" [-w <duration>] [filter]\n")
+		})
+	}
+}
+
+func TestTopicRegistry_limit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	toTest := newTopicRegistry()
+	toTest.limit = 2
+
+	toTest.add(testMessage(ctrl, "a", "1"))
+	toTest.add(testMessage(ctrl, "b", "1"))
+	toTest.add(testMessage(ctrl, "a", "2"))
+	toTest.add(testMessage(ctrl, "c", "1"))
+
+	topics := toTest.matching("#")
+	assert.Len(t, topics, 2)
+	assert.Contains(t, topics, "a")
+	assert.Contains(t, topics, "c")
+	assert.NotContains(t, topics, "b", "the least recently seen topic should be removed")
+	assert.Equal(t, uint64(2), topics["a"].count)
+}
@@ -1,31 +1,77 @@
 package io
 
-import "io"
+import (
+	"bytes"
+	"io"
+	"sync"
+)
 
+// maxLineLength is the maximum length of a line which will be buffered by the prefixWriter
+const maxLineLength = 64 * 1024
+
+// prefixWriter writes each line with the given prefix. Incomplete lines will be buffered until
+// they are completed (or flushed). So the lines of different writers will not be interleaved.
 type prefixWriter struct {
-	Prefix        string
-	prefixWritten bool
-	Delegate      io.Writer
+	Prefix   string
+	Delegate io.Writer
+
+	buffer []byte
 }
 
 func (p *prefixWriter) Write(b []byte) (n int, err error) {
-	givenLen := len(b)
-	writePrefix := !p.prefixWritten
-	if writePrefix {
-		b2 := make([]byte, 0, len(b)+len(p.Prefix))
-		b2 = append(b2, []byte(p.Prefix)...)
-		b2 = append(b2, b...)
-		b = b2
-
-		p.prefixWritten = true
+	p.buffer = append(p.buffer, b...)
+
+	end := bytes.LastIndexByte(p.buffer, '\n')
+	if end == -1 {
+		if len(p.buffer) < maxLineLength {
+			return len(b), nil
+		}
+		//the line is too long: write it anyway
+		end = len(p.buffer) - 1
+	}
+
+	out := make([]byte, 0, end+1+len(p.Prefix))
+	for _, line := range bytes.SplitAfter(p.buffer[:end+1], []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		out = append(out, p.Prefix...)
+		out = append(out, line...)
+	}
+	if out[len(out)-1] != '\n' {
+		out = append(out, '\n')
 	}
+	p.buffer = append(p.buffer[:0], p.buffer[end+1:]...)
 
-	n, err = p.Delegate.Write(b)
-	if writePrefix && err == nil {
-		//in happy case we have to make sure that the correct amount of read bytes
-		//are returned -> otherwise this will cause many io trouble
-		n = givenLen
+	//write all lines at once so that they will not be interleaved with other writes
+	if _, err = p.Delegate.Write(out); err != nil {
+		return 0, err
 	}
 
-	return
+	//in happy case we have to make sure that the correct amount of read bytes
+	//are returned -> otherwise this will cause many io trouble
+	return len(b), nil
+}
+
+// Flush writes the remaining incomplete line
+func (p *prefixWriter) Flush() error {
+	if len(p.buffer) == 0 {
+		return nil
+	}
+
+	_, err := p.Write([]byte("\n"))
+	return err
+}
+
+// syncWriter serializes the writes of multiple routines
+type syncWriter struct {
+	mutex    sync.Mutex
+	delegate io.Writer
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.delegate.Write(b)
 }
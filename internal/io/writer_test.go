@@ -2,9 +2,11 @@ package io
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -22,5 +24,64 @@ func TestPrefixWriter_Write(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.EqualValues(t, len(sSource), n)
-	assert.Equal(t, toTest.Prefix+sSource, target.String())
+	assert.Equal(t, "", target.String(), "incomplete lines should be buffered")
+
+	assert.NoError(t, toTest.Flush())
+	assert.Equal(t, toTest.Prefix+sSource+"\n", target.String())
+}
+
+func TestPrefixWriter_Write_multiline(t *testing.T) {
+	target := bytes.NewBuffer([]byte{})
+
+	toTest := &prefixWriter{
+		Prefix:   "> ",
+		Delegate: target,
+	}
+
+	toTest.Write([]byte("first\nsec"))
+	toTest.Write([]byte("ond\n\nthird"))
+	assert.Equal(t, "> first\n> second\n> \n", target.String())
+
+	toTest.Flush()
+	toTest.Flush()
+	assert.Equal(t, "> first\n> second\n> \n> third\n", target.String())
+}
+
+func TestPrefixWriter_Write_longLine(t *testing.T) {
+	target := bytes.NewBuffer([]byte{})
+
+	toTest := &prefixWriter{
+		Prefix:   "> ",
+		Delegate: target,
+	}
+
+	toTest.Write(bytes.Repeat([]byte("a"), maxLineLength))
+	assert.Equal(t, maxLineLength+3, target.Len(), "too long lines should be written immediately")
+}
+
+func TestSyncWriter_concurrent(t *testing.T) {
+	target := bytes.NewBuffer([]byte{})
+	out := &syncWriter{delegate: target}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			pw := &prefixWriter{Prefix: fmt.Sprintf("%d | ", i), Delegate: out}
+			for j := 0; j < 100; j++ {
+				//write the line in multiple parts
+				pw.Write([]byte("some "))
+				pw.Write([]byte(fmt.Sprintf("line %d\n", j)))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(target.String(), "\n"), "\n")
+	assert.Len(t, lines, 1000)
+	for _, line := range lines {
+		assert.Regexp(t, `^[0-9] \| some line [0-9]+$`, line)
+	}
 }
@@ -0,0 +1,29 @@
+// Package topic contains the matching of mqtt topics which is shared by the shell and the embedded broker.
+package topic
+
+import "strings"
+
+// Matches checks if the given topic matches the given subscription filter (incl. wildcards)
+func Matches(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	//topics beginning with $ will not be matched by wildcards at first level
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "#" || filterLevels[0] == "+") {
+		return false
+	}
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
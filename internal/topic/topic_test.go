@@ -0,0 +1,35 @@
+package topic
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		filter   string
+		topic    string
+		expected bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/b", "a/b/c", false},
+		{"a/b/c", "a/b", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"+/+", "a/b", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b/c", true},
+		{"#", "$SYS/broker", false},
+		{"+/broker", "$SYS/broker", false},
+		{"$SYS/#", "$SYS/broker", true},
+	}
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestMatches_%d", i), func(t *testing.T) {
+			assert.Equal(t, test.expected, Matches(test.filter, test.topic))
+		})
+	}
+}